@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// how many of the latest samples of each chart series decide whether the system is idle
+	adaptiveWindow = 10
+
+	// a series is idle while its latest samples have a standard deviation of at most this fraction
+	// of their mean, or of 1 for series which sit near 0, e.g. an idle network
+	adaptiveIdleVariation = 0.25
+
+	// how much the sample interval is lengthened by each time every series stays idle for a window
+	adaptiveBackoff = 2
+)
+
+// Lengthens the sample interval while every chart is flat and shortens it again as soon as any of
+// them moves, so poptop samples lazily on an idle system but is still responsive when something
+// happens. The interval is kept between min and max.
+type adaptiveSampler struct {
+	min, max time.Duration
+
+	// the latest samples of each chart series
+	recent map[*BoundedSeries]*BoundedSeries
+
+	// samples since the interval last changed, it's only lengthened after a full window at the
+	// current interval
+	ticks int
+}
+
+func newAdaptiveSampler(min, max time.Duration) *adaptiveSampler {
+	return &adaptiveSampler{min: min, max: max, recent: map[*BoundedSeries]*BoundedSeries{}}
+}
+
+// Records the latest sample of each series and returns the interval to sample at next, given the
+// current one
+func (this *adaptiveSampler) Next(series []*BoundedSeries, current time.Duration) time.Duration {
+	idle := true
+	for _, s := range series {
+		recent, ok := this.recent[s]
+		if !ok {
+			recent = NewBoundedSeries(adaptiveWindow)
+			this.recent[s] = recent
+		}
+		recent.AddValue(s.Last())
+		if !isIdle(recent) {
+			idle = false
+		}
+	}
+	this.ticks++
+
+	next := current
+	if !idle {
+		next = this.min
+	} else if this.ticks >= adaptiveWindow {
+		next = time.Duration(float64(current) * adaptiveBackoff)
+	}
+	if next < this.min {
+		next = this.min
+	} else if next > this.max {
+		next = this.max
+	}
+
+	if next != current {
+		this.ticks = 0
+	}
+	return next
+}
+
+// Returns true if the series' samples vary little enough to call it idle
+func isIdle(recent *BoundedSeries) bool {
+	mean := getAvg(recent.validValues())
+	if math.IsNaN(mean) {
+		return true
+	}
+	return recent.StdDev() <= adaptiveIdleVariation*math.Max(math.Abs(mean), 1)
+}
+
+// Adjusts the sample clock after each sample until the context is done, see adaptiveSampler
+func (this *PoptopConfig) runAdaptiveSampling(ctx context.Context) {
+	sampler := newAdaptiveSampler(this.AdaptiveMin, this.AdaptiveMax)
+	this.spawn(func() {
+		periodicLive(ctx, this.sampleClock, func() error {
+			this.sampleClock.Set(sampler.Next(this.chartSeries.All(), this.CurrentSampleInterval()))
+			return nil
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveSampler(t *testing.T) {
+	sampler := newAdaptiveSampler(time.Second, 3*time.Second)
+	series := NewBoundedSeries(10)
+	all := []*BoundedSeries{series}
+
+	// a flat series lengthens the interval once it's been idle for a full window
+	interval := time.Second
+	for i := 0; i < adaptiveWindow-1; i++ {
+		series.AddValue(5)
+		if interval = sampler.Next(all, interval); interval != time.Second {
+			t.Fatalf("Expected the interval to wait for a full window but got %v after %d samples", interval, i+1)
+		}
+	}
+	series.AddValue(5)
+	if interval = sampler.Next(all, interval); interval != 2*time.Second {
+		t.Errorf("Expected an idle window to double the interval but got %v", interval)
+	}
+
+	// and it's capped at the maximum
+	for i := 0; i < adaptiveWindow; i++ {
+		series.AddValue(5)
+		interval = sampler.Next(all, interval)
+	}
+	if interval != 3*time.Second {
+		t.Errorf("Expected the interval to be capped at 3s but got %v", interval)
+	}
+
+	// small wobbles around 0 still count as idle
+	zero := NewBoundedSeries(10)
+	for i := 0; i < adaptiveWindow; i++ {
+		zero.AddValue(float64(i%2) * 0.2)
+		interval = sampler.Next([]*BoundedSeries{series, zero}, interval)
+	}
+	if interval != 3*time.Second {
+		t.Errorf("Expected a series wobbling near 0 to be idle but the interval went to %v", interval)
+	}
+
+	// any activity drops straight back to the minimum
+	series.AddValue(50)
+	if interval = sampler.Next(all, interval); interval != time.Second {
+		t.Errorf("Expected activity to drop the interval to the minimum but got %v", interval)
+	}
+}
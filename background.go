@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// How long to wait for the terminal to answer when asked for its background color
+const backgroundQueryTimeout = 200 * time.Millisecond
+
+// Returns true if the terminal has a light background, from COLORFGBG if it's set or otherwise
+// by asking the terminal. Returns false for a dark background or if neither says.
+func detectLightBackground() bool {
+	if light, ok := parseColorFgBg(os.Getenv("COLORFGBG")); ok {
+		return light
+	}
+	if light, ok := queryBackground(backgroundQueryTimeout); ok {
+		return light
+	}
+	return false
+}
+
+// Parses COLORFGBG as set by e.g. rxvt and Konsole, like "15;0" for white on black. The
+// background is the last field, one of the 16 ANSI colors, of which white (7) and the bright
+// colors other than gray (9 to 15) are light. Returns false for ok if it can't be parsed, e.g.
+// when the background is "default".
+func parseColorFgBg(value string) (light, ok bool) {
+	fields := strings.Split(value, ";")
+	bg, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || bg < 0 || bg > 15 {
+		return false, false
+	}
+	return bg == 7 || bg > 8, true
+}
+
+// Asks the terminal for its background color with the OSC 11 query, waiting at most timeout for
+// the answer. The query is followed by a request for the terminal's attributes, which every
+// terminal answers, so that a terminal which doesn't support OSC 11 is given up on straight away
+// rather than its answer being read as key presses later.
+func queryBackground(timeout time.Duration) (light, ok bool) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, false
+	}
+	defer tty.Close()
+
+	// a tty which can't time out, e.g. on MacOS, could leave us waiting forever
+	if err := tty.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false, false
+	}
+
+	// tty.Fd() would switch the tty to blocking reads and lose the deadline
+	conn, err := tty.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+	var state *term.State
+	conn.Control(func(fd uintptr) {
+		state, err = term.MakeRaw(int(fd))
+	})
+	if err != nil {
+		return false, false
+	}
+	defer conn.Control(func(fd uintptr) {
+		term.Restore(int(fd), state)
+	})
+
+	if _, err := tty.WriteString("\x1b]11;?\x1b\\\x1b[c"); err != nil {
+		return false, false
+	}
+
+	reply := ""
+	buf := make([]byte, 256)
+	for !strings.Contains(reply, "\x1b[?") || !strings.HasSuffix(reply, "c") {
+		n, err := tty.Read(buf)
+		reply += string(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	return parseBackgroundReply(reply)
+}
+
+// Parses the terminal's answer to the OSC 11 query, e.g. "\x1b]11;rgb:ffff/ffff/ffff\x1b\\" for
+// white, where each component has 1 to 4 hex digits. The background is light if its luminance is
+// over half.
+func parseBackgroundReply(reply string) (light, ok bool) {
+	start := strings.Index(reply, "]11;rgb:")
+	if start == -1 {
+		return false, false
+	}
+	color := reply[start+len("]11;rgb:"):]
+	if end := strings.IndexAny(color, "\x07\x1b"); end != -1 {
+		color = color[:end]
+	}
+
+	components := strings.Split(color, "/")
+	if len(components) != 3 {
+		return false, false
+	}
+	rgb := make([]float64, 3)
+	for i, component := range components {
+		value, err := strconv.ParseUint(component, 16, 16)
+		if err != nil || len(component) == 0 || len(component) > 4 {
+			return false, false
+		}
+		rgb[i] = float64(value) / (math.Pow(16, float64(len(component))) - 1)
+	}
+
+	luminance := 0.2126*rgb[0] + 0.7152*rgb[1] + 0.0722*rgb[2]
+	return luminance > 0.5, true
+}
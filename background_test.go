@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestParseColorFgBg(t *testing.T) {
+	cases := []struct {
+		value     string
+		light, ok bool
+	}{
+		{"15;0", false, true},
+		{"0;15", true, true},
+		{"0;7", true, true},
+		{"7;8", false, true},
+		{"12;default;0", false, true},
+		{"0;default", false, false},
+		{"", false, false},
+		{"0;99", false, false},
+	}
+	for _, c := range cases {
+		if light, ok := parseColorFgBg(c.value); light != c.light || ok != c.ok {
+			t.Errorf("Expected COLORFGBG=%q to give %v, %v but got %v, %v", c.value, c.light, c.ok, light, ok)
+		}
+	}
+}
+
+func TestParseBackgroundReply(t *testing.T) {
+	cases := []struct {
+		reply     string
+		light, ok bool
+	}{
+		{"\x1b]11;rgb:ffff/ffff/ffff\x1b\\\x1b[?62;22c", true, true},
+		{"\x1b]11;rgb:1e1e/1e1e/2e2e\x07\x1b[?1;2c", false, true},
+		{"\x1b]11;rgb:fd/f6/e3\x07", true, true},
+		{"\x1b]11;rgb:0/0/f\x07", false, true},
+		{"\x1b[?62;22c", false, false},
+		{"\x1b]11;rgb:ffff/ffff\x07", false, false},
+		{"\x1b]11;rgb:gggg/ffff/ffff\x07", false, false},
+		{"", false, false},
+	}
+	for _, c := range cases {
+		if light, ok := parseBackgroundReply(c.reply); light != c.light || ok != c.ok {
+			t.Errorf("Expected %q to give %v, %v but got %v, %v", c.reply, c.light, c.ok, light, ok)
+		}
+	}
+}
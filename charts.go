@@ -2,18 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mum4k/termdash/cell"
 	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/container/grid"
 	"github.com/mum4k/termdash/linestyle"
 	"github.com/mum4k/termdash/widgetapi"
 	"github.com/mum4k/termdash/widgets/linechart"
+	"github.com/mum4k/termdash/widgets/text"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/net"
 )
 
 var (
@@ -24,22 +30,108 @@ var (
 	ColorHot1         = cell.ColorNumber(197)
 	ColorHot2         = cell.ColorNumber(214)
 	ColorHot3         = cell.ColorNumber(39)
+	ColorHot4         = cell.ColorNumber(48)
 	ColorRead         = ColorHot3
 	ColorWrite        = ColorHot1
+
+	// the border of the widget focused by Tab or a click, in the title color so that themes recolor it too
+	ColorWidgetFocused = ColorWidgetTitle
+
+	// used where a chart shows a second set of reads and writes, e.g. IPv6 traffic
+	ColorReadAlt  = ColorHot4
+	ColorWriteAlt = ColorHot2
+
+	// the 1min load, which turns hot once there's more load than CPUs to run it
+	ColorLoadOk         = ColorHot4
+	ColorLoadOverloaded = ColorHot1
+
+	// network errors, which should stand out from everything else
+	ColorError = cell.ColorNumber(196)
+
+	// processes which have just entered the top of a top list
+	ColorNewProcess = ColorHot4
+
+	// darker shades of the series colors, for drawing each series' average line with --averages
+	dimColors = map[cell.Color]cell.Color{
+		ColorHot1: cell.ColorNumber(89),
+		ColorHot2: cell.ColorNumber(130),
+		ColorHot3: cell.ColorNumber(25),
+		ColorHot4: cell.ColorNumber(29),
+	}
 )
 
+// Returns the darker shade of a series color, or the border gray for colors without one
+func dimColor(color cell.Color) cell.Color {
+	if dim, ok := dimColors[color]; ok {
+		return dim
+	}
+	return ColorWidgetBorder
+}
+
+// The series whose colors can be set with --color, named like the series in --json output, e.g. recv or time_wait
+var seriesColorNames = map[string]bool{
+	"load1": true, "load5": true, "load15": true,
+	"min": true, "avg": true, "max": true,
+	"user": true, "system": true, "iowait": true,
+	"sent": true, "recv": true, "errors": true, "drops": true,
+	"v4_sent": true, "v4_recv": true, "v6_sent": true, "v6_recv": true,
+	"read": true, "write": true,
+	"established": true, "time_wait": true, "listen": true,
+	"ctxt": true, "intr": true,
+	"util": true, "vram": true,
+	"used": true, "free": true,
+	"handles": true, "inodes": true,
+}
+
+func sortedSeriesColorNames() []string {
+	names := []string{}
+	for name := range seriesColorNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the color to draw a series in, which is its --color override if there is one or else
+// fallback. Every chart resolves its series colors through here so the overrides apply everywhere
+// a series is drawn, e.g. the sent color applies to both throughput and packets.
+func (this *PoptopConfig) SeriesColor(series string, fallback cell.Color) cell.Color {
+	if color, ok := this.SeriesColors[series]; ok {
+		return color
+	}
+	return fallback
+}
+
 type Widgets [][]container.Option
 
 func newWidgetCache() map[int][]container.Option {
 	return map[int][]container.Option{}
 }
 
+// Stops the widgets which only run while they're in the layout once they've been removed from it,
+// dropping them from the cache so they're started again if they're added back
+func stopRemovedWidgets(config *PoptopConfig, cache map[int][]container.Option) {
+	enabled := map[int]bool{}
+	for _, widget := range config.Widgets {
+		enabled[widget] = true
+	}
+
+	for widget, cancel := range config.widgetCancels {
+		if !enabled[widget] {
+			cancel()
+			delete(config.widgetCancels, widget)
+			delete(cache, widget)
+		}
+	}
+}
+
 // uses a cache to either initialize or retrieve widgets in the configured order and passes them back as []container.Option`s
-func getWidgets(ctx context.Context, config *PoptopConfig, cache map[int][]container.Option) (Widgets, error) {
+func getWidgets(ctx context.Context, root *container.Container, config *PoptopConfig, cache map[int][]container.Option) (Widgets, error) {
 	var topCpu []container.Option
 	var topMem []container.Option
 	var err error
 	widgets := [][]container.Option{}
+	stopRemovedWidgets(config, cache)
 
 	for _, widgetRef := range config.Widgets {
 
@@ -50,33 +142,81 @@ func getWidgets(ctx context.Context, config *PoptopConfig, cache map[int][]conta
 		}
 
 		var newWidget []container.Option
+		sampling := config.widgetSampling(widgetRef)
 
 		switch widgetRef {
 		case WidgetHelp:
 			newWidget, err = newHelpBox(ctx, config)
 
 		case WidgetCPULoad:
-			newWidget, err = newLoadChart(ctx, config)
+			newWidget, err = newLoadChart(ctx, root, config, sampling, sampleSource(config, "cpuLoad", newLoadCollector()))
 
 		case WidgetCPUPerc:
-			newWidget, err = newCpuChart(ctx, config)
+			if config.CpuBreakdown {
+				newWidget, err = newCpuTimesChart(ctx, root, config, sampling, sampleSource(config, "cpuTimes", newCpuTimesCollector()), iowaitReported)
+				break
+			}
+
+			collector := sampleSource(config, "cpuPerc", newCpuCollector())
+			if config.CpuIdle {
+				collector = newCpuIdleCollector(collector)
+			}
+			newWidget, err = newCpuChart(ctx, root, config, sampling, collector)
 
 		case WidgetNetworkIO:
-			newWidget, err = newNetChart(ctx, config)
+			newWidget, err = newNetChart(ctx, root, config, sampling)
 
 		case WidgetDiskIOPS:
-			newWidget, err = newDiskIOPSChart(ctx, config)
+			source := chooseDiskSource(ctx, config)
+			newWidget, err = newDiskIOPSChart(ctx, root, config, sampling, source, counterSource(config, "diskIOPS", newDiskIOPSCollector(sampling.Interval, source.ops), source.ops, 1))
 
 		case WidgetDiskIO:
-			newWidget, err = newDiskIOChart(ctx, config)
+			source := chooseDiskSource(ctx, config)
+			newWidget, err = newDiskIOChart(ctx, root, config, sampling, source, counterSource(config, "diskIO", newDiskIOCollector(source.bytes, time.Now), source.bytes, 1024))
+
+		case WidgetConnections:
+			newWidget, err = newConnectionsChart(ctx, root, config, sampling, sampleSource(config, "connections", newConnectionsCollector()))
+
+		case WidgetSwitches:
+			newWidget, err = newSwitchesChart(ctx, root, config, sampling, sampleSource(config, "switches", primeRate(config, newSwitchesCollector(switchCounters, time.Now))))
+
+		case WidgetDiskLatency:
+			newWidget, err = newDiskLatencyChart(ctx, root, config, sampling, sampleSource(config, "diskLatency", primeRate(config, newDiskLatencyCollector(diskLatencyCounters))))
+
+		case WidgetDiskSpace:
+			newWidget, err = newDiskSpaceChart(ctx, root, config, sampling, sampleSource(config, "diskSpace", newDiskSpaceCollector(config.DiskSpacePath)))
+
+		case WidgetFileLimits:
+			newWidget, err = newFileLimitsChart(ctx, root, config, sampling, sampleSource(config, "fileLimits", newFileLimitsCollector(fileHandleCounts, fullestInodeUsage)))
+
+		case WidgetHostInfo:
+			newWidget, err = newHostInfoBox(ctx, config)
+
+		case WidgetOverview:
+			newWidget, err = newOverviewBox(ctx, config)
+
+		case WidgetTopDisk:
+			newWidget, err = newTopDiskBox(ctx, config)
+
+		case WidgetTopNet:
+			// nethogs captures packets for as long as it runs, so it's stopped when the widget's removed
+			widgetCtx, cancel := context.WithCancel(ctx)
+			config.widgetCancels[WidgetTopNet] = cancel
+			newWidget, err = newTopNetBox(widgetCtx, config)
+
+		case WidgetTopFiles:
+			newWidget, err = newTopFilesBox(ctx, config)
+
+		case WidgetGPU:
+			newWidget, err = newGpuChart(ctx, root, config, sampling, sampleSource(config, "gpu", newGpuCollector(config.CommandTimeout)))
 
 		case WidgetTopCPU:
-			topCpu, topMem, err = newTopBoxes(ctx, config)
+			topCpu, topMem, err = newTopBoxes(ctx, root, config)
 			cache[WidgetTopMem] = topMem
 			newWidget = topCpu
 
 		case WidgetTopMem:
-			topCpu, topMem, err = newTopBoxes(ctx, config)
+			topCpu, topMem, err = newTopBoxes(ctx, root, config)
 			cache[WidgetTopCPU] = topCpu
 			newWidget = topMem
 		}
@@ -96,13 +236,121 @@ func getWidgets(ctx context.Context, config *PoptopConfig, cache map[int][]conta
 	return widgets, nil
 }
 
-func formatLabels(config *PoptopConfig, xIndexToLabel func(n int) string) map[int]string {
-	labels := map[int]string{}
+// Returns a function that builds the X axis labels for a chart sampled by sampling, which charts
+// call each time they're given new values so that the labels follow changes to the sample interval
+// at runtime. With clock labels turned on the labels are wall clock times rather than built by xIndexToLabel.
+// The points closest to the markers made by pressing m are labelled with the markers instead.
+func formatLabels(config *PoptopConfig, sampling *chartSampling, xIndexToLabel func(n int) string) func() map[int]string {
+	start, startTicks := time.Now(), sampling.clock.Ticks()
+
+	return func() map[int]string {
+		now, interval := time.Now(), sampling.PointInterval()
+
+		var labels map[int]string
+		if config.ClockLabels {
+			labels = clockLabels(start, now, sampling.numSamples, interval)
+		} else {
+			labels = map[int]string{}
+			for i := 0; i < sampling.numSamples; i++ {
+				labels[i] = xIndexToLabel(i)
+			}
+		}
+
+		markLabels(labels, config.markers.Get(config.sampleClock.Ticks()), sampling, startTicks)
+		return labels
+	}
+}
+
+// The fewest blank cells left between X axis labels when they're spaced out to fit the chart
+const xLabelGap = 4
+
+// The label given to points which aren't labelled, termdash draws an empty label as "NaN"
+const blankLabel = " "
+
+// The fewest cells termdash leaves between X axis labels
+const termdashLabelGap = 3
+
+// How many cells termdash moves along the X axis after each blank label before trying to place
+// the next, the label itself and termdash's fewest cells between labels
+const blankLabelSpacing = len(blankLabel) + termdashLabelGap
+
+// Returns the labels for numPoints points drawn across width cells, keeping the labels of every
+// nth point so they're evenly spaced. n is every, or if that's 0 the fewest points which leave
+// xLabelGap cells between labels. termdash labels whichever point is under each cell it tries and
+// falls back to the point's index for points without a label, so the others are blanked rather
+// than left out, and each kept label also keeps the next few to be sure termdash tries one of them.
+// Marker labels are always kept, see markerSpan.
+func thinLabels(labels map[int]string, numPoints, width, every int) map[int]string {
+	if numPoints < 2 || width <= 0 {
+		return labels
+	}
+	cellsPerPoint := float64(width) / float64(numPoints-1)
+
+	labelLen := 0
+	for _, label := range labels {
+		labelLen = max(labelLen, len(label))
+	}
+	if every <= 0 {
+		every = int(math.Ceil(float64(labelLen+xLabelGap) / cellsPerPoint))
+	}
+	if every <= 1 {
+		return labels
+	}
+	cover := min(every, int(math.Ceil(float64(blankLabelSpacing)/cellsPerPoint)))
+
+	thinned := map[int]string{}
+	for i := 0; i < numPoints; i++ {
+		thinned[i] = blankLabel
+		if i%every < cover {
+			thinned[i] = labels[i]
+		}
+	}
+
+	span, padding := markerSpan(labelLen, cellsPerPoint)
+	for i := 0; i < numPoints; i++ {
+		if label := labels[i]; isMarkerLabel(label) {
+			padded := label + strings.Repeat(" ", max(0, padding-len(label)))
+			for j := max(0, i-span+1); j <= i; j++ {
+				thinned[j] = padded
+			}
+		}
+	}
+	return thinned
+}
+
+// Returns how many points up to a marker are given its label, and how long the label is padded to.
+// After each label termdash moves along the X axis by at most the longest label and its gap, so a
+// marker across that many cells can't be skipped, and padding it to as long stops termdash drawing
+// it twice. The marker leads up to its point so it fits at the right edge of the chart, where new
+// markers are made.
+func markerSpan(labelLen int, cellsPerPoint float64) (int, int) {
+	span := int(math.Ceil(float64(labelLen+termdashLabelGap) / cellsPerPoint))
+	padding := int(math.Ceil(float64(span)*cellsPerPoint)) - termdashLabelGap
+	return span, padding
+}
 
-	for i := 0; i < config.NumSamples; i++ {
-		labels[i] = xIndexToLabel(i)
+// Returns when the first of nSamples points spaced interval apart was sampled, which is start until
+// the chart fills up, then the newest point is now
+func firstPointTime(start, now time.Time, nSamples int, interval time.Duration) time.Time {
+	first := now.Add(-time.Duration(nSamples-1) * interval)
+	if first.Before(start) {
+		return start
 	}
+	return first
+}
+
+// The format of X axis labels showing the time of day
+const clockLabelFormat = "15:04:05"
 
+// Labels nSamples points spaced interval apart with the time of day each was sampled. Until the
+// chart fills up the points start at start, then the newest point is now.
+func clockLabels(start, now time.Time, nSamples int, interval time.Duration) map[int]string {
+	first := firstPointTime(start, now, nSamples, interval)
+
+	labels := map[int]string{}
+	for i := 0; i < nSamples; i++ {
+		labels[i] = first.Add(time.Duration(i) * interval).Format(clockLabelFormat)
+	}
 	return labels
 }
 
@@ -114,6 +362,117 @@ func formatNoPoint(n float64) string {
 	return fmt.Sprintf("%.0f", n)
 }
 
+// Formats large values with an SI suffix, e.g. 1234567 as "1.2M", for cumulative counters which
+// quickly outgrow the Y axis labels
+func formatMagnitude(n float64) string {
+	return formatUnits(n, 1000, []string{"", "K", "M", "G", "T", "P"})
+}
+
+// Formats values compactly with a suffix, e.g. 125000 as "125k" and 1500000 as "1.5M", for Y axis
+// labels which are easier to scan without every digit. Values under 10 keep one decimal place.
+func formatCompact(n float64) string {
+	n, unit := scaleUnits(n, 1000, []string{"", "k", "M", "G", "T", "P"}, roundCompact)
+	return strconv.FormatFloat(roundCompact(n), 'f', -1, 64) + unit
+}
+
+// Formats n in the unit that suits it, where each of units is base times the one before, e.g.
+// 1536 with base 1024 and units B, KiB and so on as "1.5KiB". Scaled values get one decimal place.
+func formatUnits(n, base float64, units []string) string {
+	scaled, unit := scaleUnits(n, base, units, func(n float64) float64 {
+		return math.Round(n*10) / 10
+	})
+	if unit == units[0] {
+		return fmt.Sprintf("%.0f%s", n, unit)
+	}
+	return fmt.Sprintf("%.1f%s", scaled, unit)
+}
+
+// Divides n by base until it's under base or it's in the last of units, returning it with its unit.
+// It's compared after rounding as it'll be shown, so e.g. 999950 rolls over to 1M rather than 1000k.
+func scaleUnits(n, base float64, units []string, round func(float64) float64) (float64, string) {
+	i := 0
+	for math.Abs(round(n)) >= base && i < len(units)-1 {
+		n /= base
+		i++
+	}
+	return n, units[i]
+}
+
+// Rounds to one decimal place under 10 and to a whole number otherwise
+func roundCompact(n float64) float64 {
+	if math.Abs(n) < 10 {
+		n = math.Round(n*10) / 10
+	} else {
+		n = math.Round(n)
+	}
+	// avoid "-0" for small negative values
+	if n == 0 {
+		return 0
+	}
+	return n
+}
+
+// Returns the name, Y axis format and title value format of a chart of counters. A chart of rates
+// is named rateName and labels its axis compactly unless precise labels were asked for, a chart of
+// cumulative totals is named totalName.
+func counterChart(config *PoptopConfig, rateName, totalName string) (string, linechart.ValueFormatter, linechart.ValueFormatter) {
+	if config.Cumulative {
+		return totalName, formatMagnitude, formatMagnitude
+	}
+	if config.PreciseAxis {
+		return rateName, formatNoPoint, formatNoPoint
+	}
+	return rateName, formatCompact, formatNoPoint
+}
+
+// The bits in a KiB, which --bits charts network throughput in
+const bitsPerKiB = 1024 * 8
+
+// Returns the name, Y axis format and title value format of a network throughput chart named
+// prefix, along with its collector. With --bits the chart is in bits rather than KiB, so the
+// collector's KiB samples are converted as they're charted, while recordings, --serve and the
+// freshly sampled values elsewhere stay in KiB.
+func netCounterChart(config *PoptopConfig, prefix string, collector Collector) (string, linechart.ValueFormatter, linechart.ValueFormatter, Collector) {
+	if !config.Bits {
+		name, axisFormat, format := counterChart(config, prefix+" (KiB/s)", prefix+" (KiB)")
+		return name, axisFormat, format, collector
+	}
+
+	name, axisFormat, format := counterChart(config, prefix+" (bit/s)", prefix+" (bits)")
+	if !config.Cumulative {
+		format = formatBitRate
+	}
+	bits := CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return values, err
+		}
+		// a copy, as the samples may be shared with the recording or --serve
+		scaled := make([]float64, len(values))
+		for i, value := range values {
+			scaled[i] = value * bitsPerKiB
+		}
+		return scaled, nil
+	})
+	return name, axisFormat, format, bits
+}
+
+// Formats bits per second in the SI unit that suits it, e.g. 1500 as "1.5Kbps" and 940000000 as
+// "940.0Mbps", the way network links are rated
+func formatBitRate(n float64) string {
+	return formatUnits(n, 1000, []string{"bps", "Kbps", "Mbps", "Gbps", "Tbps", "Pbps"})
+}
+
+// Formats a number of bytes in the binary unit that suits it, e.g. 1536 as "1.5KiB" and
+// 5583457485 as "5.2GiB"
+func formatBytes(n float64) string {
+	return formatUnits(n, 1024, []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"})
+}
+
+func formatMillis(n float64) string {
+	return fmt.Sprintf("%.1fms", n)
+}
+
 func formatPercent(n float64) string {
 	return fmt.Sprintf("%.0f%%", n)
 }
@@ -129,379 +488,1366 @@ func newLinechart(opts ...linechart.Option) (*linechart.LineChart, error) {
 	return linechart.New(mergedOpts...)
 }
 
-func makeContainer(widget widgetapi.Widget, title *cell.RichTextString) []container.Option {
-	return []container.Option{container.Border(linestyle.Round),
+// The drawing surface of a chart, a line chart by default or a stack of sparklines in compact mode
+type chartWidget interface {
+	widgetapi.Widget
+
+	// Sets the values of the named series, drawn in the given color
+	Series(name string, values []float64, color cell.Color) error
+}
+
+// A line chart which labels every series with the same x-axis labels. Series with more values than
+// the chart has room for are averaged down to one value per column.
+type lineChart struct {
+	*linechart.LineChart
+	xLabels func() map[int]string
+
+	// values above this are drawn at it rather than rescaling the Y axis, 0 for no clipping
+	clipY float64
+
+	// plot values on a log scale, see toLogScale
+	logScale bool
+
+	// draw a flat line in a dimmer color at the average of each series' visible values
+	averages bool
+
+	// label every nth sample on the X axis, 0 to space the labels out to fit the chart's width
+	labelEvery int
+}
+
+func (this *lineChart) Series(name string, values []float64, color cell.Color) error {
+	if this.averages {
+		// averaged every time the series is set so the line follows the window as it scrolls
+		if avg, ok := seriesAvg(values); ok {
+			flat := make([]float64, len(values))
+			for i := range flat {
+				flat[i] = avg
+			}
+
+			// series are drawn in name order, so this puts the average beneath the values
+			if err := this.series("0_avg_"+name, flat, dimColor(color)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return this.series(name, values, color)
+}
+
+// Fills the area between two series by drawing bandLines evenly spaced lines from lower to upper
+// in color, as termdash can't fill areas. The edges are drawn in lowerColor and upperColor. Unlike
+// Series no average lines are drawn, as the band already shows the spread of the values.
+func (this *lineChart) Band(name string, lower, upper []float64, color, lowerColor, upperColor cell.Color) error {
+	lines := bandValues(lower, upper, bandLines)
+	for i, values := range lines {
+		lineColor := color
+		if i == 0 {
+			lineColor = lowerColor
+		} else if i == len(lines)-1 {
+			lineColor = upperColor
+		}
+
+		if err := this.series(fmt.Sprintf("%s%02d", name, i), values, lineColor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// How many lines a chart draws to fill a band, including its edges
+const bandLines = 10
+
+// Returns n series spaced evenly from lower to upper, the first being lower and the last upper
+func bandValues(lower, upper []float64, n int) [][]float64 {
+	lines := make([][]float64, n)
+	for i := range lines {
+		frac := float64(i) / float64(n-1)
+		lines[i] = make([]float64, len(lower))
+		for j := range lower {
+			lines[i][j] = lower[j] + (upper[j]-lower[j])*frac
+		}
+	}
+	return lines
+}
+
+// Draws a series after clipping, log scaling and averaging it down to the chart's width
+func (this *lineChart) series(name string, values []float64, color cell.Color) error {
+	if this.clipY > 0 || this.logScale {
+		scaled := make([]float64, len(values))
+		for i, v := range values {
+			scaled[i] = this.scale(v)
+		}
+		values = scaled
+	}
+
+	// the capacity is from the last draw, so is zero until the chart is first drawn
+	xLabels := this.xLabels()
+	width, every := this.ValueCapacity(), this.labelEvery
+	if width > 0 && len(values) > width {
+		buckets, size := bucketValues(values, width)
+		if every > 0 {
+			every = max(1, every/size)
+		}
+
+		values = buckets
+		bucketLabels := map[int]string{}
+		for i := range buckets {
+			if label, ok := xLabels[i*size]; ok {
+				bucketLabels[i] = label
+			}
+
+			// markers are kept wherever they fall in the bucket
+			for j := i * size; j < (i+1)*size; j++ {
+				if isMarkerLabel(xLabels[j]) {
+					bucketLabels[i] = xLabels[j]
+				}
+			}
+		}
+		xLabels = bucketLabels
+	}
+	if width > 0 {
+		// the capacity is in braille pixels, two to a cell
+		xLabels = thinLabels(xLabels, len(values), width/2, every)
+	}
+
+	return this.LineChart.Series(name, values,
+		linechart.SeriesCellOpts(cell.FgColor(color)),
+		linechart.SeriesXLabels(xLabels),
+	)
+}
+
+// Returns a value as it's plotted, clipped to clipY and on a log scale if either is set
+func (this *lineChart) scale(value float64) float64 {
+	if this.clipY > 0 {
+		value = math.Min(value, this.clipY)
+	}
+	if this.logScale {
+		value = toLogScale(value)
+	}
+	return value
+}
+
+// Returns the average of the values which aren't NaN gaps, false if there are none
+func seriesAvg(values []float64) (float64, bool) {
+	valid := make([]float64, 0, len(values))
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			valid = append(valid, v)
+		}
+	}
+	if len(valid) == 0 {
+		return 0, false
+	}
+	return getAvg(valid), true
+}
+
+// Log scale charts plot log10(value+1), which keeps both small and large values readable and maps
+// 0 to 0. Values below zero can't be plotted on a log scale so they're drawn at 0. NaN gaps stay gaps.
+func toLogScale(value float64) float64 {
+	if value <= 0 {
+		return 0
+	}
+	return math.Log10(value + 1)
+}
+
+// The inverse of toLogScale, for labelling a log scale Y axis with real values
+func fromLogScale(value float64) float64 {
+	return math.Pow(10, value) - 1
+}
+
+// The upper bound of the Y axis for percentage charts, so they span 0-100% rather than scaling to
+// the values seen so far
+const percentMax = 100
+
+// Passed to newChart for charts which aren't a widget of their own, so no per-widget settings apply
+const noWidget = -1
+
+// Creates the chart for a widget. Unless zero anchoring is turned off the Y axis starts at zero, and
+// if yMax is above zero then the axis spans 0 to yMax, only growing if a value exceeds it. If a max Y
+// has been configured for the widget then the axis is fixed at 0 to that max and larger values are clipped.
+func newChart(config *PoptopConfig, widget int, yFormat linechart.ValueFormatter, xLabels func() map[int]string, yMax float64) (chartWidget, error) {
+	if config.Compact {
+		return newSparkStack(), nil
+	}
+
+	maxY, clip := config.MaxY[widget]
+	if clip {
+		yMax = maxY
+	}
+
+	// a log scale chart's Y axis is in log space, so the max is transformed the same way as the
+	// values and the labels are transformed back
+	logScale := config.LogScale[widget]
+	scaledMax := yMax
+	if logScale {
+		scaledMax = toLogScale(yMax)
+		format := yFormat
+		yFormat = func(value float64) string {
+			return format(fromLogScale(value))
+		}
+	}
+
+	opts := []linechart.Option{linechart.YAxisFormattedValues(yFormat)}
+	if clip {
+		opts = append(opts, linechart.YAxisCustomScale(0, scaledMax))
+	} else if !config.ZeroAnchor {
+		opts = append(opts, linechart.YAxisAdaptive())
+	} else if yMax > 0 {
+		opts = append(opts, linechart.YAxisCustomScale(0, scaledMax))
+	}
+
+	lc, err := newLinechart(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	chart := &lineChart{LineChart: lc, xLabels: xLabels, logScale: logScale, averages: config.ShowAverages,
+		labelEvery: config.LabelEvery}
+	if clip {
+		chart.clipY = yMax
+	}
+	return chart, nil
+}
+
+// Creates the container options for one of the widget's containers, with a titled border in the
+// widget's line style, the title goes with the border if the style is linestyle.None. The border
+// turns the title color while the container has the keyboard focus.
+func makeContainer(widget widgetapi.Widget, title *cell.RichTextString, config *PoptopConfig, ref int) []container.Option {
+	watcher := newFocusWatcher(widget, config, ref)
+	return placeWatcher(watcher, title, fmt.Sprintf("widget%p", watcher))
+}
+
+// Creates the container options for a widget whose border title will change after creation,
+// along with a function that replaces the title, e.g. to show the latest sampled values. The
+// container is given an ID so the title can be updated in place through the root container.
+func makeDynamicContainer(root *container.Container, id string, widget widgetapi.Widget, title *cell.RichTextString, config *PoptopConfig, ref int) ([]container.Option, func(*cell.RichTextString)) {
+	opts := placeWatcher(newFocusWatcher(widget, config, ref), title, id)
+
+	setTitle := func(newTitle *cell.RichTextString) {
+		// this fails if the widget isn't currently part of the layout, in which case
+		// there's nothing to update
+		root.Update(id, container.RichBorderTitle(newTitle))
+	}
+
+	return opts, setTitle
+}
+
+// Returns an error reporter for goPeriodicLive which shows a chart's error at the end of its title,
+// until the next sample which succeeds puts the title back
+func titleErrors(setTitle func(*cell.RichTextString), makeTitle func() *cell.RichTextString) func(error) {
+	return func(err error) {
+		setTitle(makeTitle().
+			SetFgColor(ColorHot1).
+			AddText(strings.TrimSpace(err.Error()) + " ").
+			ResetColor())
+	}
+}
+
+// Returns an error reporter for goPeriodic which shows a text widget's error in place of its text,
+// until the next update which succeeds replaces it
+func textErrors(textBox *text.Text) func(error) {
+	return func(err error) {
+		textBox.Write(" "+strings.TrimSpace(err.Error()), text.WriteReplace())
+	}
+}
+
+// Places the watched widget in a container with the given ID, which applyLayout puts the focus
+// back on by
+func placeWatcher(watcher *focusWatcher, title *cell.RichTextString, id string) []container.Option {
+	watcher.id = id
+	return append(borderOptions(watcher.config, watcher.widget, title), container.PlaceWidget(watcher), container.ID(id))
+}
+
+// Returns the options for a container's titled border in the widget's line style, for a widget
+// whose container holds several others, e.g. the Overview's rows
+func borderOptions(config *PoptopConfig, ref int, title *cell.RichTextString) []container.Option {
+	return []container.Option{container.Border(config.BorderStyle(ref)),
 		container.BorderColor(ColorWidgetBorder),
-		container.FocusedColor(ColorWidgetBorder),
+		container.FocusedColor(ColorWidgetFocused),
 		container.TitleColor(ColorWidgetTitle),
 		container.TitleFocusedColor(ColorWidgetTitle),
-		container.RichBorderTitle(title),
-		container.PlaceWidget(widget)}
+		container.RichBorderTitle(title)}
+}
+
+// Creates the container options for one of several borderless widgets inside a widget's container,
+// which highlight their top row when focused as the border around them is another container's
+func makeInnerContainer(widget widgetapi.Widget, config *PoptopConfig, ref int) []container.Option {
+	watcher := newFocusWatcher(widget, config, ref)
+	watcher.inner = true
+	watcher.id = fmt.Sprintf("widget%p", watcher)
+	return []container.Option{container.Border(linestyle.None), container.PlaceWidget(watcher), container.ID(watcher.id)}
+}
+
+// A labelled entry in a chart title, colored to match its series
+type titleEntry struct {
+	label  string
+	color  cell.Color
+	series *BoundedSeries
+}
+
+// Builds a chart title like " CPU Load (1min: 2.3, 5min: 1.8, 15min: 1.5) " where each entry shows the
+// most recent value of its series. Entries whose series has no values yet are shown with just their label.
+// If configured each entry also shows the p50/p95/max over the visible window, e.g. "1min: 2.3 [1.9/2.8/3.0]".
+// When values are smoothed the title ends with the number of samples averaged into each point, e.g. "(smoothed x4)".
+func chartTitle(config *PoptopConfig, name string, format func(float64) string, entries ...titleEntry) *cell.RichTextString {
+	title := cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(" " + name + " (")
+
+	for i, entry := range entries {
+		if i > 0 {
+			title.AddText(", ")
+		}
+
+		title.SetFgColor(entry.color).AddText(entry.label)
+		if entry.series != nil && !math.IsNaN(entry.series.Last()) {
+			title.AddText(": " + format(entry.series.Last()))
+
+			if config.ShowStats {
+				title.AddText(fmt.Sprintf(" [%s/%s/%s]",
+					format(entry.series.Percentile(50)),
+					format(entry.series.Percentile(95)),
+					format(entry.series.Percentile(100))))
+			}
+		}
+		title.ResetColor()
+	}
+
+	if config.Smoothing() > 1 && config.SmoothMedian {
+		return title.AddText(fmt.Sprintf(") (median x%d) ", config.Smoothing()))
+	}
+	if config.Smoothing() > 1 {
+		return title.AddText(fmt.Sprintf(") (smoothed x%d) ", config.Smoothing()))
+	}
+	return title.AddText(") ")
 }
 
 // Create a widget that shows CPU load measured at 1min, 5min, 15min averages.
+// Returns the color of the 1min load series, which is hot if load exceeds the number of logical
+// CPUs as processes are then waiting to run. If the CPU count isn't known it's never hot.
+func loadColor(load float64, cpus int) cell.Color {
+	if cpus <= 0 || load <= float64(cpus) {
+		return ColorLoadOk
+	}
+	return ColorLoadOverloaded
+}
+
 // This uses a sysctl call to find CPU load.
 //
 // Load is one of the simplest metrics for understanding how busy your system is.
 // It means roughly how many processes are executing or waiting to execute on a CPU.
 // If load is higher than the number of CPU cores on your system then it indicates
 // processes are having to wait for execution.
-func newLoadChart(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
-	xLabels := formatLabels(config, func(n int) string {
-		x := float64(n) * float64(config.SampleInterval) / float64(time.Second)
+func newLoadChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
 		return fmt.Sprintf("%.0fs", x)
 	})
 
-	lc, err := newLinechart(linechart.YAxisFormattedValues(formatOnePoint))
+	chart, err := newChart(config, WidgetCPULoad, formatOnePoint, xLabels, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	nSamples := config.NumSamples
-	load1 := NewBoundedSeries(nSamples)
-	load5 := NewBoundedSeries(nSamples)
-	load15 := NewBoundedSeries(nSamples)
+	load1 := newChartSeries(config, sampling, "cpuLoad.load1")
+	load5 := newChartSeries(config, sampling, "cpuLoad.load5")
+	load15 := newChartSeries(config, sampling, "cpuLoad.load15")
 
-	go periodic(ctx, config.SampleInterval, func() error {
-		loadAvg, err := load.AvgWithContext(ctx)
-		if err != nil {
+	// the CPU count doesn't change so just fetch it once, treating a failure as unknown
+	cpus, err := cpu.CountsWithContext(ctx, true)
+	if err != nil {
+		cpus = 0
+	}
+	load1Color := config.SeriesColor("load1", ColorLoadOk)
+	load5Color := config.SeriesColor("load5", ColorHot2)
+	load15Color := config.SeriesColor("load15", ColorHot3)
+
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, "CPU Load", formatOnePoint,
+			titleEntry{"1min", load1Color, load1},
+			titleEntry{"5min", load5Color, load5},
+			titleEntry{"15min", load15Color, load15})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "cpuLoad", chart, makeTitle(), config, WidgetCPULoad)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("cpuLoad", values, 3); err != nil {
 			return err
 		}
+		config.freezeOnSpike(WidgetCPULoad, values)
 
-		load1.AddValue(loadAvg.Load1)
-		load5.AddValue(loadAvg.Load5)
-		load15.AddValue(loadAvg.Load15)
+		load1.AddValue(values[0])
+		load5.AddValue(values[1])
+		load15.AddValue(values[2])
+		load1Color = config.SeriesColor("load1", loadColor(values[0], cpus))
+		setTitle(makeTitle())
 
-		err = lc.Series("c_load1", load1.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorHot1)),
-		)
+		err = chart.Series("c_load1", config.Smoothed(load1), load1Color)
 		if err != nil {
 			return err
 		}
-		err = lc.Series("b_load5", load5.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorHot2)),
-		)
+		err = chart.Series("b_load5", config.Smoothed(load5), load5Color)
 		if err != nil {
 			return err
 		}
-		err = lc.Series("a_load15", load15.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorHot3)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("a_load15", config.Smoothed(load15), load15Color)
 		return err
 	})
 
-	title := cell.NewRichTextString(ColorWidgetTitle).
-		AddOpt(cell.Bold()).
-		AddText(" CPU Load (").
-		SetFgColor(ColorHot1).
-		AddText("1min").
-		ResetColor().
-		AddText(", ").
-		SetFgColor(ColorHot2).
-		AddText("5min").
-		ResetColor().
-		AddText(", ").
-		SetFgColor(ColorHot3).
-		AddText("15min").
-		ResetColor().
-		AddText(") ")
-
-	opts := makeContainer(lc, title)
-
 	return opts, nil
 }
 
 // Create a chart to show min, average, max CPU busy % time.
 // On MacOS this calls host_processor_info().
 // The judgement call here is that min, avg, max is a simpler way to understand CPU load
-// rather than a single average, or charting per-CPU time.
-func newCpuChart(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
+// rather than a single average, or charting per-CPU time. If configured we chart idle % instead,
+// in which case the least idle CPU is drawn in the hot color as it's the busiest.
+func newCpuChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	name, minColor, maxColor := "CPU (%)", ColorHot3, ColorHot1
+	if config.CpuIdle {
+		name, minColor, maxColor = "CPU Idle (%)", ColorHot1, ColorHot3
+	}
+	minColor = config.SeriesColor("min", minColor)
+	maxColor = config.SeriesColor("max", maxColor)
+	avgColor := config.SeriesColor("avg", ColorHot2)
 
-	xLabels := formatLabels(config, func(n int) string {
-		x := float64(n) * float64(config.SampleInterval) / float64(time.Second)
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
 		return fmt.Sprintf("%.0fs", x)
 	})
 
-	lc, err := newLinechart(linechart.YAxisFormattedValues(formatPercent))
+	chart, err := newChart(config, WidgetCPUPerc, formatPercent, xLabels, percentMax)
 	if err != nil {
 		return nil, err
 	}
 
-	nSamples := config.NumSamples
-	avgCpu := NewBoundedSeries(nSamples)
-	minCpu := NewBoundedSeries(nSamples)
-	maxCpu := NewBoundedSeries(nSamples)
+	avgCpu := newChartSeries(config, sampling, "cpuPerc.avg")
+	minCpu := newChartSeries(config, sampling, "cpuPerc.min")
+	maxCpu := newChartSeries(config, sampling, "cpuPerc.max")
 
-	go periodic(ctx, config.SampleInterval, func() error {
-		cpuAllPerc, err := cpu.PercentWithContext(ctx, 0, true)
-		if err != nil {
-			return err
-		}
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, name, formatPercent,
+			titleEntry{"min", minColor, minCpu},
+			titleEntry{"avg", avgColor, avgCpu},
+			titleEntry{"max", maxColor, maxCpu})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "cpuPerc", chart, makeTitle(), config, WidgetCPUPerc)
 
-		minMax := getMinMax(cpuAllPerc)
+	// compact sparklines can't be shaded so they always show three lines
+	var band *lineChart
+	if lc, ok := chart.(*lineChart); ok && config.CpuBand {
+		band = lc
+	}
 
-		avgCpu.AddValue(getAvg(cpuAllPerc))
-		minCpu.AddValue(minMax.min)
-		maxCpu.AddValue(minMax.max)
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("cpuPerc", values, 3); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetCPUPerc, values)
+
+		minCpu.AddValue(values[0])
+		avgCpu.AddValue(values[1])
+		maxCpu.AddValue(values[2])
+		setTitle(makeTitle())
+
+		if band != nil {
+			err = band.Band("a_cpuBand", config.Smoothed(minCpu), config.Smoothed(maxCpu),
+				dimColor(avgColor), minColor, maxColor)
+			if err != nil {
+				return err
+			}
+			return chart.Series("c_cpuAvg", config.Smoothed(avgCpu), avgColor)
+		}
 
-		err = lc.Series("c_cpuAvg", avgCpu.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorHot2)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("c_cpuAvg", config.Smoothed(avgCpu), avgColor)
 		if err != nil {
 			return err
 		}
-		err = lc.Series("b_cpuMax", maxCpu.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorHot1)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("b_cpuMax", config.Smoothed(maxCpu), maxColor)
 		if err != nil {
 			return err
 		}
-		err = lc.Series("a_cpuMin", minCpu.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorHot3)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("a_cpuMin", config.Smoothed(minCpu), minColor)
 		return err
 	})
 
-	title := cell.NewRichTextString(ColorWidgetTitle).
-		AddOpt(cell.Bold()).
-		AddText(" CPU (%) (").
-		SetFgColor(ColorHot3).
-		AddText("min").
-		ResetColor().
-		AddText(", ").
-		SetFgColor(ColorHot2).
-		AddText("avg").
-		ResetColor().
-		AddText(", ").
-		SetFgColor(ColorHot1).
-		AddText("max").
-		ResetColor().
-		AddText(") ")
-
-	opts := makeContainer(lc, title)
-
 	return opts, nil
 }
 
-// Chart to show throughput on all network devices in kibibytes per second
-// using data from the netstat command.
-func newNetChart(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
-	xLabels := formatLabels(config, func(n int) string {
-		x := float64(n) * float64(config.SampleInterval) / float64(time.Second)
+// Create a chart to show the % of CPU time spent in user space, the kernel and waiting on IO
+// across all CPUs. A high iowait is a good sign of a disk bound workload, but it's only charted
+// if withIowait is set as not every platform reports it.
+func newCpuTimesChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector, withIowait bool) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
 		return fmt.Sprintf("%.0fs", x)
 	})
 
-	lc, err := newLinechart(linechart.YAxisFormattedValues(formatNoPoint))
+	chart, err := newChart(config, WidgetCPUPerc, formatPercent, xLabels, percentMax)
 	if err != nil {
 		return nil, err
 	}
 
-	var lastSent uint64
-	var lastRecv uint64
-	sent := NewBoundedSeries(config.NumSamples)
-	recv := NewBoundedSeries(config.NumSamples)
-
-	go periodic(ctx, config.SampleInterval, func() error {
-		iostats, err := net.IOCountersWithContext(ctx, true)
-		if err != nil {
-			return err
+	user := newChartSeries(config, sampling, "cpuTimes.user")
+	system := newChartSeries(config, sampling, "cpuTimes.system")
+	iowait := newChartSeries(config, sampling, "cpuTimes.iowait")
+	userColor := config.SeriesColor("user", ColorHot2)
+	systemColor := config.SeriesColor("system", ColorHot1)
+	iowaitColor := config.SeriesColor("iowait", ColorHot3)
+
+	makeTitle := func() *cell.RichTextString {
+		entries := []titleEntry{{"user", userColor, user}, {"system", systemColor, system}}
+		if withIowait {
+			entries = append(entries, titleEntry{"iowait", iowaitColor, iowait})
 		}
+		return chartTitle(config, "CPU (%)", formatPercent, entries...)
+	}
 
-		var bytesSent uint64
-		var bytesRecv uint64
+	opts, setTitle := makeDynamicContainer(root, "cpuTimes", chart, makeTitle(), config, WidgetCPUPerc)
 
-		for _, iostat := range iostats {
-			bytesSent += iostat.BytesSent
-			bytesRecv += iostat.BytesRecv
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
 		}
-
-		newSent := bytesSent * uint64(time.Second/config.SampleInterval) / 1024
-		newRecv := bytesRecv * uint64(time.Second/config.SampleInterval) / 1024
-
-		if lastSent != 0 {
-			sent.AddValue(float64(newSent - lastSent))
+		if err := checkValues("cpuTimes", values, 3); err != nil {
+			return err
 		}
-		lastSent = newSent
+		config.freezeOnSpike(WidgetCPUPerc, values)
 
-		if lastRecv != 0 {
-			recv.AddValue(float64(newRecv - lastRecv))
-		}
-		lastRecv = newRecv
+		user.AddValue(values[0])
+		system.AddValue(values[1])
+		iowait.AddValue(values[2])
+		setTitle(makeTitle())
 
-		err = lc.Series("c_sent", sent.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorWrite)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("c_cpuUser", config.Smoothed(user), userColor)
 		if err != nil {
 			return err
 		}
-		err = lc.Series("b_recv", recv.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorRead)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("b_cpuSystem", config.Smoothed(system), systemColor)
+		if err != nil || !withIowait {
+			return err
+		}
+		err = chart.Series("a_cpuIowait", config.Smoothed(iowait), iowaitColor)
 		return err
 	})
 
-	title := cell.NewRichTextString(ColorWidgetTitle).
-		AddOpt(cell.Bold()).
-		AddText(" Network IO (KiB/s) (").
-		SetFgColor(ColorWrite).
-		AddText("send").
-		ResetColor().
-		AddText(", ").
-		SetFgColor(ColorRead).
-		AddText("recv").
-		ResetColor().
-		AddText(") ")
-
-	opts := makeContainer(lc, title)
-
 	return opts, nil
 }
 
-// Chart to show Disk IOPS (input/output operations per second) over time using data from iostat.
-// Arguably, in an everyday scenario with many heavy processes then IOPS is a simpler metric than
-// throughput, but if disk load is skewed to a specific process (e.g. heavy file copies, database
-// operations), then disk throughput may be a better metric.
-func newDiskIOPSChart(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
-	xLabels := formatLabels(config, func(n int) string {
-		x := float64(n) * float64(config.SampleInterval) / float64(time.Second)
-		return fmt.Sprintf("%.0fs", x)
-	})
+// Returns true if the interface name matches any of the exclusion patterns, which
+// may be exact names or globs like "docker*"
+func interfaceExcluded(patterns []string, iface string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, iface); matched {
+			return true
+		}
+	}
+	return false
+}
 
-	lc, err := newLinechart(linechart.YAxisFormattedValues(formatNoPoint))
-	if err != nil {
-		return nil, err
+// Chart to show throughput on all network devices in kibibytes per second
+// using data from the netstat command, skipping any excluded interfaces. If specific
+// interfaces have been configured then we instead stack a separate chart for each
+// of those interfaces. If we've been asked to split by address family and the system
+// reports traffic that way then we chart IPv4 and IPv6 separately instead.
+func newNetChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling) ([]container.Option, error) {
+	included := func(iface string) bool {
+		return !interfaceExcluded(config.ExcludeInterfaces, iface)
 	}
-	write := NewBoundedSeries(config.NumSamples)
-	read := NewBoundedSeries(config.NumSamples)
-	var lastWrite uint64
-	var lastRead uint64
 
-	go periodic(ctx, config.SampleInterval, func() error {
-		iostats, err := disk.IOCountersWithContext(ctx)
-		if err != nil {
-			return err
+	if len(config.NetInterfaces) == 0 && config.NetSplitFamily {
+		_, err := familyCounters(ctx)
+		if err == nil || config.replay != nil {
+			collector := newFamilyNetCollector(familyCounters, time.Now)
+			opts, err := newFamilyNetChart(ctx, root, config, sampling, counterSource(config, "networkIOFamily", collector, familyCounters, 1024))
+			if err != nil {
+				return nil, err
+			}
+			// packets aren't counted by address family, so they're charted across the included interfaces
+			return withNetPackets(ctx, root, config, sampling, "networkIOFamily", "", included, opts)
 		}
+		// otherwise fall back to the combined chart
+	}
 
-		var newRead uint64
-		var newWrite uint64
-		for _, v := range iostats {
-			newRead += v.ReadCount
-			newWrite += v.WriteCount
+	if len(config.NetInterfaces) == 0 {
+		counters := netCounters(included)
+		collector := newNetCollector(sampling.Interval, counters)
+		opts, err := newInterfaceNetChart(ctx, root, config, sampling, "networkIO", "", counterSource(config, "networkIO", collector, counters, 1024))
+		if err != nil {
+			return nil, err
 		}
+		return withNetPackets(ctx, root, config, sampling, "networkIO", "", included, opts)
+	}
 
-		if lastWrite != 0 {
-			write.AddValue(float64(newWrite-lastWrite) * float64(time.Second/config.SampleInterval))
+	builder := grid.New()
+	for _, iface := range config.NetInterfaces {
+		name := iface
+		id := "networkIO_" + name
+		only := func(n string) bool {
+			return n == name
+		}
+		counters := netCounters(only)
+		collector := newNetCollector(sampling.Interval, counters)
+		opts, err := newInterfaceNetChart(ctx, root, config, sampling, id, name, counterSource(config, id, collector, counters, 1024))
+		if err != nil {
+			return nil, err
+		}
+		if opts, err = withNetPackets(ctx, root, config, sampling, id, name, only, opts); err != nil {
+			return nil, err
 		}
-		lastWrite = newWrite
 
-		if lastRead != 0 {
-			read.AddValue(float64(newRead-lastRead) * float64(time.Second/config.SampleInterval))
+		config.nestTitles(id)
+		builder.Add(grid.RowHeightPercWithOpts(gridPerc(len(config.NetInterfaces)), opts))
+	}
+
+	opts, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, container.Border(linestyle.None)), nil
+}
+
+// Chart to show network throughput as sent and received by the collector, across every interface
+// or for the named interface.
+func newInterfaceNetChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, id, iface string, collector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	prefix := "Network IO"
+	if iface != "" {
+		prefix += " " + iface
+	}
+	name, axisFormat, format, collector := netCounterChart(config, prefix, collector)
+
+	chart, err := newChart(config, WidgetNetworkIO, axisFormat, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := newNetChartSeries(config, sampling, id+".sent")
+	recv := newNetChartSeries(config, sampling, id+".recv")
+	sentColor := config.SeriesColor("sent", ColorWrite)
+	recvColor := config.SeriesColor("recv", ColorRead)
+
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, name, format,
+			titleEntry{"send", sentColor, sent},
+			titleEntry{"recv", recvColor, recv})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, id, chart, makeTitle(), config, WidgetNetworkIO)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues(id, values, 2); err != nil {
+			return err
 		}
-		lastRead = newRead
+		config.freezeOnSpike(WidgetNetworkIO, values)
 
-		err = lc.Series("c_read", read.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorRead)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		sent.AddValue(values[0])
+		recv.AddValue(values[1])
+		setTitle(makeTitle())
+
+		err = chart.Series("c_sent", config.Smoothed(sent), sentColor)
 		if err != nil {
 			return err
 		}
-		err = lc.Series("b_write", write.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorWrite)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("b_recv", config.Smoothed(recv), recvColor)
 		return err
 	})
 
-	title := cell.NewRichTextString(ColorWidgetTitle).
-		AddOpt(cell.Bold()).
-		AddText(" Disk IOPS (").
-		SetFgColor(ColorRead).
-		AddText("read").
-		ResetColor().
-		AddText(", ").
-		SetFgColor(ColorWrite).
-		AddText("write").
-		ResetColor().
-		AddText(") ")
-
-	opts := makeContainer(lc, title)
+	return opts, nil
+}
+
+// Stacks a chart of the packets sent and received by the included interfaces below a network
+// throughput chart if --net-packets is set, along with the errors and drops if --net-errors is set,
+// otherwise returns the throughput chart as it is
+func withNetPackets(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, id, iface string, include func(iface string) bool, opts []container.Option) ([]container.Option, error) {
+	if !config.NetPackets && !config.NetErrors {
+		return opts, nil
+	}
+
+	var packetCollector, errorCollector Collector
+	if config.NetPackets {
+		counters := netPacketCounters(include)
+		packetCollector = counterSource(config, id+"Packets", newNetPacketCollector(sampling.Interval, counters), counters, 1)
+	}
+	if config.NetErrors {
+		counters := netErrorCounters(include)
+		errorCollector = counterSource(config, id+"Errors", newNetPacketCollector(sampling.Interval, counters), counters, 1)
+	}
+
+	packetOpts, err := newNetPacketChart(ctx, root, config, sampling, id+"Packets", iface, packetCollector, errorCollector)
+	if err != nil {
+		return nil, err
+	}
+
+	config.nestTitles(id, id+"Packets")
+	return []container.Option{
+		container.SplitHorizontal(container.Top(opts...), container.Bottom(packetOpts...), container.SplitPercent(50)),
+		container.Border(linestyle.None),
+	}, nil
+}
+
+// Chart to show the packets per second sent and received by packetCollector, which shows up
+// floods of small packets that barely register as throughput, and the errors and dropped packets per
+// second from errorCollector, which is a strong sign of a flaky NIC. Either collector can be nil
+// to leave its series out. It's part of the network widget rather than a widget of its own, so the
+// network widget's --max-y, --log-scale and --freeze-on don't apply.
+func newNetPacketChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, id, iface string, packetCollector, errorCollector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	prefix := "Network Packets"
+	if packetCollector == nil {
+		prefix = "Network Errors"
+	}
+	if iface != "" {
+		prefix += " " + iface
+	}
+	name, axisFormat, format := counterChart(config, prefix+" (/s)", prefix)
+
+	chart, err := newChart(config, noWidget, axisFormat, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := newChartSeries(config, sampling, id+".sent")
+	recv := newChartSeries(config, sampling, id+".recv")
+	errs := newChartSeries(config, sampling, id+".errors")
+	drops := newChartSeries(config, sampling, id+".drops")
+	sentColor := config.SeriesColor("sent", ColorWrite)
+	recvColor := config.SeriesColor("recv", ColorRead)
+	errorsColor := config.SeriesColor("errors", ColorError)
+	dropsColor := config.SeriesColor("drops", ColorHot2)
+
+	makeTitle := func() *cell.RichTextString {
+		entries := []titleEntry{}
+		if packetCollector != nil {
+			entries = append(entries, titleEntry{"send", sentColor, sent}, titleEntry{"recv", recvColor, recv})
+		}
+		if errorCollector != nil {
+			entries = append(entries, titleEntry{"errors", errorsColor, errs}, titleEntry{"drops", dropsColor, drops})
+		}
+		return chartTitle(config, name, format, entries...)
+	}
+
+	opts, setTitle := makeDynamicContainer(root, id, chart, makeTitle(), config, WidgetNetworkIO)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		// both are collected before checking either so they're primed by the same first sample
+		var packetValues, errorValues []float64
+		var packetErr, errorErr error
+		if packetCollector != nil {
+			packetValues, packetErr = packetCollector.Collect(ctx)
+		}
+		if errorCollector != nil {
+			errorValues, errorErr = errorCollector.Collect(ctx)
+		}
+
+		if packetCollector != nil {
+			if packetErr != nil || packetValues == nil {
+				return packetErr
+			}
+			if err := checkValues(id, packetValues, 2); err != nil {
+				return err
+			}
+		}
+		if errorCollector != nil {
+			if errorErr != nil || errorValues == nil {
+				return errorErr
+			}
+			if err := checkValues(id+"Errors", errorValues, 2); err != nil {
+				return err
+			}
+		}
+
+		if packetCollector != nil {
+			sent.AddValue(packetValues[0])
+			recv.AddValue(packetValues[1])
+		}
+		if errorCollector != nil {
+			errs.AddValue(errorValues[0])
+			drops.AddValue(errorValues[1])
+		}
+		setTitle(makeTitle())
+
+		// errors are drawn last so they're on top, as any at all are worth noticing
+		if packetCollector != nil {
+			err = chart.Series("b_sent", config.Smoothed(sent), sentColor)
+			if err != nil {
+				return err
+			}
+			err = chart.Series("a_recv", config.Smoothed(recv), recvColor)
+			if err != nil {
+				return err
+			}
+		}
+		if errorCollector != nil {
+			err = chart.Series("c_drops", config.Smoothed(drops), dropsColor)
+			if err != nil {
+				return err
+			}
+			err = chart.Series("d_errors", config.Smoothed(errs), errorsColor)
+		}
+		return err
+	})
 
 	return opts, nil
 }
 
-// Chart to show disk IO throughput in kibibytes per second based on iostat output.
-func newDiskIOChart(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
-	xLabels := formatLabels(config, func(n int) string {
-		x := float64(n) * float64(config.SampleInterval) / float64(time.Second)
+// Chart to show network throughput split into IPv4 and IPv6 sent and received, using the address
+// family counters. These are counted across every interface so exclusions don't apply.
+func newFamilyNetChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
 		return fmt.Sprintf("%.0fs", x)
 	})
 
-	lc, err := newLinechart(linechart.YAxisFormattedValues(formatNoPoint))
+	name, axisFormat, format, collector := netCounterChart(config, "Network IO", collector)
+	chart, err := newChart(config, WidgetNetworkIO, axisFormat, xLabels, 0)
 	if err != nil {
 		return nil, err
 	}
-	write := NewBoundedSeries(config.NumSamples)
-	read := NewBoundedSeries(config.NumSamples)
-	var lastWrite uint64
-	var lastRead uint64
 
-	go periodic(ctx, config.SampleInterval, func() error {
-		iostats, err := disk.IOCountersWithContext(ctx)
+	v4Sent := newNetChartSeries(config, sampling, "networkIOFamily.v4_sent")
+	v4Recv := newNetChartSeries(config, sampling, "networkIOFamily.v4_recv")
+	v6Sent := newNetChartSeries(config, sampling, "networkIOFamily.v6_sent")
+	v6Recv := newNetChartSeries(config, sampling, "networkIOFamily.v6_recv")
+	v4SentColor := config.SeriesColor("v4_sent", ColorWrite)
+	v4RecvColor := config.SeriesColor("v4_recv", ColorRead)
+	v6SentColor := config.SeriesColor("v6_sent", ColorWriteAlt)
+	v6RecvColor := config.SeriesColor("v6_recv", ColorReadAlt)
+
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, name, format,
+			titleEntry{"v4 send", v4SentColor, v4Sent},
+			titleEntry{"v4 recv", v4RecvColor, v4Recv},
+			titleEntry{"v6 send", v6SentColor, v6Sent},
+			titleEntry{"v6 recv", v6RecvColor, v6Recv})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "networkIOFamily", chart, makeTitle(), config, WidgetNetworkIO)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("networkIOFamily", values, 4); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetNetworkIO, values)
+
+		v4Sent.AddValue(values[0])
+		v4Recv.AddValue(values[1])
+		v6Sent.AddValue(values[2])
+		v6Recv.AddValue(values[3])
+		setTitle(makeTitle())
+
+		err = chart.Series("e_v4Sent", config.Smoothed(v4Sent), v4SentColor)
 		if err != nil {
 			return err
 		}
+		err = chart.Series("d_v4Recv", config.Smoothed(v4Recv), v4RecvColor)
+		if err != nil {
+			return err
+		}
+		err = chart.Series("c_v6Sent", config.Smoothed(v6Sent), v6SentColor)
+		if err != nil {
+			return err
+		}
+		err = chart.Series("b_v6Recv", config.Smoothed(v6Recv), v6RecvColor)
+		return err
+	})
+
+	return opts, nil
+}
+
+// Chart to show Disk IOPS (input/output operations per second) over time using the given source.
+// Arguably, in an everyday scenario with many heavy processes then IOPS is a simpler metric than
+// throughput, but if disk load is skewed to a specific process (e.g. heavy file copies, database
+// operations), then disk throughput may be a better metric.
+func newDiskIOPSChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, source *diskSource, collector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	name, axisFormat, format := counterChart(config, "Disk IOPS", "Disk Ops")
+	chart, err := newChart(config, WidgetDiskIOPS, axisFormat, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
+	write := newChartSeries(config, sampling, "diskIOPS.write")
+	read := newChartSeries(config, sampling, "diskIOPS.read")
+	readColor := config.SeriesColor("read", ColorRead)
+	writeColor := config.SeriesColor("write", ColorWrite)
+
+	makeTitle := func() *cell.RichTextString {
+		name, entries := source.title(name, read, write, readColor, writeColor)
+		return chartTitle(config, name, format, entries...)
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "diskIOPS", chart, makeTitle(), config, WidgetDiskIOPS)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+
+		// a stuck iostat skips this sample, it's tried again next sample
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil
+		}
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("diskIOPS", values, 2); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetDiskIOPS, values)
 
-		var newRead uint64
-		var newWrite uint64
-		for _, v := range iostats {
-			newRead += v.ReadCount
-			newWrite += v.WriteCount
+		read.AddValue(values[0])
+		write.AddValue(values[1])
+		setTitle(makeTitle())
+
+		err = chart.Series("c_read", config.Smoothed(read), readColor)
+		if err != nil || !source.split {
+			return err
 		}
+		err = chart.Series("b_write", config.Smoothed(write), writeColor)
+		return err
+	})
+
+	return opts, nil
+}
+
+// Chart to show disk IO throughput in kibibytes per second using the given source.
+// Unlike the IOPS chart this uses byte counters, scaled by the real time elapsed between samples.
+func newDiskIOChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, source *diskSource, collector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	name, axisFormat, format := counterChart(config, "Disk IO (KiB/s)", "Disk IO (KiB)")
+	chart, err := newChart(config, WidgetDiskIO, axisFormat, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
+	write := newChartSeries(config, sampling, "diskIO.write")
+	read := newChartSeries(config, sampling, "diskIO.read")
+	readColor := config.SeriesColor("read", ColorRead)
+	writeColor := config.SeriesColor("write", ColorWrite)
+
+	makeTitle := func() *cell.RichTextString {
+		name, entries := source.title(name, read, write, readColor, writeColor)
+		return chartTitle(config, name, format, entries...)
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "diskIO", chart, makeTitle(), config, WidgetDiskIO)
 
-		if lastWrite != 0 {
-			write.AddValue(float64(newWrite - lastWrite))
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+
+		// a stuck iostat skips this sample, it's tried again next sample
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil
+		}
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("diskIO", values, 2); err != nil {
+			return err
 		}
-		lastWrite = newWrite
+		config.freezeOnSpike(WidgetDiskIO, values)
 
-		if lastRead != 0 {
-			read.AddValue(float64(newRead - lastRead))
+		read.AddValue(values[0])
+		write.AddValue(values[1])
+		setTitle(makeTitle())
+
+		if source.split {
+			err = chart.Series("c_write", config.Smoothed(write), writeColor)
+			if err != nil {
+				return err
+			}
 		}
-		lastRead = newRead
+		err = chart.Series("b_read", config.Smoothed(read), readColor)
+		return err
+	})
+
+	return opts, nil
+}
+
+// Chart to show the number of open network connections in the ESTABLISHED, TIME_WAIT and LISTEN states,
+// which is useful for spotting connection leaks. Listing connections can be slow (and on some systems
+// requires elevated privileges to see every process's sockets), so unless it's been given its own
+// interval we sample this at one-fourth of the sample interval rate, see Finalize.
+func newConnectionsChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	chart, err := newChart(config, WidgetConnections, formatNoPoint, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
 
-		err = lc.Series("c_write", write.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorWrite)),
-			linechart.SeriesXLabels(xLabels),
-		)
+	established := newChartSeries(config, sampling, "connections.established")
+	timeWait := newChartSeries(config, sampling, "connections.time_wait")
+	listen := newChartSeries(config, sampling, "connections.listen")
+	establishedColor := config.SeriesColor("established", ColorHot1)
+	timeWaitColor := config.SeriesColor("time_wait", ColorHot2)
+	listenColor := config.SeriesColor("listen", ColorHot3)
+
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, "Connections", formatNoPoint,
+			titleEntry{"established", establishedColor, established},
+			titleEntry{"time_wait", timeWaitColor, timeWait},
+			titleEntry{"listen", listenColor, listen})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "connections", chart, makeTitle(), config, WidgetConnections)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("connections", values, 3); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetConnections, values)
+
+		established.AddValue(values[0])
+		timeWait.AddValue(values[1])
+		listen.AddValue(values[2])
+		setTitle(makeTitle())
+
+		err = chart.Series("c_established", config.Smoothed(established), establishedColor)
 		if err != nil {
 			return err
 		}
-		err = lc.Series("b_read", read.SmoothedValues(config.SmoothingSamples),
-			linechart.SeriesCellOpts(cell.FgColor(ColorRead)),
-			linechart.SeriesXLabels(xLabels),
-		)
+		err = chart.Series("b_timeWait", config.Smoothed(timeWait), timeWaitColor)
+		if err != nil {
+			return err
+		}
+		err = chart.Series("a_listen", config.Smoothed(listen), listenColor)
 		return err
 	})
 
-	title := cell.NewRichTextString(ColorWidgetTitle).
-		AddOpt(cell.Bold()).
-		AddText(" Disk IO (KiB/s) (").
-		SetFgColor(ColorRead).
-		AddText("read").
-		ResetColor().
-		AddText(", ").
-		SetFgColor(ColorWrite).
-		AddText("write").
-		ResetColor().
-		AddText(") ")
-
-	opts := makeContainer(lc, title)
+	return opts, nil
+}
+
+// Chart to show context switches and interrupts per second across the system, which helps spot
+// scheduling storms. Only Linux reports these, elsewhere the widget says they're unsupported.
+func newSwitchesChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	if _, err := switchCounters(ctx); err != nil && config.replay == nil {
+		textBox, err := text.New()
+		if err != nil {
+			return nil, err
+		}
+
+		title := cell.NewRichTextString(ColorWidgetTitle).
+			AddOpt(cell.Bold()).
+			AddText(" Context Switches (/s) ")
+
+		textBox.Write(" Context switch and interrupt counts are only available on Linux.", text.WriteReplace())
+		return makeContainer(textBox, title, config, WidgetSwitches), nil
+	}
+
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	chart, err := newChart(config, WidgetSwitches, formatMagnitude, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ctxt := newChartSeries(config, sampling, "switches.ctxt")
+	intr := newChartSeries(config, sampling, "switches.intr")
+	ctxtColor := config.SeriesColor("ctxt", ColorHot1)
+	intrColor := config.SeriesColor("intr", ColorHot3)
+
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, "Context Switches (/s)", formatMagnitude,
+			titleEntry{"ctxt", ctxtColor, ctxt},
+			titleEntry{"intr", intrColor, intr})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "switches", chart, makeTitle(), config, WidgetSwitches)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("switches", values, 2); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetSwitches, values)
+
+		ctxt.AddValue(values[0])
+		intr.AddValue(values[1])
+		setTitle(makeTitle())
+
+		err = chart.Series("b_ctxt", config.Smoothed(ctxt), ctxtColor)
+		if err != nil {
+			return err
+		}
+		err = chart.Series("a_intr", config.Smoothed(intr), intrColor)
+		return err
+	})
+
+	return opts, nil
+}
+
+// Chart to show the average milliseconds each disk read and write took, which shows disk pressure
+// better than IOPS as requests queue up and take longer on a struggling disk. Where the disk timings
+// aren't available, e.g. MacOS builds without cgo, the widget says so.
+func newDiskLatencyChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	if _, err := diskLatencyCounters(ctx); err != nil && config.replay == nil {
+		textBox, err := text.New()
+		if err != nil {
+			return nil, err
+		}
+
+		title := cell.NewRichTextString(ColorWidgetTitle).
+			AddOpt(cell.Bold()).
+			AddText(" Disk Latency (ms) ")
+
+		textBox.Write(fmt.Sprintf(" Disk timings aren't available on this system: %v", err), text.WriteReplace())
+		return makeContainer(textBox, title, config, WidgetDiskLatency), nil
+	}
+
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	chart, err := newChart(config, WidgetDiskLatency, formatMillis, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	read := newChartSeries(config, sampling, "diskLatency.read")
+	write := newChartSeries(config, sampling, "diskLatency.write")
+	readColor := config.SeriesColor("read", ColorRead)
+	writeColor := config.SeriesColor("write", ColorWrite)
+
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, "Disk Latency (ms)", formatMillis,
+			titleEntry{"read", readColor, read},
+			titleEntry{"write", writeColor, write})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "diskLatency", chart, makeTitle(), config, WidgetDiskLatency)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("diskLatency", values, 2); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetDiskLatency, values)
+
+		read.AddValue(values[0])
+		write.AddValue(values[1])
+		setTitle(makeTitle())
+
+		err = chart.Series("c_write", config.Smoothed(write), writeColor)
+		if err != nil {
+			return err
+		}
+		err = chart.Series("b_read", config.Smoothed(read), readColor)
+		return err
+	})
+
+	return opts, nil
+}
+
+// Chart to show the bytes used on the filesystem mounted at DiskSpacePath. Usage changes slowly so
+// unless it's been given its own interval this is sampled at a fifth of the sample interval rate,
+// see Finalize. While usage is climbing the title estimates when the filesystem fills up.
+func newDiskSpaceChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	name := "Disk Space " + config.DiskSpacePath
+
+	if _, err := disk.UsageWithContext(ctx, config.DiskSpacePath); err != nil && config.replay == nil {
+		textBox, err := text.New()
+		if err != nil {
+			return nil, err
+		}
+
+		title := cell.NewRichTextString(ColorWidgetTitle).
+			AddOpt(cell.Bold()).
+			AddText(" " + name + " ")
+
+		textBox.Write(fmt.Sprintf(" Couldn't read the disk space of %s: %v", config.DiskSpacePath, err), text.WriteReplace())
+		return makeContainer(textBox, title, config, WidgetDiskSpace), nil
+	}
+
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	chart, err := newChart(config, WidgetDiskSpace, formatBytes, xLabels, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	used := newChartSeries(config, sampling, "diskSpace.used")
+	free := newChartSeries(config, sampling, "diskSpace.free")
+	usedColor := config.SeriesColor("used", ColorHot2)
+	freeColor := config.SeriesColor("free", ColorHot4)
+
+	makeTitle := func() *cell.RichTextString {
+		entries := []titleEntry{{"used", usedColor, used}, {"free", freeColor, free}}
+		if eta, ok := timeToFull(used, free.Last(), sampling.PointInterval()); ok {
+			entries = append(entries, titleEntry{"full in " + formatTimeToFull(eta), ColorError, nil})
+		}
+		return chartTitle(config, name, formatBytes, entries...)
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "diskSpace", chart, makeTitle(), config, WidgetDiskSpace)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("diskSpace", values, 2); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetDiskSpace, values)
+
+		used.AddValue(values[0])
+		free.AddValue(values[1])
+		setTitle(makeTitle())
+
+		return chart.Series("a_used", config.Smoothed(used), usedColor)
+	})
+
+	return opts, nil
+}
+
+// The percentage of a file limit at which its series turns red, as running out is close
+const fileLimitDanger = 90
+
+// Returns the color of a file limit series, which is the error color once it's at fileLimitDanger
+// percent of its limit
+func fileLimitColor(perc float64, ok cell.Color) cell.Color {
+	if perc >= fileLimitDanger {
+		return ColorError
+	}
+	return ok
+}
+
+// Chart to show the percentage of the system's file handle limit in use and of the inodes used on
+// the fullest filesystem, as running out of either causes confusing failures. These change slowly
+// so unless it's been given its own interval this is sampled at a fifth of the sample interval
+// rate, see Finalize.
+func newFileLimitsChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	chart, err := newChart(config, WidgetFileLimits, formatPercent, xLabels, percentMax)
+	if err != nil {
+		return nil, err
+	}
+
+	handles := newChartSeries(config, sampling, "fileLimits.handles")
+	inodes := newChartSeries(config, sampling, "fileLimits.inodes")
+	handlesColor := config.SeriesColor("handles", ColorHot1)
+	inodesColor := config.SeriesColor("inodes", ColorHot2)
+
+	makeTitle := func() *cell.RichTextString {
+		return chartTitle(config, "File Limits (%)", formatPercent,
+			titleEntry{"handles", handlesColor, handles},
+			titleEntry{"inodes", inodesColor, inodes})
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "fileLimits", chart, makeTitle(), config, WidgetFileLimits)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return err
+		}
+		if err := checkValues("fileLimits", values, 2); err != nil {
+			return err
+		}
+		config.freezeOnSpike(WidgetFileLimits, values)
+
+		handles.AddValue(values[0])
+		inodes.AddValue(values[1])
+		handlesColor = config.SeriesColor("handles", fileLimitColor(values[0], ColorHot1))
+		inodesColor = config.SeriesColor("inodes", fileLimitColor(values[1], ColorHot2))
+		setTitle(makeTitle())
+
+		err = chart.Series("b_handles", config.Smoothed(handles), handlesColor)
+		if err != nil {
+			return err
+		}
+		return chart.Series("a_inodes", config.Smoothed(inodes), inodesColor)
+	})
 
 	return opts, nil
 }
+
+// Estimates are only shown for filesystems filling up within this long, slower than that the trend
+// is more likely noise than a problem
+const maxTimeToFull = 365 * 24 * time.Hour
+
+// Extrapolates the trend of the used bytes across the chart to estimate how long until the free
+// bytes run out, with points pointInterval apart. Returns false if usage isn't climbing.
+func timeToFull(used *BoundedSeries, free float64, pointInterval time.Duration) (time.Duration, bool) {
+	perPoint := used.Slope()
+	if math.IsNaN(perPoint) || perPoint <= 0 || math.IsNaN(free) {
+		return 0, false
+	}
+
+	eta := free / perPoint * float64(pointInterval)
+	if eta > float64(maxTimeToFull) {
+		return 0, false
+	}
+	return time.Duration(eta), true
+}
+
+// Formats an estimate of how long until a filesystem is full, e.g. "~45m", "~3h" or "~12d"
+func formatTimeToFull(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "<1m"
+	case d < time.Hour:
+		return fmt.Sprintf("~%.0fm", d.Minutes())
+	case d < 48*time.Hour:
+		return fmt.Sprintf("~%.0fh", d.Hours())
+	}
+	return fmt.Sprintf("~%.0fd", d.Hours()/24)
+}
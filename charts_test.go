@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mum4k/termdash/container"
+)
+
+func TestClockLabels(t *testing.T) {
+	start := time.Date(2022, 9, 1, 12, 0, 0, 0, time.Local)
+
+	// before the chart fills up the points run on from when it started
+	labels := clockLabels(start, start.Add(2*time.Second), 4, time.Second)
+	expected := []string{"12:00:00", "12:00:01", "12:00:02", "12:00:03"}
+	for i, label := range expected {
+		if labels[i] != label {
+			t.Errorf("Expected label %d to be %s but got %s", i, label, labels[i])
+		}
+	}
+
+	// once full the newest point is now
+	labels = clockLabels(start, start.Add(time.Minute), 4, 10*time.Second)
+	expected = []string{"12:00:30", "12:00:40", "12:00:50", "12:01:00"}
+	for i, label := range expected {
+		if labels[i] != label {
+			t.Errorf("Expected label %d to be %s but got %s", i, label, labels[i])
+		}
+	}
+}
+
+func TestThinLabels(t *testing.T) {
+	labels := map[int]string{}
+	for i := 0; i < 11; i++ {
+		labels[i] = fmt.Sprintf("-%ds", 10-i)
+	}
+	assertLabels := func(thinned map[int]string, expected []string) {
+		t.Helper()
+		for i, label := range expected {
+			if thinned[i] != label {
+				t.Errorf("Expected label %d to be %q but got %q", i, label, thinned[i])
+			}
+		}
+	}
+
+	// ten cells a point leaves room for every label
+	assertLabels(thinLabels(labels, 11, 100, 0), []string{"-10s", "-9s", "-8s", "-7s", "-6s", "-5s", "-4s", "-3s", "-2s", "-1s", "-0s"})
+
+	// a cell a point labels every eighth, keeping enough after each that termdash lands on one
+	assertLabels(thinLabels(labels, 11, 10, 0), []string{"-10s", "-9s", "-8s", "-7s", " ", " ", " ", " ", "-2s", "-1s", "-0s"})
+
+	// every third as asked
+	assertLabels(thinLabels(labels, 11, 100, 3), []string{"-10s", " ", " ", "-7s", " ", " ", "-4s", " ", " ", "-1s", " "})
+
+	// a marker leads up to its point so termdash can't skip over it
+	labels[9] = "▲1"
+	assertLabels(thinLabels(labels, 11, 10, 0), []string{"-10s", "-9s", "-8s", "▲1", "▲1", "▲1", "▲1", "▲1", "▲1", "▲1", "-0s"})
+
+	// padded when it's shorter than the span, "▲" being three bytes wide to termdash
+	labels[0] = "-10000s"
+	assertLabels(thinLabels(labels, 11, 20, 0), []string{"-10000s", "-9s", " ", " ", " ", "▲1   ", "▲1   ", "▲1   ", "▲1   ", "▲1   ", " "})
+	labels[0], labels[9] = "-10s", "-1s"
+
+	// before the first draw the width isn't known
+	assertLabels(thinLabels(labels, 11, 0, 3), []string{"-10s", "-9s", "-8s"})
+}
+
+func TestTimeToFull(t *testing.T) {
+	used := NewBoundedSeries(10)
+	if _, ok := timeToFull(used, 100, time.Second); ok {
+		t.Error("Expected no estimate without any samples")
+	}
+
+	// 10 bytes a point with points a minute apart fills 600 free bytes in an hour
+	for _, v := range []float64{100, 110, 120, 130} {
+		used.AddValue(v)
+	}
+	if eta, ok := timeToFull(used, 600, time.Minute); !ok || eta != time.Hour {
+		t.Errorf("Expected the disk to be full in an hour but got %v, %v", eta, ok)
+	}
+	if _, ok := timeToFull(used, 1e12, time.Minute); ok {
+		t.Error("Expected no estimate when it would take years to fill")
+	}
+
+	used.AddValue(50)
+	if _, ok := timeToFull(used, 600, time.Minute); ok {
+		t.Error("Expected no estimate once usage falls")
+	}
+}
+
+func TestFormatTimeToFull(t *testing.T) {
+	cases := map[time.Duration]string{
+		30 * time.Second:    "<1m",
+		45 * time.Minute:    "~45m",
+		3 * time.Hour:       "~3h",
+		47 * time.Hour:      "~47h",
+		12 * 24 * time.Hour: "~12d",
+	}
+
+	for d, expected := range cases {
+		if formatted := formatTimeToFull(d); formatted != expected {
+			t.Errorf("Expected %v to be formatted as %s but got %s", d, expected, formatted)
+		}
+	}
+}
+
+func TestFormatMagnitude(t *testing.T) {
+	cases := map[float64]string{
+		0:       "0",
+		999:     "999",
+		1000:    "1.0K",
+		1234567: "1.2M",
+		999960:  "1.0M",
+		5.6e9:   "5.6G",
+		7.8e12:  "7.8T",
+		3e18:    "3000.0P",
+		-2500:   "-2.5K",
+	}
+	for n, want := range cases {
+		if got := formatMagnitude(n); got != want {
+			t.Errorf("Expected %v to format as %s but got %s", n, want, got)
+		}
+	}
+}
+
+func TestFormatCompact(t *testing.T) {
+	cases := map[float64]string{
+		0:       "0",
+		0.04:    "0",
+		-0.04:   "0",
+		0.25:    "0.3",
+		1.5:     "1.5",
+		9.96:    "10",
+		999:     "999",
+		999.6:   "1k",
+		1000:    "1k",
+		1500:    "1.5k",
+		9999:    "10k",
+		125000:  "125k",
+		999950:  "1M",
+		1500000: "1.5M",
+		2.5e9:   "2.5G",
+		3e18:    "3000P",
+		-125000: "-125k",
+	}
+	for n, want := range cases {
+		if got := formatCompact(n); got != want {
+			t.Errorf("Expected %v to format as %s but got %s", n, want, got)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[float64]string{
+		0:                "0B",
+		1023:             "1023B",
+		1024:             "1.0KiB",
+		1536:             "1.5KiB",
+		5583457485:       "5.2GiB",
+		3 * (1 << 40):    "3.0TiB",
+		2048 * (1 << 50): "2048.0PiB",
+	}
+	for n, want := range cases {
+		if got := formatBytes(n); got != want {
+			t.Errorf("Expected %v to format as %s but got %s", n, want, got)
+		}
+	}
+}
+
+func TestFormatBitRate(t *testing.T) {
+	cases := map[float64]string{
+		0:         "0bps",
+		999:       "999bps",
+		1500:      "1.5Kbps",
+		940000000: "940.0Mbps",
+		2.5e12:    "2.5Tbps",
+	}
+	for n, want := range cases {
+		if got := formatBitRate(n); got != want {
+			t.Errorf("Expected %v to format as %s but got %s", n, want, got)
+		}
+	}
+}
+
+func TestNetCounterChart(t *testing.T) {
+	samples := []float64{1, 2.5}
+	collector := CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		return samples, nil
+	})
+
+	name, _, _, kib := netCounterChart(&PoptopConfig{}, "Network IO", collector)
+	if values := collect(t, kib); name != "Network IO (KiB/s)" || values[0] != 1 || values[1] != 2.5 {
+		t.Errorf("Expected KiB/s to be charted as sampled but got %s %v", name, values)
+	}
+
+	name, _, format, bits := netCounterChart(&PoptopConfig{Bits: true}, "Network IO", collector)
+	if values := collect(t, bits); name != "Network IO (bit/s)" || values[0] != 8192 || values[1] != 20480 {
+		t.Errorf("Expected KiB/s to be charted in bit/s but got %s %v", name, values)
+	}
+	if format(8192) != "8.2Kbps" {
+		t.Errorf("Expected the title to show bit rates but got %s", format(8192))
+	}
+	if samples[0] != 1 {
+		t.Error("Expected the collector's samples to be left alone")
+	}
+
+	if name, _, _, _ := netCounterChart(&PoptopConfig{Bits: true, Cumulative: true}, "Network IO", collector); name != "Network IO (bits)" {
+		t.Errorf("Expected a total in bits but got %s", name)
+	}
+}
+
+func TestLoadColor(t *testing.T) {
+	if loadColor(3.5, 4) != ColorLoadOk || loadColor(4, 4) != ColorLoadOk {
+		t.Error("Expected load up to the CPU count not to be overloaded")
+	}
+	if loadColor(4.1, 4) != ColorLoadOverloaded {
+		t.Error("Expected load over the CPU count to be overloaded")
+	}
+	if loadColor(5, 0) != ColorLoadOk || loadColor(5, -1) != ColorLoadOk {
+		t.Error("Expected load not to be overloaded when the CPU count isn't known")
+	}
+}
+
+func TestLogScale(t *testing.T) {
+	for _, value := range []float64{0, 1, 9, 99, 12345.5} {
+		if got := fromLogScale(toLogScale(value)); math.Abs(got-value) > 1e-6 {
+			t.Errorf("Expected %v to round trip through the log scale but got %v", value, got)
+		}
+	}
+	if toLogScale(99) != 2 {
+		t.Errorf("Expected 99 to plot at 2 but got %v", toLogScale(99))
+	}
+	if toLogScale(-5) != 0 {
+		t.Errorf("Expected negative values to plot at 0 but got %v", toLogScale(-5))
+	}
+	if !math.IsNaN(toLogScale(math.NaN())) {
+		t.Error("Expected NaN gaps to stay gaps")
+	}
+}
+
+func TestSeriesAvg(t *testing.T) {
+	nan := math.NaN()
+	if avg, ok := seriesAvg([]float64{nan, nan, 2, nan, 4, 6}); !ok || avg != 4 {
+		t.Errorf("Expected the average of the values around the gaps to be 4 but got %v, %v", avg, ok)
+	}
+	if _, ok := seriesAvg([]float64{nan, nan}); ok {
+		t.Error("Expected no average for a series which is all gaps")
+	}
+
+	// the average line is clipped and log scaled like the values it's drawn against
+	chart := &lineChart{clipY: 100, logScale: true}
+	if scaled := chart.scale(500); scaled != toLogScale(100) {
+		t.Errorf("Expected 500 to be clipped to 100 then log scaled but got %v", scaled)
+	}
+}
+
+func TestBandValues(t *testing.T) {
+	nan := math.NaN()
+	lines := bandValues([]float64{0, 10, nan}, []float64{100, 10, nan}, 5)
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 lines but got %d", len(lines))
+	}
+
+	expected := []float64{0, 25, 50, 75, 100}
+	for i, line := range lines {
+		if line[0] != expected[i] || line[1] != 10 || !math.IsNaN(line[2]) {
+			t.Errorf("Expected line %d to be [%v 10 NaN] but got %v", i, expected[i], line)
+		}
+	}
+}
+
+func TestChartTitleSmoothing(t *testing.T) {
+	series := NewBoundedSeries(4)
+	series.AddValue(2.5)
+	format := func(v float64) string { return fmt.Sprintf("%.1f", v) }
+
+	config := &PoptopConfig{SmoothingSamples: 4}
+	if text := chartTitle(config, "CPU Load", format, titleEntry{"1min", ColorHot1, series}).Text(); text != " CPU Load (1min: 2.5) (smoothed x4) " {
+		t.Errorf("Expected the title to note the smoothing but got %q", text)
+	}
+
+	config.SmoothMedian = true
+	if text := chartTitle(config, "CPU Load", format, titleEntry{"1min", ColorHot1, series}).Text(); text != " CPU Load (1min: 2.5) (median x4) " {
+		t.Errorf("Expected the title to note the median smoothing but got %q", text)
+	}
+
+	config.SmoothingSamples = 1
+	if text := chartTitle(config, "CPU Load", format, titleEntry{"1min", ColorHot1, series}).Text(); text != " CPU Load (1min: 2.5) " {
+		t.Errorf("Expected raw samples to leave the smoothing out of the title but got %q", text)
+	}
+}
+
+func TestStopRemovedWidgets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	config := &PoptopConfig{Widgets: []int{WidgetTopNet}, widgetCancels: map[int]context.CancelFunc{WidgetTopNet: cancel}}
+	cache := map[int][]container.Option{WidgetTopNet: nil, WidgetCPULoad: nil}
+
+	stopRemovedWidgets(config, cache)
+	if ctx.Err() != nil || len(cache) != 2 {
+		t.Error("Expected a widget in the layout to keep running")
+	}
+
+	config.Widgets = []int{WidgetCPULoad}
+	stopRemovedWidgets(config, cache)
+	if _, cached := cache[WidgetTopNet]; ctx.Err() == nil || cached || len(config.widgetCancels) != 0 {
+		t.Error("Expected a removed widget to be stopped and dropped from the cache")
+	}
+	if _, cached := cache[WidgetCPULoad]; !cached {
+		t.Error("Expected widgets which keep running when removed to stay cached")
+	}
+}
@@ -0,0 +1,1003 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// A Collector fetches the latest values of a metric, e.g. the 1, 5 and 15 minute CPU load.
+// Returning nil values with a nil error means there is no sample yet, for example rate based
+// metrics need two readings before they can produce a value.
+type Collector interface {
+	Collect(ctx context.Context) ([]float64, error)
+}
+
+// Adapts a plain function into a Collector
+type CollectorFunc func(ctx context.Context) ([]float64, error)
+
+func (this CollectorFunc) Collect(ctx context.Context) ([]float64, error) {
+	return this(ctx)
+}
+
+// Returns the collector a chart should sample from, which is the live collector unless we're
+// replaying a recorded session. When recording, every sample is also written to the recording,
+// and when serving metrics the latest sample is kept for the server.
+func sampleSource(config *PoptopConfig, name string, live Collector) Collector {
+	source := live
+	if config.replay != nil {
+		source = config.replay.Collector(name)
+	} else if config.recorder != nil {
+		source = &recordingCollector{name, live, config.recorder}
+	}
+
+	if config.snapshot != nil {
+		source = &snapshotCollector{name, source, config.snapshot}
+	}
+	return source
+}
+
+// Returns the collector for a chart of counters, which samples rate unless the chart should show
+// cumulative totals, in which case it's the totals of counters divided by unit. Totals are recorded
+// under their own name so they aren't replayed into a chart expecting rates.
+func counterSource(config *PoptopConfig, name string, rate Collector, counters counterFunc, unit float64) Collector {
+	if config.Cumulative {
+		return sampleSource(config, name+"Total", newCumulativeCollector(counters, unit))
+	}
+	return sampleSource(config, name, primeRate(config, rate))
+}
+
+// Returns the rate collector having taken its first sample, which only primes the counters the
+// rates are worked out from, so the chart's first tick charts a rate rather than nothing. The next
+// sample is a tick away, as usual. Nothing is primed while replaying, as replayed samples are
+// already rates, or while paused, as a sample stepped through long after priming would be a huge
+// spike for collectors which assume it was a tick apart.
+func primeRate(config *PoptopConfig, rate Collector) Collector {
+	if config.SampleOnStart && config.replay == nil && !config.Paused() {
+		// if this fails the first tick primes instead
+		rate.Collect(context.Background())
+	}
+	return rate
+}
+
+// Check that a collector returned as many values as the chart expects, which guards against
+// replaying a recording made by a different version of poptop
+func checkValues(name string, values []float64, n int) error {
+	if len(values) != n {
+		return fmt.Errorf("Expected %d values for %s but got %d.\n", n, name, len(values))
+	}
+	return nil
+}
+
+// How many values the charts expect in each sample they replay, by the name it's recorded under
+// less the Total suffix of cumulative counters. The per interface network charts, and their packet
+// and error charts, are named for the interface and all take 2, while the GPU chart takes 3 per GPU.
+var recordedValueCounts = map[string]int{
+	"cpuLoad":         3,
+	"cpuPerc":         3,
+	"cpuTimes":        3,
+	"connections":     3,
+	"networkIOFamily": 4,
+	"diskIOPS":        2,
+	"diskIO":          2,
+	"switches":        2,
+	"diskLatency":     2,
+	"diskSpace":       2,
+	"fileLimits":      2,
+}
+
+// Checks that a recorded sample has as many values as the chart it's replayed into expects, so a
+// recording or stream from a different version of poptop is turned away as it's read. Samples no
+// chart replays are let through.
+func checkRecordedValues(sample *recordedSample) error {
+	name := strings.TrimSuffix(sample.Name, "Total")
+	if name == "gpu" {
+		if len(sample.Values)%3 != 0 {
+			return fmt.Errorf("Expected a multiple of 3 values for gpu but got %d.\n", len(sample.Values))
+		}
+		return nil
+	}
+
+	n, ok := recordedValueCounts[name]
+	if !ok && strings.HasPrefix(name, "networkIO") {
+		n, ok = 2, true
+	}
+	if ok && len(sample.Values) != n {
+		return fmt.Errorf("Expected %d values for %s but got %d.\n", n, sample.Name, len(sample.Values))
+	}
+	return nil
+}
+
+// One line of a recording file
+type recordedSample struct {
+	Time   time.Time  `json:"time"`
+	Name   string     `json:"name"`
+	Values []*float64 `json:"values"`
+}
+
+// Writes samples as JSON lines to a file, NaN values are stored as null
+type sampleRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newSampleRecorder(path string) (*sampleRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create recording file: %v\n", err)
+	}
+
+	return &sampleRecorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (this *sampleRecorder) Record(name string, values []float64, now time.Time) error {
+	sample := &recordedSample{Time: now, Name: name, Values: make([]*float64, len(values))}
+	for i := range values {
+		if !math.IsNaN(values[i]) {
+			sample.Values[i] = &values[i]
+		}
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	// charts may still be sampling while we shut down
+	if this.encoder == nil {
+		return nil
+	}
+	return this.encoder.Encode(sample)
+}
+
+func (this *sampleRecorder) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.encoder = nil
+	return this.file.Close()
+}
+
+type recordingCollector struct {
+	name     string
+	live     Collector
+	recorder *sampleRecorder
+}
+
+func (this *recordingCollector) Collect(ctx context.Context) ([]float64, error) {
+	values, err := this.live.Collect(ctx)
+	if err != nil || values == nil {
+		return values, err
+	}
+
+	return values, this.recorder.Record(this.name, values, time.Now())
+}
+
+// Plays back a recording, handing out each sample once as much time has passed since the
+// replay started as had passed in the original session, divided by the speed multiplier. Samples
+// can keep arriving while it plays, e.g. when they're piped in with --stdin.
+type replaySource struct {
+	mu      sync.Mutex
+	samples map[string][]*recordedSample
+	start   time.Time
+	began   time.Time
+	speed   float64
+}
+
+func newReplaySource(path string, speed float64) (*replaySource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open recording file: %v\n", err)
+	}
+	defer file.Close()
+
+	source := &replaySource{samples: map[string][]*recordedSample{}, speed: speed}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+
+	samples := []*recordedSample{}
+	for line := 1; scanner.Scan(); line++ {
+		sample := &recordedSample{}
+		if err := json.Unmarshal(scanner.Bytes(), sample); err != nil {
+			return nil, fmt.Errorf("Could not parse line %d of recording file: %v\n", line, err)
+		}
+		if err := checkRecordedValues(sample); err != nil {
+			return nil, fmt.Errorf("Could not replay line %d of recording file: %v", line, err)
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read recording file: %v\n", err)
+	}
+
+	source.Add(samples...)
+	return source, nil
+}
+
+// Queues samples to be played back
+func (this *replaySource) Add(samples ...*recordedSample) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	// the replay starts at the earliest of the first samples, anything added later that's due
+	// before then is handed out straight away
+	first := this.began.IsZero()
+	for _, sample := range samples {
+		if first && (this.start.IsZero() || sample.Time.Before(this.start)) {
+			this.start = sample.Time
+		}
+		this.samples[sample.Name] = append(this.samples[sample.Name], sample)
+	}
+	if first && len(samples) > 0 {
+		this.began = time.Now()
+	}
+}
+
+// Returns a collector that replays the samples recorded under the given name
+func (this *replaySource) Collector(name string) Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		this.mu.Lock()
+		samples := this.samples[name]
+		if len(samples) == 0 {
+			this.mu.Unlock()
+			return nil, nil
+		}
+		sample := samples[0]
+		this.samples[name] = samples[1:]
+		offset := time.Duration(float64(sample.Time.Sub(this.start)) / this.speed)
+		due := this.began.Add(offset)
+		this.mu.Unlock()
+
+		// wait until the sample is due
+		wait := time.Until(due)
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		values := make([]float64, len(sample.Values))
+		for i, v := range sample.Values {
+			if v == nil {
+				values[i] = math.NaN()
+			} else {
+				values[i] = *v
+			}
+		}
+		return values, nil
+	})
+}
+
+// Returns a replay for samples streamed in with Stream, which plays them back as they arrive
+func newStreamSource() *replaySource {
+	return &replaySource{samples: map[string][]*recordedSample{}, speed: 1}
+}
+
+// Adds samples to the replay as they're read from in, one JSON object per line, until the end
+// of the input. Each line is either a line of --json output, so that 'ssh host poptop --json' can
+// be piped into the charts, or a line of a --record recording. Lines which can't be parsed are
+// passed to onError and skipped.
+func (this *replaySource) Stream(in io.Reader, onError func(error)) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		samples, err := parseStreamedSamples(scanner.Bytes())
+		if err != nil {
+			onError(fmt.Errorf("Could not parse line %d of stdin: %v\n", line, err))
+			continue
+		}
+		this.Add(samples...)
+	}
+	return scanner.Err()
+}
+
+// Parses a streamed line of --json output or of a recording into chart samples
+func parseStreamedSamples(line []byte) ([]*recordedSample, error) {
+	recorded := &recordedSample{}
+	if err := json.Unmarshal(line, recorded); err != nil {
+		return nil, err
+	}
+	if recorded.Name != "" {
+		if err := checkRecordedValues(recorded); err != nil {
+			return nil, err
+		}
+		return []*recordedSample{recorded}, nil
+	}
+
+	sample := &jsonSample{}
+	if err := json.Unmarshal(line, sample); err != nil {
+		return nil, err
+	}
+	if sample.Timestamp.IsZero() {
+		return nil, fmt.Errorf("expected a timestamp as in --json output or a name as in a recording")
+	}
+	return sample.chartSamples(), nil
+}
+
+// Collects the 1, 5 and 15 minute CPU load averages
+func newLoadCollector() Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		loadAvg, err := load.AvgWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []float64{loadAvg.Load1, loadAvg.Load5, loadAvg.Load15}, nil
+	})
+}
+
+// Collects the min, average and max CPU busy % across all CPUs
+func newCpuCollector() Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		cpuAllPerc, err := cpu.PercentWithContext(ctx, 0, true)
+		if err != nil {
+			return nil, err
+		}
+		minMax := getMinMax(cpuAllPerc)
+		return []float64{minMax.min, getAvg(cpuAllPerc), minMax.max}, nil
+	})
+}
+
+// Turns the min, average and max CPU busy % from collector into the min, average and max idle %,
+// so the least idle CPU is the one that was the most busy
+func newCpuIdleCollector(collector Collector) Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		values, err := collector.Collect(ctx)
+		if err != nil || len(values) != 3 {
+			return values, err
+		}
+		return []float64{percentMax - values[2], percentMax - values[1], percentMax - values[0]}, nil
+	})
+}
+
+// Only Linux reports the time CPUs spend waiting on IO, elsewhere gopsutil always gives 0
+var iowaitReported = runtime.GOOS == "linux"
+
+// Returns the % of CPU time spent in user space (including niced processes), the kernel and
+// waiting on IO between two readings of the cumulative CPU times. The bool is false if no time
+// passed or the counters went backwards.
+func cpuBreakdown(last, current cpu.TimesStat) ([]float64, bool) {
+	total := current.Total() - last.Total()
+	if total <= 0 {
+		return nil, false
+	}
+
+	user := current.User + current.Nice - last.User - last.Nice
+	system := current.System - last.System
+	iowait := current.Iowait - last.Iowait
+	return []float64{user / total * 100, system / total * 100, iowait / total * 100}, true
+}
+
+// Collects the user, system and iowait % of CPU time across all CPUs from the CPU time counters
+func newCpuTimesCollector() Collector {
+	var last cpu.TimesStat
+	var primed bool
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		times, err := cpu.TimesWithContext(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(times) == 0 {
+			return nil, fmt.Errorf("No CPU times were reported.\n")
+		}
+
+		values, ok := cpuBreakdown(last, times[0])
+		last = times[0]
+		if !primed || !ok {
+			primed = true
+			return nil, nil
+		}
+		return values, nil
+	})
+}
+
+// Reads a set of cumulative counters such as the total bytes sent and received, which rate
+// collectors turn into per-second values. Tests supply fakes rather than reading the system.
+type counterFunc func(ctx context.Context) ([]uint64, error)
+
+// Returns the total bytes sent and received across the interfaces for which include returns true
+func netCounters(include func(iface string) bool) counterFunc {
+	return interfaceCounters(include, func(iostat net.IOCountersStat) (uint64, uint64) {
+		return iostat.BytesSent, iostat.BytesRecv
+	})
+}
+
+// Returns the total packets sent and received across the interfaces include returns true for
+func netPacketCounters(include func(iface string) bool) counterFunc {
+	return interfaceCounters(include, func(iostat net.IOCountersStat) (uint64, uint64) {
+		return iostat.PacketsSent, iostat.PacketsRecv
+	})
+}
+
+// Returns the total errors and dropped packets, both in and out, across the interfaces include returns true for
+func netErrorCounters(include func(iface string) bool) counterFunc {
+	return interfaceCounters(include, netErrorFields)
+}
+
+// Returns an interface's errors and dropped packets, both in and out
+func netErrorFields(iostat net.IOCountersStat) (uint64, uint64) {
+	return iostat.Errin + iostat.Errout, iostat.Dropin + iostat.Dropout
+}
+
+// Sums the sent and received counters picked out by fields across the included interfaces
+func interfaceCounters(include func(iface string) bool, fields func(net.IOCountersStat) (uint64, uint64)) counterFunc {
+	return func(ctx context.Context) ([]uint64, error) {
+		iostats, err := net.IOCountersWithContext(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		return sumInterfaceCounters(iostats, include, fields), nil
+	}
+}
+
+// Sums the sent and received counters picked out by fields across the interfaces include returns true for
+func sumInterfaceCounters(iostats []net.IOCountersStat, include func(iface string) bool, fields func(net.IOCountersStat) (uint64, uint64)) []uint64 {
+	var totalSent uint64
+	var totalRecv uint64
+
+	for _, iostat := range iostats {
+		if !include(iostat.Name) {
+			continue
+		}
+		sent, recv := fields(iostat)
+		totalSent += sent
+		totalRecv += recv
+	}
+
+	return []uint64{totalSent, totalRecv}
+}
+
+// The Linux kernel's IP statistics which, unlike the interface counters, break traffic down by address family
+const (
+	procNetstat = "/proc/net/netstat"
+	procSnmp6   = "/proc/net/snmp6"
+)
+
+// Returns the total IPv4 bytes sent and received, then the IPv6 bytes sent and received. These are
+// counted by the IP stack across every interface, so loopback traffic is included. Only Linux
+// reports these, elsewhere this returns an error.
+func familyCounters(ctx context.Context) ([]uint64, error) {
+	netstat, err := os.ReadFile(procNetstat)
+	if err != nil {
+		return nil, err
+	}
+	v4Recv, v4Sent, err := parseNetstatOctets(string(netstat))
+	if err != nil {
+		return nil, err
+	}
+
+	snmp6, err := os.ReadFile(procSnmp6)
+	if err != nil {
+		return nil, err
+	}
+	v6Recv, v6Sent, err := parseSnmp6Octets(string(snmp6))
+	if err != nil {
+		return nil, err
+	}
+
+	return []uint64{v4Sent, v4Recv, v6Sent, v6Recv}, nil
+}
+
+// Finds the IPv4 InOctets and OutOctets counters in the contents of /proc/net/netstat, where
+// each section is a line of names followed by a line of values, e.g.
+//
+//	IpExt: InNoRoutes InTruncatedPkts InMcastPkts OutMcastPkts InBcastPkts OutBcastPkts InOctets OutOctets
+//	IpExt: 0 0 12 0 3 0 123456 7890
+func parseNetstatOctets(netstat string) (in, out uint64, err error) {
+	lines := strings.Split(netstat, "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		names := strings.Fields(lines[i])
+		values := strings.Fields(lines[i+1])
+		if len(names) == 0 || names[0] != "IpExt:" || len(values) != len(names) {
+			continue
+		}
+
+		found := 0
+		for j := 1; j < len(names); j++ {
+			var dest *uint64
+			switch names[j] {
+			case "InOctets":
+				dest = &in
+			case "OutOctets":
+				dest = &out
+			default:
+				continue
+			}
+
+			if *dest, err = strconv.ParseUint(values[j], 10, 64); err != nil {
+				return 0, 0, fmt.Errorf("Could not parse %s in %s: %v\n", names[j], procNetstat, err)
+			}
+			found++
+		}
+
+		if found == 2 {
+			return in, out, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("Could not find IPv4 octet counters in %s.\n", procNetstat)
+}
+
+// Finds the Ip6InOctets and Ip6OutOctets counters in the contents of /proc/net/snmp6, which has
+// one name and value per line
+func parseSnmp6Octets(snmp6 string) (in, out uint64, err error) {
+	found := 0
+	for _, line := range strings.Split(snmp6, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		var dest *uint64
+		switch fields[0] {
+		case "Ip6InOctets":
+			dest = &in
+		case "Ip6OutOctets":
+			dest = &out
+		default:
+			continue
+		}
+
+		if *dest, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Could not parse %s in %s: %v\n", fields[0], procSnmp6, err)
+		}
+		found++
+	}
+
+	if found != 2 {
+		return 0, 0, fmt.Errorf("Could not find IPv6 octet counters in %s.\n", procSnmp6)
+	}
+	return in, out, nil
+}
+
+// The Linux kernel's scheduler and interrupt statistics
+const procStat = "/proc/stat"
+
+// Returns the total context switches and interrupts since boot. Only Linux reports these,
+// elsewhere this returns an error.
+func switchCounters(ctx context.Context) ([]uint64, error) {
+	stat, err := os.ReadFile(procStat)
+	if err != nil {
+		return nil, err
+	}
+	ctxt, intr, err := parseProcStatSwitches(string(stat))
+	if err != nil {
+		return nil, err
+	}
+	return []uint64{ctxt, intr}, nil
+}
+
+// Finds the context switch and interrupt counts in the contents of /proc/stat, where the first
+// number on the intr line is the total across every interrupt followed by the count of each, e.g.
+//
+//	intr 1234567 12 0 0 ...
+//	ctxt 7654321
+func parseProcStatSwitches(stat string) (ctxt, intr uint64, err error) {
+	found := 0
+	for _, line := range strings.Split(stat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		var dest *uint64
+		switch fields[0] {
+		case "ctxt":
+			dest = &ctxt
+		case "intr":
+			dest = &intr
+		default:
+			continue
+		}
+
+		if *dest, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Could not parse %s in %s: %v\n", fields[0], procStat, err)
+		}
+		found++
+	}
+
+	if found != 2 {
+		return 0, 0, fmt.Errorf("Could not find the ctxt and intr counters in %s.\n", procStat)
+	}
+	return ctxt, intr, nil
+}
+
+// Returns the total disk read and write operations
+func diskOpCounters(ctx context.Context) ([]uint64, error) {
+	iostats, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reads, writes uint64
+	for _, v := range iostats {
+		reads += v.ReadCount
+		writes += v.WriteCount
+	}
+	return []uint64{reads, writes}, nil
+}
+
+// Returns the total bytes read from and written to disk
+func diskByteCounters(ctx context.Context) ([]uint64, error) {
+	iostats, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var read, written uint64
+	for _, v := range iostats {
+		read += v.ReadBytes
+		written += v.WriteBytes
+	}
+	return []uint64{read, written}, nil
+}
+
+// Collects the sent and received kibibytes per second from bytes sent and received counters
+func newNetCollector(interval func() time.Duration, counters counterFunc) Collector {
+	return newSentRecvCollector(interval, counters, 1024)
+}
+
+// Collects the sent and received packets per second from packet counters
+func newNetPacketCollector(interval func() time.Duration, counters counterFunc) Collector {
+	return newSentRecvCollector(interval, counters, 1)
+}
+
+// Collects per second rates of sent and received counters, in units of unit, where interval returns
+// the interval the collector is sampled at
+func newSentRecvCollector(interval func() time.Duration, counters counterFunc, unit uint64) Collector {
+	var lastSent, lastRecv uint64
+	var primed bool
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		totals, err := counters(ctx)
+		if err != nil {
+			// the next sample is more than an interval on from the last, so only primes
+			primed = false
+			return nil, err
+		}
+
+		newSent, newRecv := totals[0], totals[1]
+
+		var values []float64
+		if primed {
+			scale := perSecond(interval()) / float64(unit)
+			values = []float64{counterDelta(newSent, lastSent) * scale, counterDelta(newRecv, lastRecv) * scale}
+		}
+		lastSent = newSent
+		lastRecv = newRecv
+		primed = true
+
+		return values, nil
+	})
+}
+
+// Returns what a change over interval is multiplied by to make it a per second rate. This is worked
+// out in floating point, as dividing the durations would truncate, e.g. to 0 for intervals over a
+// second and to 3 rather than 3.33 for 300ms.
+func perSecond(interval time.Duration) float64 {
+	return float64(time.Second) / float64(interval)
+}
+
+// Returns how much a counter went up since last, or 0 if it went backwards, e.g. when an interface
+// or disk goes away, rather than wrapping around to a huge spike
+func counterDelta(current, last uint64) float64 {
+	if current < last {
+		return 0
+	}
+	return float64(current - last)
+}
+
+// Collects disk read and write operations per second from operation counters, where interval
+// returns the interval the collector is sampled at
+func newDiskIOPSCollector(interval func() time.Duration, counters counterFunc) Collector {
+	var lastRead, lastWrite uint64
+	var primed bool
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		totals, err := counters(ctx)
+		if err != nil {
+			// the next sample is more than an interval on from the last, so only primes
+			primed = false
+			return nil, err
+		}
+
+		newRead, newWrite := totals[0], totals[1]
+
+		var values []float64
+		if primed {
+			values = []float64{
+				counterDelta(newRead, lastRead) * perSecond(interval()),
+				counterDelta(newWrite, lastWrite) * perSecond(interval()),
+			}
+		}
+		lastRead = newRead
+		lastWrite = newWrite
+		primed = true
+
+		return values, nil
+	})
+}
+
+// Collects IPv4 sent and received followed by IPv6 sent and received kibibytes per second from
+// the address family counters, using now to find the real time elapsed between samples
+func newFamilyNetCollector(counters counterFunc, now func() time.Time) Collector {
+	rates := make([]counterRate, 4)
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		totals, err := counters(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		t := now()
+		values := make([]float64, len(rates))
+		primed := true
+		for i := range rates {
+			rate, ok := rates[i].Rate(totals[i], t)
+			values[i] = rate / 1024
+			primed = primed && ok
+		}
+
+		if !primed {
+			return nil, nil
+		}
+		return values, nil
+	})
+}
+
+// Collects disk read and write throughput in kibibytes per second from byte counters,
+// using now to find the real time elapsed between samples
+func newDiskIOCollector(counters counterFunc, now func() time.Time) Collector {
+	var readRate, writeRate counterRate
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		totals, err := counters(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		t := now()
+		read, readOk := readRate.Rate(totals[0], t)
+		write, writeOk := writeRate.Rate(totals[1], t)
+		if !readOk || !writeOk {
+			return nil, nil
+		}
+		return []float64{read / 1024, write / 1024}, nil
+	})
+}
+
+// Collects the average milliseconds each disk read and write took since the last sample, from
+// counters of reads, ms reading, writes and ms writing. With no requests there's no wait so the
+// latency is 0, as iostat shows it.
+func newDiskLatencyCollector(counters counterFunc) Collector {
+	var last []uint64
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		totals, err := counters(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		previous := last
+		last = totals
+		if previous == nil {
+			return nil, nil
+		}
+
+		// a counter going backwards, e.g. when a disk goes away, counts as no requests
+		delta := func(i int) float64 {
+			if totals[i] < previous[i] {
+				return 0
+			}
+			return float64(totals[i] - previous[i])
+		}
+
+		values := make([]float64, 2)
+		for i := range values {
+			if ops := delta(i * 2); ops > 0 {
+				values[i] = delta(i*2+1) / ops
+			}
+		}
+		return values, nil
+	})
+}
+
+// Collects the bytes used and free on the filesystem mounted at path
+func newDiskSpaceCollector(path string) Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		usage, err := disk.UsageWithContext(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return []float64{float64(usage.Used), float64(usage.Free)}, nil
+	})
+}
+
+// The Linux kernel's count of allocated file handles, e.g. "1344	0	9223372036854775807"
+const procFileNr = "/proc/sys/fs/file-nr"
+
+// Returns the file handles in use and the system-wide limit on them. Only Linux reports these,
+// elsewhere this returns an error.
+func fileHandleCounts(ctx context.Context) ([]uint64, error) {
+	fileNr, err := os.ReadFile(procFileNr)
+	if err != nil {
+		return nil, err
+	}
+	used, limit, err := parseFileNr(string(fileNr))
+	if err != nil {
+		return nil, err
+	}
+	return []uint64{used, limit}, nil
+}
+
+// Parses the contents of /proc/sys/fs/file-nr, which are the number of allocated file handles,
+// the number of those which are free and the maximum, returning the handles in use and the maximum.
+// Kernels since 2.6 free handles straight away so the middle number is always 0.
+func parseFileNr(fileNr string) (used, limit uint64, err error) {
+	fields := strings.Fields(fileNr)
+	if len(fields) != 3 {
+		return 0, 0, fmt.Errorf("Expected 3 numbers in %s but got %q.\n", procFileNr, strings.TrimSpace(fileNr))
+	}
+
+	numbers := make([]uint64, 3)
+	for i, field := range fields {
+		if numbers[i], err = strconv.ParseUint(field, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("Could not parse %s: %v\n", procFileNr, err)
+		}
+	}
+	if numbers[2] == 0 || numbers[1] > numbers[0] {
+		return 0, 0, fmt.Errorf("Could not make sense of %s: %q.\n", procFileNr, strings.TrimSpace(fileNr))
+	}
+	return numbers[0] - numbers[1], numbers[2], nil
+}
+
+// Returns the percentage of inodes used on the mounted filesystem which has used the highest
+// share of its inodes. Filesystems which don't have a fixed number of inodes, e.g. btrfs, report
+// none and are skipped.
+func fullestInodeUsage(ctx context.Context) (float64, error) {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return 0, err
+	}
+
+	fullest := math.NaN()
+	for _, partition := range partitions {
+		usage, err := disk.UsageWithContext(ctx, partition.Mountpoint)
+		if err != nil || usage.InodesTotal == 0 {
+			continue
+		}
+		if math.IsNaN(fullest) || usage.InodesUsedPercent > fullest {
+			fullest = usage.InodesUsedPercent
+		}
+	}
+	return fullest, nil
+}
+
+// Collects the percentage of the system's file handle limit in use and the percentage of inodes
+// used on the fullest filesystem. The file handles are charted as a gap where they aren't
+// reported, e.g. on MacOS.
+func newFileLimitsCollector(handles counterFunc, inodes func(ctx context.Context) (float64, error)) Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		inodePerc, err := inodes(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		handlePerc := math.NaN()
+		if counts, err := handles(ctx); err == nil {
+			handlePerc = float64(counts[0]) / float64(counts[1]) * 100
+		}
+		return []float64{handlePerc, inodePerc}, nil
+	})
+}
+
+// Collects context switches and interrupts per second from their counters, using now to find the
+// real time elapsed between samples
+func newSwitchesCollector(counters counterFunc, now func() time.Time) Collector {
+	var ctxtRate, intrRate counterRate
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		totals, err := counters(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		t := now()
+		ctxt, ctxtOk := ctxtRate.Rate(totals[0], t)
+		intr, intrOk := intrRate.Rate(totals[1], t)
+		if !ctxtOk || !intrOk {
+			return nil, nil
+		}
+		return []float64{ctxt, intr}, nil
+	})
+}
+
+// Collects the running total of each counter since the first sample, divided by unit (e.g. 1024 for
+// KiB). A counter which goes backwards, e.g. when an interface goes away, adds nothing rather than
+// making the total drop.
+func newCumulativeCollector(counters counterFunc, unit float64) Collector {
+	var last, totals []uint64
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		current, err := counters(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if last == nil {
+			totals = make([]uint64, len(current))
+		} else {
+			for i := range current {
+				if current[i] >= last[i] {
+					totals[i] += current[i] - last[i]
+				}
+			}
+		}
+		last = current
+
+		values := make([]float64, len(totals))
+		for i, total := range totals {
+			values[i] = float64(total) / unit
+		}
+		return values, nil
+	})
+}
+
+// Collects the number of network connections in the ESTABLISHED, TIME_WAIT and LISTEN states
+func newConnectionsCollector() Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		conns, err := net.ConnectionsWithContext(ctx, "all")
+		if err != nil {
+			return nil, err
+		}
+
+		var nEstablished, nTimeWait, nListen int
+		for _, conn := range conns {
+			switch conn.Status {
+			case "ESTABLISHED":
+				nEstablished++
+			case "TIME_WAIT":
+				nTimeWait++
+			case "LISTEN":
+				nListen++
+			}
+		}
+
+		return []float64{float64(nEstablished), float64(nTimeWait), float64(nListen)}, nil
+	})
+}
+
+// Collects utilization %, VRAM used and VRAM total for each GPU, flattened into one slice
+func newGpuCollector(timeout time.Duration) Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		stats, err := getGpuStats(ctx, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		values := []float64{}
+		for _, stat := range stats {
+			values = append(values, stat.UtilPerc, stat.MemUsed, stat.MemTotal)
+		}
+		return values, nil
+	})
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// Returns a counterFunc which hands out each set of totals in turn
+func fakeCounters(totals ...[]uint64) counterFunc {
+	return func(ctx context.Context) ([]uint64, error) {
+		next := totals[0]
+		totals = totals[1:]
+		return next, nil
+	}
+}
+
+func collect(t *testing.T, collector Collector) []float64 {
+	values, err := collector.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return values
+}
+
+func TestNetCollector(t *testing.T) {
+	config := &PoptopConfig{SampleInterval: 500 * time.Millisecond}
+	collector := newNetCollector(config.CurrentSampleInterval, fakeCounters(
+		[]uint64{1024 * 1024, 2048 * 1024},
+		[]uint64{1024*1024 + 4096, 2048*1024 + 512},
+		[]uint64{1024*1024 + 4096, 2048*1024 + 512},
+		[]uint64{1024, 2048*1024 + 1536}))
+
+	// the first sample has nothing to compare against
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values for the first sample but got %v", values)
+	}
+
+	// 4 KiB sent and 0.5 KiB received over half a second
+	assertSliceEq(t, collect(t, collector), []float64{8, 1})
+
+	// nothing changed
+	assertSliceEq(t, collect(t, collector), []float64{0, 0})
+
+	// a counter going backwards, e.g. when an interface goes away, counts as nothing sent
+	assertSliceEq(t, collect(t, collector), []float64{0, 2})
+}
+
+func TestNetCollectorLongInterval(t *testing.T) {
+	config := &PoptopConfig{SampleInterval: 2 * time.Second}
+	collector := newSentRecvCollector(config.CurrentSampleInterval, fakeCounters([]uint64{100, 0}, []uint64{101, 4096}), 1024)
+	collect(t, collector)
+
+	// 1 byte and 4 KiB over two seconds, which truncating the interval to whole seconds charted as 0
+	assertSliceEq(t, collect(t, collector), []float64{0.5 / 1024, 2})
+}
+
+func TestPrimeRate(t *testing.T) {
+	counters := func() counterFunc {
+		return fakeCounters([]uint64{1024 * 1024, 0}, []uint64{1024*1024 + 2048, 1024})
+	}
+	config := &PoptopConfig{SampleInterval: time.Second, SampleOnStart: true}
+
+	// the first tick charts a rate over the interval since priming, not against a zero counter
+	collector := primeRate(config, newNetCollector(config.CurrentSampleInterval, counters()))
+	assertSliceEq(t, collect(t, collector), []float64{2, 1})
+
+	config.SampleOnStart = false
+	collector = primeRate(config, newNetCollector(config.CurrentSampleInterval, counters()))
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected the first tick to prime the collector but got %v", values)
+	}
+
+	// a sample stepped through while paused could be long after priming
+	config.SampleOnStart = true
+	config.sampleClock = newLiveInterval(time.Second)
+	config.sampleClock.SetPaused(true)
+	collector = primeRate(config, newNetCollector(config.CurrentSampleInterval, counters()))
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected nothing to be primed while paused but got %v", values)
+	}
+}
+
+func TestDiskIOPSCollector(t *testing.T) {
+	config := &PoptopConfig{SampleInterval: 250 * time.Millisecond}
+	collector := newDiskIOPSCollector(config.CurrentSampleInterval, fakeCounters(
+		[]uint64{0, 0},
+		[]uint64{10, 3},
+		[]uint64{15, 3},
+		[]uint64{5, 4}))
+
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values for the first sample but got %v", values)
+	}
+
+	// we were at zero operations, but that's still a real first reading
+	assertSliceEq(t, collect(t, collector), []float64{40, 12})
+	assertSliceEq(t, collect(t, collector), []float64{20, 0})
+
+	// reads going backwards count as none
+	assertSliceEq(t, collect(t, collector), []float64{0, 4})
+}
+
+func TestDiskIOPSCollectorTimeout(t *testing.T) {
+	config := &PoptopConfig{SampleInterval: time.Second}
+	next := fakeCounters([]uint64{0, 0}, []uint64{30, 6}, []uint64{40, 8})
+	calls := 0
+	collector := newDiskIOPSCollector(config.CurrentSampleInterval, func(ctx context.Context) ([]uint64, error) {
+		calls++
+		if calls == 2 {
+			return nil, context.DeadlineExceeded
+		}
+		return next(ctx)
+	})
+	collect(t, collector)
+
+	if _, err := collector.Collect(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the timeout to be passed on but got %v", err)
+	}
+
+	// the counters moved over two intervals, so rather than a doubled rate the sample only primes
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values after a timeout but got %v", values)
+	}
+	assertSliceEq(t, collect(t, collector), []float64{10, 2})
+}
+
+func TestDiskIOPSCollectorIntervals(t *testing.T) {
+	cases := []struct {
+		interval time.Duration
+		expected []float64
+	}{
+		{2 * time.Second, []float64{5, 1.5}},
+		{10 * time.Second, []float64{1, 0.3}},
+		{400 * time.Millisecond, []float64{25, 7.5}},
+	}
+	for _, c := range cases {
+		config := &PoptopConfig{SampleInterval: c.interval}
+		collector := newDiskIOPSCollector(config.CurrentSampleInterval, fakeCounters([]uint64{0, 0}, []uint64{10, 3}))
+		collect(t, collector)
+		assertSliceEq(t, collect(t, collector), c.expected)
+	}
+}
+
+func TestDiskIOCollector(t *testing.T) {
+	start := time.Unix(1000, 0)
+	times := []time.Time{start, start.Add(500 * time.Millisecond), start.Add(2500 * time.Millisecond)}
+	now := func() time.Time {
+		next := times[0]
+		times = times[1:]
+		return next
+	}
+
+	collector := newDiskIOCollector(fakeCounters(
+		[]uint64{4096, 0},
+		[]uint64{4096 + 8192, 1024},
+		[]uint64{4096 + 8192 + 3072, 1024}), now)
+
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values for the first sample but got %v", values)
+	}
+
+	// the rate uses the real elapsed time rather than the configured interval
+	assertSliceEq(t, collect(t, collector), []float64{16, 2})
+	assertSliceEq(t, collect(t, collector), []float64{1.5, 0})
+}
+
+func TestCollectorErrors(t *testing.T) {
+	failure := errors.New("counters unavailable")
+	failing := func(ctx context.Context) ([]uint64, error) {
+		return nil, failure
+	}
+
+	config := &PoptopConfig{SampleInterval: time.Second}
+	collectors := []Collector{
+		newNetCollector(config.CurrentSampleInterval, failing),
+		newDiskIOPSCollector(config.CurrentSampleInterval, failing),
+		newDiskIOCollector(failing, time.Now),
+	}
+
+	for _, collector := range collectors {
+		if _, err := collector.Collect(context.Background()); !errors.Is(err, failure) {
+			t.Errorf("Expected the counter error but got %v", err)
+		}
+	}
+
+	// the sample after an error is more than an interval on from the last, so it only primes
+	calls := 0
+	flaky := func(ctx context.Context) ([]uint64, error) {
+		calls++
+		if calls == 2 {
+			return nil, failure
+		}
+		return []uint64{uint64(calls) * 1024, 0}, nil
+	}
+	collector := newNetCollector(config.CurrentSampleInterval, flaky)
+	collect(t, collector)
+	if _, err := collector.Collect(context.Background()); !errors.Is(err, failure) {
+		t.Errorf("Expected the counter error but got %v", err)
+	}
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected the sample after an error to only prime but got %v", values)
+	}
+	assertSliceEq(t, collect(t, collector), []float64{1, 0})
+}
+
+func TestParseNetstatOctets(t *testing.T) {
+	netstat := `TcpExt: SyncookiesSent SyncookiesRecv
+TcpExt: 0 0
+IpExt: InNoRoutes InTruncatedPkts InOctets OutOctets InNoECTPkts
+IpExt: 0 0 123456 7890 42
+`
+	in, out, err := parseNetstatOctets(netstat)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if in != 123456 || out != 7890 {
+		t.Errorf("Expected 123456 in and 7890 out but got %d and %d", in, out)
+	}
+
+	if _, _, err := parseNetstatOctets("TcpExt: SyncookiesSent\nTcpExt: 0\n"); err == nil {
+		t.Error("Expected an error when the IpExt section is missing")
+	}
+}
+
+func TestParseSnmp6Octets(t *testing.T) {
+	snmp6 := `Ip6InReceives                   	100
+Ip6InOctets                     	2048
+Ip6OutOctets                    	1024
+Icmp6InMsgs                     	3
+`
+	in, out, err := parseSnmp6Octets(snmp6)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if in != 2048 || out != 1024 {
+		t.Errorf("Expected 2048 in and 1024 out but got %d and %d", in, out)
+	}
+
+	if _, _, err := parseSnmp6Octets("Ip6InOctets 2048\n"); err == nil {
+		t.Error("Expected an error when Ip6OutOctets is missing")
+	}
+}
+
+func TestNetErrorCounters(t *testing.T) {
+	iostats := []net.IOCountersStat{
+		{Name: "lo", Errin: 100, Dropin: 100},
+		{Name: "eth0", Errin: 1, Errout: 2, Dropin: 3, Dropout: 4, PacketsSent: 1000},
+		{Name: "wlan0", Errout: 5, Dropin: 6},
+	}
+
+	cases := []struct {
+		exclude []string
+		errors  uint64
+		drops   uint64
+	}{
+		{nil, 108, 113},
+		{[]string{"lo"}, 8, 13},
+		{[]string{"lo", "wlan*"}, 3, 7},
+		{[]string{"*"}, 0, 0},
+	}
+
+	for _, c := range cases {
+		include := func(iface string) bool { return !interfaceExcluded(c.exclude, iface) }
+		totals := sumInterfaceCounters(iostats, include, netErrorFields)
+		if totals[0] != c.errors || totals[1] != c.drops {
+			t.Errorf("Expected %d errors and %d drops excluding %v but got %v", c.errors, c.drops, c.exclude, totals)
+		}
+	}
+}
+
+func TestFamilyNetCollector(t *testing.T) {
+	start := time.Unix(1000, 0)
+	times := []time.Time{start, start.Add(2 * time.Second)}
+	now := func() time.Time {
+		next := times[0]
+		times = times[1:]
+		return next
+	}
+
+	collector := newFamilyNetCollector(fakeCounters(
+		[]uint64{0, 1024, 0, 0},
+		[]uint64{4096, 1024 + 2048, 8192, 0}), now)
+
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values for the first sample but got %v", values)
+	}
+	assertSliceEq(t, collect(t, collector), []float64{2, 1, 4, 0})
+}
+
+func TestCpuIdleCollector(t *testing.T) {
+	busy := CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		return []float64{10, 40, 95}, nil
+	})
+
+	// the least idle CPU is the busiest one
+	assertSliceEq(t, collect(t, newCpuIdleCollector(busy)), []float64{5, 60, 90})
+}
+
+func TestCpuBreakdown(t *testing.T) {
+	last := cpu.TimesStat{User: 100, Nice: 10, System: 50, Idle: 800, Iowait: 40}
+	current := cpu.TimesStat{User: 130, Nice: 20, System: 60, Idle: 860, Iowait: 70}
+
+	// 140 seconds passed of which 40 were user or nice, 10 system and 30 waiting on IO
+	values, ok := cpuBreakdown(last, current)
+	if !ok {
+		t.Fatal("Expected a breakdown")
+	}
+	assertSliceEq(t, values, []float64{40.0 / 140 * 100, 10.0 / 140 * 100, 30.0 / 140 * 100})
+
+	if _, ok := cpuBreakdown(current, current); ok {
+		t.Error("Expected no breakdown when no time has passed")
+	}
+	if _, ok := cpuBreakdown(current, last); ok {
+		t.Error("Expected no breakdown when the counters went backwards")
+	}
+}
+
+func TestCumulativeCollector(t *testing.T) {
+	collector := newCumulativeCollector(fakeCounters(
+		[]uint64{1024, 5000},
+		[]uint64{3072, 6024},
+		[]uint64{1024, 8072}, // the first counter went backwards
+		[]uint64{2048, 8072},
+	), 1024)
+
+	expected := [][]float64{{0, 0}, {2, 1}, {2, 3}, {3, 3}}
+	for i, want := range expected {
+		values := collect(t, collector)
+		if len(values) != 2 || values[0] != want[0] || values[1] != want[1] {
+			t.Errorf("Sample %d: expected %v but got %v", i, want, values)
+		}
+	}
+}
+
+func TestNetPacketCollector(t *testing.T) {
+	config := &PoptopConfig{SampleInterval: 500 * time.Millisecond}
+	collector := newNetPacketCollector(config.CurrentSampleInterval, fakeCounters(
+		[]uint64{1000, 5000},
+		[]uint64{1010, 5300}))
+
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values for the first sample but got %v", values)
+	}
+
+	// 10 packets sent and 300 received over half a second
+	assertSliceEq(t, collect(t, collector), []float64{20, 600})
+}
+
+func TestParseProcStatSwitches(t *testing.T) {
+	stat := "cpu  10 0 20 300 4 0 1 0 0 0\ncpu0 10 0 20 300 4 0 1 0 0 0\nintr 1234567 12 0 0 9\nctxt 7654321\nbtime 1700000000\n"
+	ctxt, intr, err := parseProcStatSwitches(stat)
+	if err != nil || ctxt != 7654321 || intr != 1234567 {
+		t.Errorf("Expected 7654321 context switches and 1234567 interrupts but got %d, %d, %v", ctxt, intr, err)
+	}
+
+	if _, _, err := parseProcStatSwitches("cpu  10 0 20 300\nctxt 7654321\n"); err == nil {
+		t.Error("Expected an error when the intr line is missing")
+	}
+}
+
+func TestSwitchesCollector(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time {
+		now = now.Add(500 * time.Millisecond)
+		return now
+	}
+	collector := newSwitchesCollector(fakeCounters([]uint64{1000, 500}, []uint64{1600, 550}), clock)
+
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values for the first sample but got %v", values)
+	}
+	assertSliceEq(t, collect(t, collector), []float64{1200, 100})
+}
+
+func TestDiskLatencyCollector(t *testing.T) {
+	collector := newDiskLatencyCollector(fakeCounters(
+		[]uint64{100, 1000, 50, 2000},
+		[]uint64{110, 1040, 50, 2000},
+		[]uint64{100, 1040, 60, 2100},
+	))
+
+	if values := collect(t, collector); values != nil {
+		t.Errorf("Expected no values for the first sample but got %v", values)
+	}
+
+	// 10 reads taking 40ms, and no writes so no wait
+	assertSliceEq(t, collect(t, collector), []float64{4, 0})
+
+	// reads going backwards count as none, 10 writes taking 100ms
+	assertSliceEq(t, collect(t, collector), []float64{0, 10})
+}
+
+func TestStreamSource(t *testing.T) {
+	in := strings.NewReader(`{"timestamp":"2022-10-01T12:00:00Z","load":{"load1":1.5,"load5":1,"load15":0.5},"gpu":[{"util_perc":40,"vram_perc":25}]}
+
+not json
+{"time":"2022-10-01T12:00:00.5Z","name":"cpuLoad","values":[2,null,0.5]}
+{"top_cpu":[]}
+{"time":"2022-10-01T12:00:01Z","name":"cpuLoad","values":[1,2]}
+`)
+	source := newStreamSource()
+	errs := []error{}
+	if err := source.Stream(in, func(err error) { errs = append(errs, err) }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(errs) != 3 || !strings.Contains(errs[0].Error(), "line 3") || !strings.Contains(errs[1].Error(), "line 5") ||
+		!strings.Contains(errs[2].Error(), "line 6") {
+		t.Errorf("Expected the lines which aren't samples the charts take to be reported but got %v", errs)
+	}
+
+	// streamed samples are played back with their original timing, from when the first arrived
+	source.began = time.Now().Add(-time.Second)
+	load := source.Collector("cpuLoad")
+	assertSliceEq(t, collect(t, load), []float64{1.5, 1, 0.5})
+	values := collect(t, load)
+	if len(values) != 3 || values[0] != 2 || !math.IsNaN(values[1]) {
+		t.Errorf("Expected the recorded sample with a gap but got %v", values)
+	}
+	if values := collect(t, load); values != nil {
+		t.Errorf("Expected no more samples but got %v", values)
+	}
+	assertSliceEq(t, collect(t, source.Collector("gpu")), []float64{40, 25, 100})
+}
+
+func TestCheckRecordedValues(t *testing.T) {
+	cases := []struct {
+		name  string
+		count int
+		valid bool
+	}{
+		{"cpuLoad", 3, true},
+		{"cpuLoad", 2, false},
+		{"networkIOFamilyTotal", 4, true},
+		{"networkIOFamily", 2, false},
+		{"networkIO_eth0", 2, true},
+		{"networkIO_eth0Packets", 3, false},
+		{"diskIOTotal", 2, true},
+		{"gpu", 6, true},
+		{"gpu", 4, false},
+		{"unknown", 5, true},
+	}
+
+	for _, c := range cases {
+		sample := &recordedSample{Name: c.name, Values: make([]*float64, c.count)}
+		if err := checkRecordedValues(sample); (err == nil) != c.valid {
+			t.Errorf("Expected %d values for %s to be valid: %v but got %v", c.count, c.name, c.valid, err)
+		}
+	}
+}
+
+func TestParseFileNr(t *testing.T) {
+	used, limit, err := parseFileNr("1344\t0\t9223372036854775807\n")
+	if err != nil || used != 1344 || limit != 9223372036854775807 {
+		t.Errorf("Expected 1344 handles of 9223372036854775807 but got %d, %d, %v", used, limit, err)
+	}
+
+	// older kernels count freed handles which are still allocated
+	used, limit, err = parseFileNr("8192\t2048\t65536\n")
+	if err != nil || used != 6144 || limit != 65536 {
+		t.Errorf("Expected 6144 handles of 65536 but got %d, %d, %v", used, limit, err)
+	}
+
+	for _, fileNr := range []string{"", "1344\t0\n", "1344\tx\t65536\n", "1344\t0\t0\n"} {
+		if _, _, err := parseFileNr(fileNr); err == nil {
+			t.Errorf("Expected an error parsing %q", fileNr)
+		}
+	}
+}
+
+func TestFileLimitsCollector(t *testing.T) {
+	inodes := func(ctx context.Context) (float64, error) {
+		return 12.5, nil
+	}
+	collector := newFileLimitsCollector(fakeCounters([]uint64{1000, 4000}), inodes)
+	assertSliceEq(t, collect(t, collector), []float64{25, 12.5})
+
+	// without file handle counts, e.g. on MacOS, only inodes are charted
+	unsupported := func(ctx context.Context) ([]uint64, error) {
+		return nil, errors.New("not supported")
+	}
+	values := collect(t, newFileLimitsCollector(unsupported, inodes))
+	if len(values) != 2 || !math.IsNaN(values[0]) || values[1] != 12.5 {
+		t.Errorf("Expected a gap for the file handles but got %v", values)
+	}
+}
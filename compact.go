@@ -0,0 +1,114 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/private/canvas"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+	"github.com/mum4k/termdash/widgetapi"
+	"github.com/mum4k/termdash/widgets/sparkline"
+)
+
+// A chart drawn as a stack of sparklines, one per series, for compact layouts. Sparklines
+// don't have axes so the chart's title is relied on to show the current values and units.
+type sparkStack struct {
+	mu     sync.Mutex
+	names  []string // series names in the order they were first set, drawn top to bottom
+	sparks map[string]*sparkline.SparkLine
+}
+
+func newSparkStack() *sparkStack {
+	return &sparkStack{
+		sparks: map[string]*sparkline.SparkLine{},
+	}
+}
+
+// Converts a series into sparkline data. Sparklines take ints and scale to the visible
+// max, so we multiply up to keep the precision of small values like CPU load.
+func sparklineValues(values []float64) []int {
+	data := make([]int, 0, len(values))
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			data = append(data, int(v*100))
+		}
+	}
+	return data
+}
+
+func (this *sparkStack) Series(name string, values []float64, color cell.Color) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	spark, ok := this.sparks[name]
+	if !ok {
+		var err error
+		spark, err = sparkline.New(sparkline.Color(color))
+		if err != nil {
+			return err
+		}
+		this.sparks[name] = spark
+		this.names = append(this.names, name)
+	}
+
+	spark.Clear()
+	return spark.Add(sparklineValues(values), sparkline.Color(color))
+}
+
+// Splits the canvas into equal rows and draws one sparkline in each.
+// Implements widgetapi.Widget.Draw.
+func (this *sparkStack) Draw(cvs *canvas.Canvas, meta *widgetapi.Meta) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if len(this.names) == 0 {
+		return nil
+	}
+
+	ar := cvs.Area()
+	rowHeight := max(1, ar.Dy()/len(this.names))
+
+	for i, name := range this.names {
+		minY := ar.Min.Y + i*rowHeight
+		if minY >= ar.Max.Y {
+			break
+		}
+
+		maxY := min(minY+rowHeight, ar.Max.Y)
+		if i == len(this.names)-1 {
+			maxY = ar.Max.Y // the last row takes any remainder
+		}
+
+		rowCvs, err := canvas.New(image.Rect(ar.Min.X, minY, ar.Max.X, maxY))
+		if err != nil {
+			return err
+		}
+		if err := this.sparks[name].Draw(rowCvs, meta); err != nil {
+			return err
+		}
+		if err := rowCvs.CopyTo(cvs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Implements widgetapi.Widget.Keyboard.
+func (this *sparkStack) Keyboard(k *terminalapi.Keyboard, meta *widgetapi.EventMeta) error {
+	return nil
+}
+
+// Implements widgetapi.Widget.Mouse.
+func (this *sparkStack) Mouse(m *terminalapi.Mouse, meta *widgetapi.EventMeta) error {
+	return nil
+}
+
+// Implements widgetapi.Widget.Options.
+func (this *sparkStack) Options() widgetapi.Options {
+	return widgetapi.Options{
+		MinimumSize: image.Point{1, 1},
+	}
+}
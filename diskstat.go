@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Where the disk charts get their counters from. gopsutil reads the kernel's per-disk counters, but
+// on MacOS builds without cgo it can't, so there we fall back to parsing iostat. iostat only counts
+// transfers and megabytes, so it can't tell reads from writes.
+type diskSource struct {
+	name  string // shown in the chart titles if we had to fall back
+	ops   counterFunc
+	bytes counterFunc
+	split bool // true if the counters are read then write, otherwise the first counter is the total
+}
+
+var gopsutilDiskSource = &diskSource{ops: diskOpCounters, bytes: diskByteCounters, split: true}
+
+// Picks the source for the disk charts, which is gopsutil unless it has nothing to report on MacOS
+// and iostat is available
+func chooseDiskSource(ctx context.Context, config *PoptopConfig) *diskSource {
+	if runtime.GOOS != "darwin" || config.replay != nil {
+		return gopsutilDiskSource
+	}
+
+	if stats, err := disk.IOCountersWithContext(ctx); err == nil && len(stats) > 0 {
+		return gopsutilDiskSource
+	}
+	if _, err := exec.LookPath("iostat"); err != nil {
+		return gopsutilDiskSource
+	}
+
+	counters := func(ctx context.Context) (uint64, uint64, error) {
+		out, err := commandWithContext(ctx, config.CommandTimeout, "iostat", "-d", "-I", "-c", "1")
+		if err != nil {
+			return 0, 0, err
+		}
+		return parseIostat(string(out))
+	}
+
+	return &diskSource{
+		name: "iostat",
+		ops: func(ctx context.Context) ([]uint64, error) {
+			transfers, _, err := counters(ctx)
+			return []uint64{transfers, 0}, err
+		},
+		bytes: func(ctx context.Context) ([]uint64, error) {
+			_, bytes, err := counters(ctx)
+			return []uint64{bytes, 0}, err
+		},
+	}
+}
+
+// Returns the chart title for a disk chart along with the title entries for its series, which are
+// read and write unless the source only gives the total, drawn in readColor and writeColor
+func (this *diskSource) title(name string, read, write *BoundedSeries, readColor, writeColor cell.Color) (string, []titleEntry) {
+	if this.name != "" {
+		name = fmt.Sprintf("%s via %s", name, this.name)
+	}
+	if !this.split {
+		return name, []titleEntry{{"total", readColor, read}}
+	}
+	return name, []titleEntry{{"read", readColor, read}, {"write", writeColor, write}}
+}
+
+// Sums the transfers and bytes across every disk in the output of 'iostat -d -I', which on MacOS has
+// a line of disk names, a line of column names repeated for each disk, then the totals since boot, e.g.
+//
+//	          disk0               disk4
+//	KB/t  xfrs     MB     KB/t  xfrs   MB
+//	23.51 4331143 99435.54 12.00  3   0.04
+func parseIostat(out string) (uint64, uint64, error) {
+	lines := strings.Split(out, "\n")
+
+	for i := 0; i+1 < len(lines); i++ {
+		columns := strings.Fields(lines[i])
+		if len(columns) == 0 || columns[0] != "KB/t" {
+			continue
+		}
+
+		values := strings.Fields(lines[i+1])
+		if len(values) != len(columns) || len(columns)%3 != 0 {
+			return 0, 0, fmt.Errorf("Could not parse iostat output, expected %d values but got %d.\n", len(columns), len(values))
+		}
+
+		var transfers uint64
+		var megabytes float64
+		for j := 0; j < len(columns); j += 3 {
+			if columns[j+1] != "xfrs" || columns[j+2] != "MB" {
+				return 0, 0, fmt.Errorf("Could not parse iostat output, unexpected columns %s %s.\n", columns[j+1], columns[j+2])
+			}
+
+			n, err := strconv.ParseUint(values[j+1], 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("Could not parse iostat transfers: %v\n", err)
+			}
+			mb, err := strconv.ParseFloat(values[j+2], 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("Could not parse iostat megabytes: %v\n", err)
+			}
+
+			transfers += n
+			megabytes += mb
+		}
+
+		return transfers, uint64(megabytes * 1024 * 1024), nil
+	}
+
+	return 0, 0, fmt.Errorf("Could not find any disks in iostat output.\n")
+}
+
+// The Linux kernel's per-disk counters
+const procDiskstats = "/proc/diskstats"
+
+// Returns the total reads, milliseconds spent reading, writes and milliseconds spent writing across
+// every disk, for working out the average latency of each request. On Linux these come straight from
+// /proc/diskstats, elsewhere from gopsutil, which on MacOS needs cgo. iostat can't stand in as it
+// doesn't report how long requests take.
+func diskLatencyCounters(ctx context.Context) ([]uint64, error) {
+	if runtime.GOOS == "linux" {
+		stats, err := os.ReadFile(procDiskstats)
+		if err != nil {
+			return nil, err
+		}
+		return parseDiskstats(string(stats))
+	}
+
+	iostats, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(iostats) == 0 {
+		return nil, fmt.Errorf("Could not find any disk timings.\n")
+	}
+
+	totals := make([]uint64, 4)
+	for _, v := range iostats {
+		totals[0] += v.ReadCount
+		totals[1] += v.ReadTime
+		totals[2] += v.WriteCount
+		totals[3] += v.WriteTime
+	}
+	return totals, nil
+}
+
+// Sums the reads, milliseconds reading, writes and milliseconds writing across every device in the
+// contents of /proc/diskstats, which has a line per device of its major and minor numbers, name then
+// counters, e.g.
+//
+//	8       0 sda 171464 3488 9079484 84084 274282 160839 14174474 287834 0 302132 399884
+//
+// Like the other disk charts partitions are counted along with their disks, which doesn't skew the
+// average latency as their requests and time are counted twice alike.
+func parseDiskstats(stats string) ([]uint64, error) {
+	totals := make([]uint64, 4)
+	found := false
+
+	for _, line := range strings.Split(stats, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 11 {
+			return nil, fmt.Errorf("Could not parse %s, expected at least 11 fields but got %d.\n", procDiskstats, len(fields))
+		}
+
+		// reads, then three fields later ms reading, writes and three later ms writing
+		for i, column := range []int{3, 6, 7, 10} {
+			n, err := strconv.ParseUint(fields[column], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse the counters for %s in %s: %v\n", fields[2], procDiskstats, err)
+			}
+			totals[i] += n
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Could not find any disks in %s.\n", procDiskstats)
+	}
+	return totals, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIostat(t *testing.T) {
+	iostat := `              disk0               disk4
+    KB/t  xfrs   MB     KB/t  xfrs   MB
+   23.51 4331143 99435.50    12.00     3  0.50
+`
+	transfers, bytes, err := parseIostat(iostat)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if transfers != 4331146 {
+		t.Errorf("Expected 4331146 transfers but got %d", transfers)
+	}
+	if bytes != 99436*1024*1024 {
+		t.Errorf("Expected %d bytes but got %d", 99436*1024*1024, bytes)
+	}
+
+	single := "          disk0 \n    KB/t  xfrs   MB \n   4.00  10  0.04 \n"
+	if transfers, _, err := parseIostat(single); err != nil || transfers != 10 {
+		t.Errorf("Expected 10 transfers from a single disk but got %d, %v", transfers, err)
+	}
+
+	bad := []string{
+		"",
+		"disk0\nKB/t xfrs MB\n",
+		"disk0\nKB/t xfrs MB\n1.0 2\n",
+		"disk0\nKB/t tps MB/s\n1.0 2 3.0\n",
+		"disk0\nKB/t xfrs MB\n1.0 many 3.0\n",
+	}
+	for _, out := range bad {
+		if _, _, err := parseIostat(out); err == nil {
+			t.Errorf("Expected an error parsing %q", out)
+		}
+	}
+}
+
+func TestDiskSourceTitle(t *testing.T) {
+	name, entries := gopsutilDiskSource.title("Disk IOPS", nil, nil, ColorRead, ColorWrite)
+	if name != "Disk IOPS" || len(entries) != 2 || entries[0].label != "read" || entries[1].label != "write" {
+		t.Errorf("Expected read and write entries for gopsutil but got %q %v", name, entries)
+	}
+
+	iostat := &diskSource{name: "iostat"}
+	name, entries = iostat.title("Disk IOPS", nil, nil, ColorRead, ColorWrite)
+	if !strings.HasSuffix(name, "via iostat") || len(entries) != 1 || entries[0].label != "total" {
+		t.Errorf("Expected a single total entry via iostat but got %q %v", name, entries)
+	}
+}
+
+func TestParseDiskstats(t *testing.T) {
+	stats := `   8       0 sda 171464 3488 9079484 84084 274282 160839 14174474 287834 0 302132 399884 0 0 0 0
+   8       1 sda1 100 0 800 50 200 0 1600 400 0 500 450
+ 259       0 nvme0n1 1000 0 8000 2000 500 0 4000 1500 0 3000 3500 0 0 0 0 10 20
+`
+	expected := []uint64{172564, 86134, 274982, 289734}
+	totals, err := parseDiskstats(stats)
+	if err != nil || len(totals) != len(expected) {
+		t.Fatalf("Expected the summed reads, read ms, writes and write ms but got %v, %v", totals, err)
+	}
+	for i := range expected {
+		if totals[i] != expected[i] {
+			t.Errorf("Expected %v but got %v", expected, totals)
+			break
+		}
+	}
+
+	if _, err := parseDiskstats("   8       0 sda 171464 3488\n"); err == nil {
+		t.Error("Expected an error for a truncated line")
+	}
+	if _, err := parseDiskstats("   8       0 sda 171464 3488 9079484 x 274282 160839 14174474 287834 0 302132 399884\n"); err == nil {
+		t.Error("Expected an error for a counter which isn't a number")
+	}
+	if _, err := parseDiskstats("\n"); err == nil {
+		t.Error("Expected an error when there are no disks")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"image"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/private/canvas"
+	"github.com/mum4k/termdash/widgetapi"
+)
+
+// Wraps a widget placed in the layout to note when termdash gives its container the keyboard
+// focus, which Tab and mouse clicks move. termdash doesn't say which container is focused, only
+// each widget as it's drawn, so the widget drawn focused last is recorded in config.focused and
+// FocusedWidget reads it from there.
+type focusWatcher struct {
+	widgetapi.Widget
+
+	config *PoptopConfig
+	widget int    // the widget this is part of, e.g. WidgetNetworkIO for each of its charts
+	id     string // the ID of the container it's placed in, so the focus can be put back
+	inner  bool   // placed without a border inside the widget's container, see makeInnerContainer
+}
+
+func newFocusWatcher(inner widgetapi.Widget, config *PoptopConfig, widget int) *focusWatcher {
+	return &focusWatcher{Widget: inner, config: config, widget: widget}
+}
+
+// Draw implements widgetapi.Widget.Draw, noting whether the widget is focused. Without a border to
+// color the top row is highlighted instead, otherwise the focus wouldn't show at all.
+func (this *focusWatcher) Draw(cvs *canvas.Canvas, meta *widgetapi.Meta) error {
+	if meta.Focused {
+		this.config.focused.Store(this)
+	} else {
+		// only clears this widget's focus, another may have taken it since
+		this.config.focused.CompareAndSwap(this, nil)
+	}
+
+	if err := this.Widget.Draw(cvs, meta); err != nil {
+		return err
+	}
+
+	if meta.Focused && (this.inner || this.config.BorderStyle(this.widget) == linestyle.None || this.config.HideTitles.Load()) {
+		area := cvs.Area()
+		return cvs.SetAreaCellOpts(image.Rect(area.Min.X, area.Min.Y, area.Max.X, area.Min.Y+1), cell.BgColor(ColorWidgetFocused))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Watches the latest values of each chart for crossing the threshold configured for it, so that
+// sampling can be paused at the moment of an intermittent spike. A chart only triggers as it
+// crosses its threshold, so resuming while a value stays above it doesn't immediately freeze again.
+type freezer struct {
+	mu         sync.Mutex
+	thresholds map[int]float64
+	above      map[int]bool // whether any of the chart's values were above its threshold last time
+}
+
+func newFreezer(thresholds map[int]float64) *freezer {
+	return &freezer{thresholds: thresholds, above: map[int]bool{}}
+}
+
+// Returns true if any of values is above the widget's threshold when none were last time. A nil
+// freezer or a widget with no threshold never triggers.
+func (this *freezer) Check(widget int, values []float64) bool {
+	if this == nil {
+		return false
+	}
+	threshold, ok := this.thresholds[widget]
+	if !ok {
+		return false
+	}
+
+	above := false
+	for _, value := range values {
+		above = above || value > threshold
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	crossed := above && !this.above[widget]
+	this.above[widget] = above
+	return crossed
+}
+
+// Rings the terminal bell, which the terminal backends leave alone as they only draw with escape codes
+var beep = func() {
+	os.Stdout.WriteString("\a")
+}
+
+// Called by each chart with its latest values, pausing sampling, beeping and showing a toast if
+// they've crossed the chart's --freeze-on threshold. Sampling resumes when p is pressed.
+func (this *PoptopConfig) freezeOnSpike(widget int, values []float64) {
+	if this.Paused() || !this.freezeOn.Check(widget, values) {
+		return
+	}
+
+	this.SetPaused(true)
+	beep()
+	this.toast.Show(fmt.Sprintf("%s crossed %v, sampling is paused, press p to resume",
+		widgetNames[widget], this.freezeOn.thresholds[widget]), time.Now())
+}
+
+// Returns true if sampling has been paused, either by pressing p or by a --freeze-on threshold
+func (this *PoptopConfig) Paused() bool {
+	return this.sampleClock != nil && this.sampleClock.Paused()
+}
+
+// Pauses or resumes the charts along with the top lists, which skip their refreshes while paused
+func (this *PoptopConfig) SetPaused(paused bool) {
+	this.sampleClock.SetPaused(paused)
+}
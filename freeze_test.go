@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFreezerCheck(t *testing.T) {
+	thresholds := newFreezer(map[int]float64{WidgetCPUPerc: 90})
+
+	steps := []struct {
+		values  []float64
+		crossed bool
+	}{
+		{[]float64{10, 50, 80}, false},
+		{[]float64{10, 50, 95}, true},
+		{[]float64{20, 60, 99}, false}, // still above so doesn't trigger again
+		{[]float64{20, 60, 90}, false},
+		{[]float64{91, 92, 93}, true},
+	}
+	for i, step := range steps {
+		if crossed := thresholds.Check(WidgetCPUPerc, step.values); crossed != step.crossed {
+			t.Errorf("Step %d: expected crossed to be %v for %v", i, step.crossed, step.values)
+		}
+	}
+
+	if thresholds.Check(WidgetCPULoad, []float64{1000}) {
+		t.Error("Expected a widget without a threshold never to trigger")
+	}
+	var none *freezer
+	if none.Check(WidgetCPUPerc, []float64{1000}) {
+		t.Error("Expected a nil freezer never to trigger")
+	}
+}
+
+func TestFreezeOnSpike(t *testing.T) {
+	beeps := 0
+	oldBeep := beep
+	t.Cleanup(func() { beep = oldBeep })
+	beep = func() { beeps++ }
+
+	config := &PoptopConfig{SampleInterval: time.Second, freezeOn: newFreezer(map[int]float64{WidgetNetworkIO: 100})}
+	config.Finalize()
+
+	config.freezeOnSpike(WidgetNetworkIO, []float64{50, 60})
+	if config.Paused() {
+		t.Error("Expected values under the threshold not to pause sampling")
+	}
+
+	config.freezeOnSpike(WidgetNetworkIO, []float64{50, 160})
+	if !config.Paused() || beeps != 1 || config.toast.current(time.Now()) == "" {
+		t.Errorf("Expected crossing the threshold to pause, beep and show a toast, got paused %v and %d beeps", config.Paused(), beeps)
+	}
+}
+
+func TestPausedPeriodicLive(t *testing.T) {
+	interval := newLiveInterval(time.Millisecond)
+	interval.SetPaused(true)
+
+	var calls int32
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		periodicLive(ctx, interval, func() error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("Expected no calls while paused but got %d", n)
+	}
+
+	interval.Step()
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected one call after stepping but got %d", n)
+	}
+
+	interval.SetPaused(false)
+	interval.Step() // does nothing once resumed
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&calls) == 0 {
+		t.Error("Expected calls once resumed")
+	}
+
+	cancel()
+	<-done
+}
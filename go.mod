@@ -6,10 +6,14 @@ require (
 	github.com/alecthomas/kong v0.6.1
 	github.com/mum4k/termdash v0.17.0
 	github.com/shirou/gopsutil/v3 v3.22.8
+	golang.org/x/term v0.0.0-20220526004731-065cf7ba2467
 )
 
 require (
+	github.com/gdamore/encoding v1.0.0 // indirect
+	github.com/gdamore/tcell/v2 v2.5.1 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/lufia/plan9stats v0.0.0-20211012122336-39d0f177ccd0 // indirect
 	github.com/mattn/go-runewidth v0.0.13 // indirect
 	github.com/nsf/termbox-go v1.1.1 // indirect
@@ -19,6 +23,7 @@ require (
 	github.com/tklauser/numcpus v0.4.0 // indirect
 	github.com/yusufpapurcu/wmi v1.2.2 // indirect
 	golang.org/x/sys v0.0.0-20220704084225-05e143d24a9e // indirect
+	golang.org/x/text v0.3.7 // indirect
 )
 
 replace github.com/mum4k/termdash => github.com/bakks/termdash v0.17.0-forked-2
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+const nvidiaSmi = "nvidia-smi"
+
+type gpuStat struct {
+	UtilPerc float64
+	MemUsed  float64
+	MemTotal float64
+}
+
+// Parses the output of `nvidia-smi --query-gpu=utilization.gpu,memory.used,memory.total --format=csv,noheader,nounits`,
+// which prints one line per GPU, e.g. "45, 1024, 8192". Values a GPU doesn't report are printed as
+// [N/A] and parsed as NaN, so they're charted as gaps.
+func parseNvidiaSmi(out []byte) ([]*gpuStat, error) {
+	stats := []*gpuStat{}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Unexpected nvidia-smi output: %s", line)
+		}
+
+		values := make([]float64, len(fields))
+		for i, field := range fields {
+			field = strings.TrimSpace(field)
+			if field == "[N/A]" {
+				values[i] = math.NaN()
+				continue
+			}
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+
+		stats = append(stats, &gpuStat{
+			UtilPerc: values[0],
+			MemUsed:  values[1],
+			MemTotal: values[2],
+		})
+	}
+
+	return stats, nil
+}
+
+func getGpuStats(ctx context.Context, timeout time.Duration) ([]*gpuStat, error) {
+	out, err := commandWithContext(ctx, timeout, nvidiaSmi, "--query-gpu=utilization.gpu,memory.used,memory.total", "--format=csv,noheader,nounits")
+
+	// nvidia-smi explains why it failed on its first line, e.g. that it couldn't communicate with
+	// the driver
+	var exited *exec.ExitError
+	if errors.As(err, &exited) {
+		message := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+		return nil, fmt.Errorf("%s failed: %s: %w", nvidiaSmi, message, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNvidiaSmi(out)
+}
+
+// Create a chart to show GPU utilization and VRAM used as a percentage of total VRAM.
+// This calls nvidia-smi so only works for NVIDIA GPUs, if nvidia-smi isn't found
+// then we show a message rather than a chart. Each GPU is drawn as its own pair of series.
+func newGpuChart(ctx context.Context, root *container.Container, config *PoptopConfig, sampling *chartSampling, collector Collector) ([]container.Option, error) {
+	if _, err := exec.LookPath(nvidiaSmi); err != nil && config.replay == nil {
+		textBox, err := text.New()
+		if err != nil {
+			return nil, err
+		}
+
+		title := cell.NewRichTextString(ColorWidgetTitle).
+			AddOpt(cell.Bold()).
+			AddText(" GPU (%) ")
+
+		textBox.Write(" nvidia-smi was not found in your PATH, so GPU metrics are unavailable.", text.WriteReplace())
+		return makeContainer(textBox, title, config, WidgetGPU), nil
+	}
+
+	xLabels := formatLabels(config, sampling, func(n int) string {
+		x := float64(n) * float64(sampling.PointInterval()) / float64(time.Second)
+		return fmt.Sprintf("%.0fs", x)
+	})
+
+	chart, err := newChart(config, WidgetGPU, formatPercent, xLabels, percentMax)
+	if err != nil {
+		return nil, err
+	}
+
+	// one series per GPU, we find out how many GPUs there are on the first sample
+	util := []*BoundedSeries{}
+	vram := []*BoundedSeries{}
+	utilColor := config.SeriesColor("util", ColorHot1)
+	vramColor := config.SeriesColor("vram", ColorHot3)
+
+	makeTitle := func() *cell.RichTextString {
+		if len(util) <= 1 {
+			var utilSeries, vramSeries *BoundedSeries
+			if len(util) == 1 {
+				utilSeries, vramSeries = util[0], vram[0]
+			}
+			return chartTitle(config, "GPU (%)", formatPercent,
+				titleEntry{"util", utilColor, utilSeries},
+				titleEntry{"vram", vramColor, vramSeries})
+		}
+
+		entries := []titleEntry{}
+		for i := range util {
+			entries = append(entries,
+				titleEntry{fmt.Sprintf("util%d", i), utilColor, util[i]},
+				titleEntry{fmt.Sprintf("vram%d", i), vramColor, vram[i]})
+		}
+		return chartTitle(config, "GPU (%)", formatPercent, entries...)
+	}
+
+	opts, setTitle := makeDynamicContainer(root, "gpu", chart, makeTitle(), config, WidgetGPU)
+
+	config.goPeriodicLive(ctx, sampling.clock, titleErrors(setTitle, makeTitle), func() error {
+		values, err := collector.Collect(ctx)
+
+		// if nvidia-smi gets stuck or fails we say so in the title and try again next sample
+		if errors.Is(err, context.DeadlineExceeded) {
+			setTitle(makeTitle().
+				SetFgColor(ColorHot1).
+				AddText(fmt.Sprintf("%s timed out ", nvidiaSmi)).
+				ResetColor())
+			return nil
+		}
+		var exited *exec.ExitError
+		if errors.As(err, &exited) {
+			setTitle(makeTitle().
+				SetFgColor(ColorHot1).
+				AddText(fmt.Sprintf("%v ", err)).
+				ResetColor())
+			return nil
+		}
+		if err != nil || values == nil {
+			return err
+		}
+		if len(values)%3 != 0 {
+			return fmt.Errorf("Expected a multiple of 3 values for gpu but got %d.\n", len(values))
+		}
+
+		percs := []float64{}
+		for i := 0; i < len(values)/3; i++ {
+			stat := &gpuStat{values[i*3], values[i*3+1], values[i*3+2]}
+			if i >= len(util) {
+				util = append(util, newChartSeries(config, sampling, fmt.Sprintf("gpu.gpu%d_util", i)))
+				vram = append(vram, newChartSeries(config, sampling, fmt.Sprintf("gpu.gpu%d_vram", i)))
+			}
+
+			util[i].AddValue(stat.UtilPerc)
+			percs = append(percs, stat.UtilPerc)
+			if stat.MemTotal > 0 {
+				vram[i].AddValue(stat.MemUsed / stat.MemTotal * 100)
+				percs = append(percs, stat.MemUsed/stat.MemTotal*100)
+			}
+
+			err = chart.Series(fmt.Sprintf("b_gpu%d_util", i), config.Smoothed(util[i]), utilColor)
+			if err != nil {
+				return err
+			}
+			err = chart.Series(fmt.Sprintf("a_gpu%d_vram", i), config.Smoothed(vram[i]), vramColor)
+			if err != nil {
+				return err
+			}
+		}
+
+		config.freezeOnSpike(WidgetGPU, percs)
+		setTitle(makeTitle())
+		return nil
+	})
+
+	return opts, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseNvidiaSmi(t *testing.T) {
+	cases := []struct {
+		out   string
+		stats []gpuStat
+	}{
+		{"45, 1024, 8192\n", []gpuStat{{45, 1024, 8192}}},
+		{"45, 1024, 8192\n 3 ,0,  4096\n\n", []gpuStat{{45, 1024, 8192}, {3, 0, 4096}}},
+		{"", []gpuStat{}},
+	}
+
+	for _, c := range cases {
+		stats, err := parseNvidiaSmi([]byte(c.out))
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", c.out, err)
+			continue
+		}
+		if len(stats) != len(c.stats) {
+			t.Errorf("Expected %d GPUs in %q but got %d", len(c.stats), c.out, len(stats))
+			continue
+		}
+		for i, stat := range stats {
+			if *stat != c.stats[i] {
+				t.Errorf("Expected GPU %d in %q to be %v but got %v", i, c.out, c.stats[i], *stat)
+			}
+		}
+	}
+
+	for _, out := range []string{"45, 1024\n", "45, 1024, 8192, 1\n", "45, N/A, 8192\n"} {
+		if _, err := parseNvidiaSmi([]byte(out)); err == nil {
+			t.Errorf("Expected an error for %q", out)
+		}
+	}
+
+	// values the GPU doesn't report are gaps
+	stats, err := parseNvidiaSmi([]byte("[N/A], 1024, 8192\n"))
+	if err != nil || len(stats) != 1 || !math.IsNaN(stats[0].UtilPerc) || stats[0].MemUsed != 1024 {
+		t.Errorf("Expected [N/A] utilization to parse as NaN but got %v, %v", stats, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// These values change slowly so there's no point sampling them at the chart rate
+const hostInfoInterval = 5 * time.Second
+
+// Formats a duration as e.g. "3d 4h 12m"
+func formatUptime(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+func hostInfoText(ctx context.Context) (string, error) {
+	info, err := host.InfoWithContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// listing users reads utmp which doesn't exist on every system, e.g. in containers
+	numUsers := "n/a"
+	users, err := host.UsersWithContext(ctx)
+	if err == nil {
+		numUsers = fmt.Sprintf("%d", len(users))
+	}
+
+	bootTime := time.Unix(int64(info.BootTime), 0)
+	uptime := time.Duration(info.Uptime) * time.Second
+
+	lines := []string{
+		fmt.Sprintf(" Host      %s", info.Hostname),
+		fmt.Sprintf(" Platform  %s %s", info.Platform, info.PlatformVersion),
+		fmt.Sprintf(" Uptime    %s", formatUptime(uptime)),
+		fmt.Sprintf(" Booted    %s", bootTime.Format("2006-01-02 15:04:05")),
+		fmt.Sprintf(" Users     %s", numUsers),
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Create a text widget showing system uptime, boot time and the number of logged in users.
+func newHostInfoBox(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+
+	update := func() error {
+		info, err := hostInfoText(ctx)
+		if err != nil {
+			return err
+		}
+
+		return textBox.Write(info, text.WriteReplace())
+	}
+
+	// populate immediately rather than waiting for the first tick
+	if err := update(); err != nil {
+		return nil, err
+	}
+
+	config.goPeriodic(ctx, hostInfoInterval, textErrors(textBox), update)
+
+	title := cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(" System Info ")
+
+	opts := makeContainer(textBox, title, config, WidgetHostInfo)
+
+	return opts, nil
+}
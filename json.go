@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+type loadSample struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+type cpuSample struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+}
+
+type netSample struct {
+	SentKiBs float64 `json:"sent_kibs"`
+	RecvKiBs float64 `json:"recv_kibs"`
+}
+
+type diskIOPSSample struct {
+	Read  float64 `json:"read"`
+	Write float64 `json:"write"`
+}
+
+type diskIOSample struct {
+	ReadKiBs  float64 `json:"read_kibs"`
+	WriteKiBs float64 `json:"write_kibs"`
+}
+
+type netFamilySample struct {
+	SentV4KiBs float64 `json:"sent_v4_kibs"`
+	RecvV4KiBs float64 `json:"recv_v4_kibs"`
+	SentV6KiBs float64 `json:"sent_v6_kibs"`
+	RecvV6KiBs float64 `json:"recv_v6_kibs"`
+}
+
+type switchesSample struct {
+	Ctxt float64 `json:"ctxt"`
+	Intr float64 `json:"intr"`
+}
+
+type diskLatencySample struct {
+	ReadMs  float64 `json:"read_ms"`
+	WriteMs float64 `json:"write_ms"`
+}
+
+type diskSpaceSample struct {
+	Used float64 `json:"used_bytes"`
+	Free float64 `json:"free_bytes"`
+}
+
+// Either is left out where it isn't reported, e.g. the file handles on MacOS
+type fileLimitsSample struct {
+	HandlesPerc *float64 `json:"handles_perc,omitempty"`
+	InodesPerc  *float64 `json:"inodes_perc,omitempty"`
+}
+
+type connectionsSample struct {
+	Established int `json:"established"`
+	TimeWait    int `json:"time_wait"`
+	Listen      int `json:"listen"`
+}
+
+type gpuSample struct {
+	UtilPerc float64 `json:"util_perc"`
+	VramPerc float64 `json:"vram_perc"`
+}
+
+type hostSample struct {
+	Hostname string    `json:"hostname"`
+	Uptime   uint64    `json:"uptime_seconds"`
+	BootTime time.Time `json:"boot_time"`
+	Users    int       `json:"users"`
+}
+
+// A single line of JSON output, only the enabled widgets' metrics are populated.
+// Rate based metrics are left out of the first sample since they need a previous value. With
+// --cumulative the network and disk counters are running totals rather than rates, and Cumulative
+// is set so they're charted as totals.
+type jsonSample struct {
+	Timestamp     time.Time             `json:"timestamp"`
+	Cumulative    bool                  `json:"cumulative,omitempty"`
+	Load          *loadSample           `json:"load,omitempty"`
+	Cpu           *cpuSample            `json:"cpu,omitempty"`
+	Network       *netSample            `json:"network,omitempty"`
+	Interfaces    map[string]*netSample `json:"interfaces,omitempty"`
+	NetworkFamily *netFamilySample      `json:"network_family,omitempty"`
+	DiskIOPS      *diskIOPSSample       `json:"disk_iops,omitempty"`
+	DiskIO        *diskIOSample         `json:"disk_io,omitempty"`
+	DiskLatency   *diskLatencySample    `json:"disk_latency,omitempty"`
+	DiskSpace     *diskSpaceSample      `json:"disk_space,omitempty"`
+	Connections   *connectionsSample    `json:"connections,omitempty"`
+	Switches      *switchesSample       `json:"switches,omitempty"`
+	FileLimits    *fileLimitsSample     `json:"file_limits,omitempty"`
+	GPU           []*gpuSample          `json:"gpu,omitempty"`
+	Host          *hostSample           `json:"host,omitempty"`
+	TopCpu        []*PsProcess          `json:"top_cpu,omitempty"`
+	TopMem        []*PsProcess          `json:"top_mem,omitempty"`
+}
+
+// Returns the chart samples in a line of JSON output, named and ordered as the charts record them,
+// so JSON output piped in with --stdin can be charted. The GPU chart works out VRAM % from the used
+// and total memory, so each GPU's VRAM % is given as used out of a total of 100.
+func (this *jsonSample) chartSamples() []*recordedSample {
+	samples := []*recordedSample{}
+	add := func(name string, values ...float64) {
+		sample := &recordedSample{Time: this.Timestamp, Name: name, Values: make([]*float64, len(values))}
+		for i := range values {
+			if !math.IsNaN(values[i]) {
+				sample.Values[i] = &values[i]
+			}
+		}
+		samples = append(samples, sample)
+	}
+
+	// totals are recorded under their own name, see counterSource
+	counter := func(name string) string {
+		if this.Cumulative {
+			return name + "Total"
+		}
+		return name
+	}
+
+	if this.Load != nil {
+		add("cpuLoad", this.Load.Load1, this.Load.Load5, this.Load.Load15)
+	}
+	if this.Cpu != nil {
+		add("cpuPerc", this.Cpu.Min, this.Cpu.Avg, this.Cpu.Max)
+	}
+	if this.Network != nil {
+		add(counter("networkIO"), this.Network.SentKiBs, this.Network.RecvKiBs)
+	}
+	names := []string{}
+	for name := range this.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(counter("networkIO_"+name), this.Interfaces[name].SentKiBs, this.Interfaces[name].RecvKiBs)
+	}
+	if family := this.NetworkFamily; family != nil {
+		add(counter("networkIOFamily"), family.SentV4KiBs, family.RecvV4KiBs, family.SentV6KiBs, family.RecvV6KiBs)
+	}
+	if this.DiskIOPS != nil {
+		add(counter("diskIOPS"), this.DiskIOPS.Read, this.DiskIOPS.Write)
+	}
+	if this.DiskIO != nil {
+		add(counter("diskIO"), this.DiskIO.ReadKiBs, this.DiskIO.WriteKiBs)
+	}
+	if this.DiskLatency != nil {
+		add("diskLatency", this.DiskLatency.ReadMs, this.DiskLatency.WriteMs)
+	}
+	if this.DiskSpace != nil {
+		add("diskSpace", this.DiskSpace.Used, this.DiskSpace.Free)
+	}
+	if this.Connections != nil {
+		add("connections", float64(this.Connections.Established), float64(this.Connections.TimeWait), float64(this.Connections.Listen))
+	}
+	if this.Switches != nil {
+		add("switches", this.Switches.Ctxt, this.Switches.Intr)
+	}
+	if this.FileLimits != nil {
+		add("fileLimits", valueOrNaN(this.FileLimits.HandlesPerc), valueOrNaN(this.FileLimits.InodesPerc))
+	}
+	if len(this.GPU) > 0 {
+		values := []float64{}
+		for _, gpu := range this.GPU {
+			values = append(values, gpu.UtilPerc, gpu.VramPerc, 100)
+		}
+		add("gpu", values...)
+	}
+	return samples
+}
+
+// Returns the value pointed to, or NaN for nil, the way JSON leaves out a value that isn't reported
+func valueOrNaN(value *float64) float64 {
+	if value == nil {
+		return math.NaN()
+	}
+	return *value
+}
+
+// Returns a pointer to the value, or nil for NaN which JSON can't encode
+func nanOrValue(value float64) *float64 {
+	if math.IsNaN(value) {
+		return nil
+	}
+	return &value
+}
+
+// A chart's collector in --json output, along with where its values go in a line of output
+type jsonCollector struct {
+	collector Collector
+	store     func(s *jsonSample, values []float64)
+}
+
+// Lists the collectors for the enabled chart widgets, made and named as the charts make them so
+// that --json outputs what the charts would show. Charts of metrics the system doesn't report are
+// left out like they are with --once.
+func jsonCollectors(ctx context.Context, config *PoptopConfig) []*jsonCollector {
+	collectors := []*jsonCollector{}
+	add := func(collector Collector, store func(s *jsonSample, values []float64)) {
+		collectors = append(collectors, &jsonCollector{collector, store})
+	}
+
+	for _, widget := range config.Widgets {
+		switch widget {
+		case WidgetCPULoad:
+			add(sampleSource(config, "cpuLoad", newLoadCollector()), func(s *jsonSample, values []float64) {
+				s.Load = &loadSample{values[0], values[1], values[2]}
+			})
+
+		case WidgetCPUPerc:
+			add(sampleSource(config, "cpuPerc", newCpuCollector()), func(s *jsonSample, values []float64) {
+				s.Cpu = &cpuSample{values[0], values[1], values[2]}
+			})
+
+		case WidgetNetworkIO:
+			// fall back to combined send and receive where the address families aren't reported
+			split := len(config.NetInterfaces) == 0 && config.NetSplitFamily
+			if split {
+				_, err := familyCounters(ctx)
+				split = err == nil
+			}
+
+			if split {
+				add(counterSource(config, "networkIOFamily", newFamilyNetCollector(familyCounters, time.Now), familyCounters, 1024), func(s *jsonSample, values []float64) {
+					s.NetworkFamily = &netFamilySample{values[0], values[1], values[2], values[3]}
+				})
+				break
+			}
+
+			if len(config.NetInterfaces) == 0 {
+				counters := netCounters(func(iface string) bool {
+					return !interfaceExcluded(config.ExcludeInterfaces, iface)
+				})
+				add(counterSource(config, "networkIO", newNetCollector(config.CurrentSampleInterval, counters), counters, 1024), func(s *jsonSample, values []float64) {
+					s.Network = &netSample{values[0], values[1]}
+				})
+			}
+
+			// each interface given with -i is charted separately, so it's sampled separately too
+			for _, iface := range config.NetInterfaces {
+				name := iface
+				counters := netCounters(func(n string) bool {
+					return n == name
+				})
+				add(counterSource(config, "networkIO_"+name, newNetCollector(config.CurrentSampleInterval, counters), counters, 1024), func(s *jsonSample, values []float64) {
+					if s.Interfaces == nil {
+						s.Interfaces = map[string]*netSample{}
+					}
+					s.Interfaces[name] = &netSample{values[0], values[1]}
+				})
+			}
+
+		case WidgetDiskIOPS:
+			source := chooseDiskSource(ctx, config)
+			add(counterSource(config, "diskIOPS", newDiskIOPSCollector(config.CurrentSampleInterval, source.ops), source.ops, 1), func(s *jsonSample, values []float64) {
+				s.DiskIOPS = &diskIOPSSample{values[0], values[1]}
+			})
+
+		case WidgetDiskIO:
+			source := chooseDiskSource(ctx, config)
+			add(counterSource(config, "diskIO", newDiskIOCollector(source.bytes, time.Now), source.bytes, 1024), func(s *jsonSample, values []float64) {
+				s.DiskIO = &diskIOSample{values[0], values[1]}
+			})
+
+		case WidgetConnections:
+			add(sampleSource(config, "connections", newConnectionsCollector()), func(s *jsonSample, values []float64) {
+				s.Connections = &connectionsSample{int(values[0]), int(values[1]), int(values[2])}
+			})
+
+		case WidgetSwitches:
+			if _, err := switchCounters(ctx); err == nil {
+				add(sampleSource(config, "switches", primeRate(config, newSwitchesCollector(switchCounters, time.Now))), func(s *jsonSample, values []float64) {
+					s.Switches = &switchesSample{values[0], values[1]}
+				})
+			}
+
+		case WidgetDiskLatency:
+			if _, err := diskLatencyCounters(ctx); err == nil {
+				add(sampleSource(config, "diskLatency", primeRate(config, newDiskLatencyCollector(diskLatencyCounters))), func(s *jsonSample, values []float64) {
+					s.DiskLatency = &diskLatencySample{values[0], values[1]}
+				})
+			}
+
+		case WidgetDiskSpace:
+			add(sampleSource(config, "diskSpace", newDiskSpaceCollector(config.DiskSpacePath)), func(s *jsonSample, values []float64) {
+				s.DiskSpace = &diskSpaceSample{values[0], values[1]}
+			})
+
+		case WidgetFileLimits:
+			add(sampleSource(config, "fileLimits", newFileLimitsCollector(fileHandleCounts, fullestInodeUsage)), func(s *jsonSample, values []float64) {
+				s.FileLimits = &fileLimitsSample{nanOrValue(values[0]), nanOrValue(values[1])}
+			})
+
+		case WidgetGPU:
+			if _, err := exec.LookPath(nvidiaSmi); err == nil {
+				add(sampleSource(config, "gpu", newGpuCollector(config.CommandTimeout)), func(s *jsonSample, values []float64) {
+					for i := 0; i+2 < len(values); i += 3 {
+						gpu := &gpuSample{UtilPerc: values[i]}
+						if values[i+2] > 0 {
+							gpu.VramPerc = values[i+1] / values[i+2] * 100
+						}
+						s.GPU = append(s.GPU, gpu)
+					}
+				})
+			}
+		}
+	}
+
+	return collectors
+}
+
+// Samples every enabled metric on each sample interval and writes one JSON object per line to out
+// rather than drawing charts. Returns the context's error once it's cancelled.
+func runJSON(ctx context.Context, config *PoptopConfig, out io.Writer) error {
+	enabled := map[int]bool{}
+	for _, widget := range config.Widgets {
+		enabled[widget] = true
+	}
+
+	collectors := jsonCollectors(ctx, config)
+	encoder := json.NewEncoder(out)
+	var lastTop time.Time
+
+	sample := func() error {
+		now := time.Now()
+		s := &jsonSample{Timestamp: now, Cumulative: config.Cumulative}
+
+		for _, collector := range collectors {
+			// a stuck command, e.g. nvidia-smi or iostat, just leaves its chart out of this sample
+			values, err := collector.collector.Collect(ctx)
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			if err == nil && values != nil {
+				collector.store(s, values)
+			}
+		}
+
+		if enabled[WidgetHostInfo] {
+			info, err := host.InfoWithContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			s.Host = &hostSample{
+				Hostname: info.Hostname,
+				Uptime:   info.Uptime,
+				BootTime: time.Unix(int64(info.BootTime), 0),
+			}
+			if users, err := host.UsersWithContext(ctx); err == nil {
+				s.Host.Users = len(users)
+			}
+		}
+
+		// top lists are point-in-time so we only include them every top interval
+		if (enabled[WidgetTopCPU] || enabled[WidgetTopMem]) && now.Sub(lastTop) >= config.TopInterval {
+			// a stuck ps leaves the top lists out of this sample, they're tried again next sample
+			topCpu, topMem, err := topProcesses(ctx, config)
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			if err == nil {
+				if enabled[WidgetTopCPU] {
+					s.TopCpu = topCpu
+				}
+				if enabled[WidgetTopMem] {
+					s.TopMem = topMem
+				}
+				lastTop = now
+			}
+		}
+
+		return encoder.Encode(s)
+	}
+
+	if err := periodic(ctx, config.SampleInterval, sample); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
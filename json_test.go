@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestJSONChartSamples(t *testing.T) {
+	line := `{"timestamp":"2022-10-01T12:00:00Z","cumulative":true,"interfaces":{"lo":{"sent_kibs":1,"recv_kibs":2}},` +
+		`"disk_iops":{"read":3,"write":4},"switches":{"ctxt":5,"intr":6},"file_limits":{"inodes_perc":7}}`
+	samples, err := parseStreamedSamples([]byte(line))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	names := []string{}
+	for _, sample := range samples {
+		names = append(names, sample.Name)
+	}
+	if fmt.Sprint(names) != "[networkIO_loTotal diskIOPSTotal switches fileLimits]" {
+		t.Errorf("Expected the samples named as the charts record them but got %v", names)
+	}
+
+	// the file handles which weren't reported are a gap in the chart
+	fileLimits := samples[3].Values
+	if len(fileLimits) != 2 || fileLimits[0] != nil || *fileLimits[1] != 7 {
+		t.Errorf("Expected the file limits without the file handles but got %v", fileLimits)
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	config := DefaultConfig()
+	config.Widgets = []int{WidgetDiskSpace, WidgetFileLimits}
+	config.DiskSpacePath = "/"
+	config.SampleInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	if err := runJSON(ctx, config, &out); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context's error once it's done but got %v", err)
+	}
+
+	scanner := bufio.NewScanner(&out)
+	if !scanner.Scan() {
+		t.Fatal("Expected a line of output")
+	}
+	sample := &jsonSample{}
+	if err := json.Unmarshal(scanner.Bytes(), sample); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sample.DiskSpace == nil || sample.DiskSpace.Used+sample.DiskSpace.Free <= 0 || sample.FileLimits == nil {
+		t.Errorf("Expected samples of the disk space and file limits but got %s", scanner.Text())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// The number of rows the legend takes up above the status bar
+const legendRows = 1
+
+// A row along the bottom of the screen which shows what the colors shared across charts mean,
+// e.g. that reads are blue and writes red on every disk chart. Like the status bar it sits outside
+// the widget layout. It's written in the default palette, so the theme recolors it along with the
+// charts and it stays accurate.
+type legend struct {
+	*text.Text
+}
+
+// A color in the legend and what it means
+type legendEntry struct {
+	label string
+	color cell.Color
+}
+
+// Returns the legend's entries in the colors the charts draw them in, including any --color
+func legendEntries(config *PoptopConfig) []legendEntry {
+	// the CPU chart swaps min and max when charting idle time, see newCpuChart
+	minColor, maxColor := ColorHot3, ColorHot1
+	if config.CpuIdle {
+		minColor, maxColor = ColorHot1, ColorHot3
+	}
+
+	return []legendEntry{
+		{"read", config.SeriesColor("read", ColorRead)},
+		{"write", config.SeriesColor("write", ColorWrite)},
+		{"recv", config.SeriesColor("recv", ColorRead)},
+		{"sent", config.SeriesColor("sent", ColorWrite)},
+		{"min", config.SeriesColor("min", minColor)},
+		{"avg", config.SeriesColor("avg", ColorHot2)},
+		{"max", config.SeriesColor("max", maxColor)},
+		{"errors", config.SeriesColor("errors", ColorError)},
+	}
+}
+
+func newLegend(config *PoptopConfig) (*legend, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range legendEntries(config) {
+		if err := textBox.Write(" ━━ ", text.WriteCellOpts(cell.FgColor(entry.color))); err != nil {
+			return nil, err
+		}
+		if err := textBox.Write(entry.label+" ", text.WriteCellOpts(cell.FgColor(ColorChartLabel))); err != nil {
+			return nil, err
+		}
+	}
+	return &legend{textBox}, nil
+}
+
+// Returns the legend's place below the widget grid
+func (this *legend) container() []container.Option {
+	return []container.Option{container.Border(linestyle.None), container.KeyFocusSkip(), container.PlaceWidget(this)}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+func TestLegendEntries(t *testing.T) {
+	colors := func(config *PoptopConfig) map[string]cell.Color {
+		colors := map[string]cell.Color{}
+		for _, entry := range legendEntries(config) {
+			colors[entry.label] = entry.color
+		}
+		return colors
+	}
+
+	config := &PoptopConfig{}
+	busy := colors(config)
+	if busy["read"] != ColorRead || busy["write"] != ColorWrite || busy["max"] != ColorHot1 || busy["min"] != ColorHot3 {
+		t.Errorf("Expected the legend to use the charts' default colors but got %v", busy)
+	}
+
+	config.CpuIdle = true
+	config.SeriesColors = map[string]cell.Color{"read": cell.ColorNumber(99)}
+	idle := colors(config)
+	if idle["max"] != ColorHot3 || idle["min"] != ColorHot1 {
+		t.Errorf("Expected min and max to swap when charting CPU idle but got %v", idle)
+	}
+	if idle["read"] != cell.ColorNumber(99) {
+		t.Errorf("Expected the legend to follow --color but got %v", idle["read"])
+	}
+}
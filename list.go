@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// Prints the name of each network interface, i.e. the names --net-interface and --exclude-interface
+// match against, noting which are left out of the network chart by the exclusions
+func listInterfaces(ctx context.Context, config *PoptopConfig, out io.Writer) error {
+	iostats, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return fmt.Errorf("Could not list network interfaces: %v\n", err)
+	}
+
+	names := []string{}
+	for _, iostat := range iostats {
+		names = append(names, iostat.Name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if interfaceExcluded(config.ExcludeInterfaces, name) {
+			fmt.Fprintf(out, "%s (excluded)\n", name)
+		} else {
+			fmt.Fprintln(out, name)
+		}
+	}
+	return nil
+}
+
+// Prints the name of each disk device with IO counters, i.e. the disks the disk charts add up
+func listDisks(ctx context.Context, out io.Writer) error {
+	iostats, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Could not list disks: %v\n", err)
+	}
+
+	names := []string{}
+	for name := range iostats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(out, name)
+	}
+	return nil
+}
@@ -5,17 +5,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image"
 	"math"
 	"os"
 	"os/exec"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/alecthomas/kong"
 	"github.com/mum4k/termdash"
 	"github.com/mum4k/termdash/cell"
 	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/container/grid"
 	"github.com/mum4k/termdash/keyboard"
 	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/terminal/tcell"
 	"github.com/mum4k/termdash/terminal/termbox"
 	"github.com/mum4k/termdash/terminal/terminalapi"
 	"github.com/mum4k/termdash/widgets/text"
@@ -32,7 +45,51 @@ var ( // these are filled in at build time
 )
 
 func getBuildInfo() string {
-	return fmt.Sprintf("Poptop %s %s %s (commit %s) (built %s)\n%s\n", BuildVersion, BuildOs, BuildArch, BuildCommit, BuildTimestamp, License)
+	return fmt.Sprintf("Poptop %s %s %s (commit %s) (built %s) (%s)\n%s\n", BuildVersion, BuildOs, BuildArch, BuildCommit, BuildTimestamp, runtime.Version(), License)
+}
+
+// Fills in any build info that wasn't set at build time from what the Go toolchain embeds in the
+// binary, so builds from 'go install' or 'go build' without the release ldflags still report their
+// module version and git commit
+func fillBuildInfo(info *debug.BuildInfo) {
+	if BuildOs == "" {
+		BuildOs = runtime.GOOS
+	}
+	if BuildArch == "" {
+		BuildArch = runtime.GOARCH
+	}
+	if info == nil {
+		return
+	}
+
+	if BuildVersion == "" {
+		BuildVersion = info.Main.Version
+	}
+	if BuildCommit != "" {
+		return
+	}
+
+	modified := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			BuildCommit = setting.Value
+			if len(BuildCommit) > 7 {
+				BuildCommit = BuildCommit[:7]
+			}
+		case "vcs.time":
+			if BuildTimestamp == "" {
+				BuildTimestamp = setting.Value
+			}
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+
+	// release builds are from clean tags, so note a build with local changes
+	if modified && BuildCommit != "" {
+		BuildCommit += "-dirty"
+	}
 }
 
 func min(a, b int) int {
@@ -101,7 +158,30 @@ func power2(i int) int {
 	return n
 }
 
-func find(slice []int, element int) int {
+// Moves widget delta places through widgets, where a negative delta moves it earlier, stopping at
+// either end. Returns false if the widget isn't there or can't move any further.
+func moveWidget(widgets []int, widget, delta int) bool {
+	from := find(widgets, widget)
+	if from == -1 {
+		return false
+	}
+
+	to := max(0, min(len(widgets)-1, from+delta))
+	if to == from {
+		return false
+	}
+
+	step := 1
+	if to < from {
+		step = -1
+	}
+	for i := from; i != to; i += step {
+		widgets[i], widgets[i+step] = widgets[i+step], widgets[i]
+	}
+	return true
+}
+
+func find[T comparable](slice []T, element T) int {
 	for i, x := range slice {
 		if x == element {
 			return i
@@ -110,6 +190,189 @@ func find(slice []int, element int) int {
 	return -1
 }
 
+// What pressing a key does at runtime, outside of the help overlay and typing a filter
+type keyAction int
+
+const (
+	actionNone    keyAction = iota // special keys such as the arrows, which focused widgets handle themselves
+	actionUnknown                  // printable keys which aren't hotkeys
+	actionQuit
+	actionHelpOverlay
+	actionToggleWidget
+	actionSplit
+	actionTile
+	actionGroup
+	actionFilter
+	actionMoveEarlier
+	actionMoveLater
+	actionSmoothMore
+	actionSmoothLess
+	actionSampleFaster
+	actionSampleSlower
+	actionRedrawFaster
+	actionRedrawSlower
+	actionPause
+	actionStep
+	actionClear
+	actionTheme
+	actionSortTop
+	actionSave
+	actionMark
+	actionFocusNext
+	actionLegend
+	actionTitles
+)
+
+type hotkey struct {
+	key         rune
+	description string
+	action      keyAction
+}
+
+// Hotkeys which aren't tied to toggling a widget, listed before and after the
+// widget toggles in the help text respectively
+var generalHotkeys = []hotkey{
+	{'h', "Toggle help widget", actionToggleWidget},
+	{'?', "Toggle help overlay", actionHelpOverlay},
+	{'q', "Quit Poptop", actionQuit},
+}
+
+var layoutHotkeys = []hotkey{
+	{'z', "Toggle horizontal vs vertical alignment", actionSplit},
+	{'w', "Toggle row of widgets vs panes of widgets", actionTile},
+	{'l', "Toggle the legend of chart colors", actionLegend},
+	{'b', "Toggle widget borders and titles to give charts more room", actionTitles},
+	{'g', "Toggle grouping top processes by command", actionGroup},
+	{'o', "Swap the focused or else each top process list between sorting by CPU and memory", actionSortTop},
+	{'/', "Filter top processes by command or user, Esc clears", actionFilter},
+	{'<', "Move the focused or last added widget earlier in the layout", actionMoveEarlier},
+	{'>', "Move the focused or last added widget later in the layout", actionMoveLater},
+}
+
+var chartHotkeys = []hotkey{
+	{'+', "Smooth charts over more samples", actionSmoothMore},
+	{'-', "Smooth charts over fewer samples", actionSmoothLess},
+	{'[', "Sample twice as often", actionSampleFaster},
+	{']', "Sample half as often", actionSampleSlower},
+	{'{', "Redraw twice as often", actionRedrawFaster},
+	{'}', "Redraw half as often", actionRedrawSlower},
+	{'p', "Pause or resume sampling", actionPause},
+	{'.', "Take one sample while paused", actionStep},
+	{'r', "Clear every chart's history and start afresh", actionClear},
+	{'t', "Cycle through the color themes", actionTheme},
+	{'s', "Save every chart's data to a CSV file", actionSave},
+	{'m', "Mark now on every chart's X axis", actionMark},
+}
+
+// Returns what pressing key does, along with the widget for widget toggles. Special keys such as
+// the arrows and F-keys are negative and control keys aren't printable, so both are told apart
+// before a key is looked up as a rune and can never be mistaken for a hotkey.
+func keyToAction(key keyboard.Key) (keyAction, int) {
+	if key == keyboard.KeyEsc || key == keyboard.KeyCtrlC {
+		return actionQuit, 0
+	}
+	if key == keyboard.KeyTab {
+		return actionFocusNext, 0
+	}
+	if key < 0 || !unicode.IsPrint(rune(key)) {
+		return actionNone, 0
+	}
+
+	r := rune(key)
+	if widget, ok := shortcodeToWidget[r]; ok {
+		return actionToggleWidget, widget
+	}
+	for _, hotkeys := range [][]hotkey{generalHotkeys, layoutHotkeys, chartHotkeys} {
+		for _, h := range hotkeys {
+			if h.key == r {
+				return h.action, 0
+			}
+		}
+	}
+	return actionUnknown, 0
+}
+
+var widgetNames map[int]string = map[int]string{
+	WidgetCPULoad:     "CPU Load",
+	WidgetCPUPerc:     "CPU Percent",
+	WidgetNetworkIO:   "Network Throughput",
+	WidgetDiskIOPS:    "Disk IOPS",
+	WidgetDiskIO:      "Disk Throughput",
+	WidgetTopCPU:      "Top CPU Processes",
+	WidgetTopMem:      "Top Memory Processes",
+	WidgetHelp:        "Help",
+	WidgetGPU:         "GPU",
+	WidgetConnections: "Connections",
+	WidgetHostInfo:    "System Info",
+	WidgetOverview:    "Overview",
+	WidgetTopDisk:     "Top Disk Processes",
+	WidgetTopFiles:    "Top Open Files Processes",
+	WidgetTopNet:      "Top Network Processes",
+	WidgetSwitches:    "Context Switches",
+	WidgetDiskLatency: "Disk Latency",
+	WidgetDiskSpace:   "Disk Space",
+	WidgetFileLimits:  "File Limits",
+}
+
+// Returns the shortcodes which toggle widgets in widget order, leaving out help which is
+// already covered by the general hotkeys
+func widgetShortcodes() []rune {
+	shortcodes := []rune{}
+	for shortcode, widget := range shortcodeToWidget {
+		if widget != WidgetHelp {
+			shortcodes = append(shortcodes, shortcode)
+		}
+	}
+
+	sort.Slice(shortcodes, func(i, j int) bool {
+		return shortcodeToWidget[shortcodes[i]] < shortcodeToWidget[shortcodes[j]]
+	})
+	return shortcodes
+}
+
+// The toast shown when a key which isn't a hotkey is pressed, listing the valid keys in the same order as the help
+func unknownKeyText(key rune) string {
+	keys := []string{}
+	for _, h := range generalHotkeys {
+		keys = append(keys, string(h.key))
+	}
+	for _, shortcode := range widgetShortcodes() {
+		keys = append(keys, string(shortcode))
+	}
+	for _, h := range append(append([]hotkey{}, layoutHotkeys...), chartHotkeys...) {
+		keys = append(keys, string(h.key))
+	}
+
+	return fmt.Sprintf("'%c' isn't a hotkey, try one of %s (press ? for what they do)", key, strings.Join(keys, " "))
+}
+
+// Builds the list of hotkeys shown by the help widget and overlay. The widget
+// toggles are derived from shortcodeToWidget so this stays in sync as widgets are added.
+func hotkeyHelpText() string {
+	lines := []string{}
+	for _, h := range generalHotkeys {
+		lines = append(lines, fmt.Sprintf(" %c  %s", h.key, h.description))
+	}
+
+	for _, shortcode := range widgetShortcodes() {
+		name := widgetNames[shortcodeToWidget[shortcode]]
+		lines = append(lines, fmt.Sprintf(" %c  Toggle %s widget", shortcode, name))
+	}
+
+	for _, h := range layoutHotkeys {
+		lines = append(lines, fmt.Sprintf(" %c  %s", h.key, h.description))
+	}
+
+	for _, h := range chartHotkeys {
+		lines = append(lines, fmt.Sprintf(" %c  %s", h.key, h.description))
+	}
+
+	// Tab isn't printable so isn't in the hotkey lists
+	lines = append(lines, " Tab  Focus the next widget, which o, < and > then act on, Esc clears the focus")
+
+	return strings.Join(lines, "\n")
+}
+
 func newHelpBox(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
 	textBox, err := text.New()
 	if err != nil {
@@ -120,20 +383,25 @@ func newHelpBox(ctx context.Context, config *PoptopConfig) ([]container.Option,
 		AddOpt(cell.Bold()).
 		AddText(" Poptop Hotkeys ")
 
-	helpText := ` h  Toggle help widget (shown here)
- q  Quit Poptop
- L  Toggle CPU Load widget
- C  Toggle CPU Percent widget
- D  Toggle Disk IOPS widget
- E  Toggle Disk Throughput widget
- N  Toggle Network Throughput widget
- T  Toggle Top CPU Processes widget
- M  Toggle Top Memory Processes widget
- z  Toggle horizontal vs vertical alignment
- w  Toggle row of widgets vs panes of widgets`
-
-	opts := makeContainer(textBox, title)
-	textBox.Write(helpText, text.WriteReplace())
+	opts := makeContainer(textBox, title, config, WidgetHelp)
+	textBox.Write(hotkeyHelpText(), text.WriteReplace())
+
+	return opts, nil
+}
+
+// Creates the help overlay which temporarily replaces the whole layout when '?' is pressed.
+func newHelpOverlay(config *PoptopConfig) ([]container.Option, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+
+	title := cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(" Poptop Hotkeys (press ? or Esc to close) ")
+
+	opts := makeContainer(textBox, title, config, noWidget)
+	textBox.Write(hotkeyHelpText(), text.WriteReplace())
 
 	return opts, nil
 }
@@ -196,6 +464,12 @@ func split(config *PoptopConfig, widgetA, widgetB []container.Option, rangeA, ra
 		horizontalSwitch = !horizontalSwitch
 	}
 
+	// the widgets were laid out in reverse so that the spare space falls at the start, swap each
+	// split back so they still read in order
+	if config.BigPaneFirst {
+		widgetA, widgetB = widgetB, widgetA
+	}
+
 	if horizontalSwitch {
 		return []container.Option{
 			container.SplitVertical(
@@ -209,6 +483,98 @@ func split(config *PoptopConfig, widgetA, widgetB []container.Option, rangeA, ra
 			container.Bottom(widgetB...))}
 }
 
+// Returns the percentage size of each of n equally sized grid rows or columns. The grid
+// builder requires percentages below 100, the last element fills any remainder anyway.
+func gridPerc(n int) int {
+	if n == 1 {
+		return 99
+	}
+	return 100 / n
+}
+
+// Lays widgets out left to right, top to bottom in a fixed grid of config.GridCols columns
+// and config.GridRows rows. If there are more widgets than cells then extra rows are added.
+func gridLayout(widgets Widgets, config *PoptopConfig) ([]container.Option, error) {
+	cols := config.GridCols
+	rows := max(config.GridRows, (len(widgets)+cols-1)/cols)
+
+	builder := grid.New()
+	for r := 0; r < rows; r++ {
+		row := []grid.Element{}
+		for c := 0; c < cols; c++ {
+			// Tab skips the empty cells
+			opts := []container.Option{container.KeyFocusSkip()}
+			if i := r*cols + c; i < len(widgets) {
+				opts = widgets[i]
+			}
+			row = append(row, grid.ColWidthPercWithOpts(gridPerc(cols), opts))
+		}
+		builder.Add(grid.RowHeightPerc(gridPerc(rows), row...))
+	}
+
+	opts, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, container.Border(linestyle.None)), nil
+}
+
+// A split in an equal size layout of the widgets from index first up to but not including end, with
+// the split's first pane getting perc percent of the space. Splits of a single widget have no panes.
+type equalSplit struct {
+	first, end int
+	perc       int
+	a, b       *equalSplit
+}
+
+// Splits the widgets from first up to end in two, sizing each pane by how many widgets it holds so
+// every widget ends up with roughly the same area however many there are
+func equalSplits(first, end int) *equalSplit {
+	node := &equalSplit{first: first, end: end}
+	n := end - first
+	if n < 2 {
+		return node
+	}
+
+	mid := first + n/2
+	node.perc = (mid - first) * 100 / n
+	node.a = equalSplits(first, mid)
+	node.b = equalSplits(mid, end)
+	return node
+}
+
+// Lays widgets out like layoutR, alternating between horizontal and vertical splits if tiling, but
+// splits by percentage so that every widget gets about the same area rather than some getting
+// larger panes when the count isn't a power of two
+func equalLayout(widgets Widgets, config *PoptopConfig, node *equalSplit, depth int) []container.Option {
+	if node.a == nil {
+		return widgets[node.first]
+	}
+
+	widgetA := equalLayout(widgets, config, node.a, depth+1)
+	widgetB := equalLayout(widgets, config, node.b, depth+1)
+
+	horizontalSwitch := config.SplitHorizontally
+	if config.TileWindows && depth%2 == 1 {
+		horizontalSwitch = !horizontalSwitch
+	}
+
+	if horizontalSwitch {
+		return []container.Option{
+			container.SplitVertical(
+				container.Left(widgetA...),
+				container.Right(widgetB...),
+				container.SplitPercent(node.perc))}
+	}
+
+	return []container.Option{
+		container.SplitHorizontal(
+			container.Top(widgetA...),
+			container.Bottom(widgetB...),
+			container.SplitPercent(node.perc))}
+}
+
 // Takes an array of widgets as [][]container.Option and returns a termdash
 // layout based on configuration.
 func layout(widgets Widgets, config *PoptopConfig) ([]container.Option, error) {
@@ -218,6 +584,26 @@ func layout(widgets Widgets, config *PoptopConfig) ([]container.Option, error) {
 		return widgets[0], nil
 	}
 
+	if config.GridCols > 0 {
+		return gridLayout(widgets, config)
+	}
+
+	if config.EqualPanes {
+		opts := equalLayout(widgets, config, equalSplits(0, len(widgets)), 0)
+		return append(opts, container.Border(linestyle.None)), nil
+	}
+
+	// the recursion leaves the spare space of a count which isn't a power of two at the end, giving
+	// the last widgets the larger panes. To give them to the first widgets instead we lay out the
+	// reversed list, then split swaps each pair back.
+	if config.BigPaneFirst {
+		reversed := make(Widgets, len(widgets))
+		for i, widget := range widgets {
+			reversed[len(widgets)-1-i] = widget
+		}
+		widgets = reversed
+	}
+
 	// define a range starting at 0 and ending with the length of the widget
 	// slice rounded up to a power of two
 	rangeA := 0
@@ -233,19 +619,39 @@ func layout(widgets Widgets, config *PoptopConfig) ([]container.Option, error) {
 	return opts, nil
 }
 
-// Execute a system command, returning a byte array of the output (both stdout and stderr)
-func commandWithContext(ctx context.Context, name string, arg ...string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, name, arg...)
+// Execute a system command, returning a byte array of the output (both stdout and stderr). The
+// command is killed if it runs for longer than timeout, where 0 means no limit. A killed command's
+// error wraps context.DeadlineExceeded so callers can treat it as a transient failure.
+func commandWithContext(ctx context.Context, timeout time.Duration, name string, arg ...string) ([]byte, error) {
+	cmdCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(cmdCtx, name, arg...)
 
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &buf
 
 	if err := cmd.Start(); err != nil {
+		if ctx.Err() != nil {
+			return buf.Bytes(), ctx.Err()
+		}
 		return buf.Bytes(), err
 	}
 
 	if err := cmd.Wait(); err != nil {
+		// a command killed because we're shutting down should look like a cancellation
+		// rather than a failure
+		if ctx.Err() != nil {
+			return buf.Bytes(), ctx.Err()
+		}
+		if cmdCtx.Err() != nil {
+			return buf.Bytes(), fmt.Errorf("%s timed out after %v: %w", name, timeout, cmdCtx.Err())
+		}
 		return buf.Bytes(), err
 	}
 
@@ -261,8 +667,27 @@ const (
 	WidgetTopCPU
 	WidgetTopMem
 	WidgetHelp
+	WidgetGPU
+	WidgetConnections
+	WidgetHostInfo
+	WidgetOverview
+	WidgetTopDisk
+	WidgetTopFiles
+	WidgetSwitches
+	WidgetDiskLatency
+	WidgetDiskSpace
+	WidgetFileLimits
+	WidgetTopNet
 )
 
+// The widgets which are charts of sampled values, which flags like --log-scale and --max-y apply to
+var chartWidgets = []int{WidgetCPULoad, WidgetCPUPerc, WidgetNetworkIO, WidgetDiskIOPS, WidgetDiskIO, WidgetGPU,
+	WidgetConnections, WidgetSwitches, WidgetDiskLatency, WidgetDiskSpace, WidgetFileLimits}
+
+func isChartWidget(widget int) bool {
+	return find(chartWidgets, widget) != -1
+}
+
 var shortcodeToWidget map[rune]int = map[rune]int{
 	'L': WidgetCPULoad,
 	'C': WidgetCPUPerc,
@@ -271,6 +696,17 @@ var shortcodeToWidget map[rune]int = map[rune]int{
 	'N': WidgetNetworkIO,
 	'T': WidgetTopCPU,
 	'M': WidgetTopMem,
+	'G': WidgetGPU,
+	'S': WidgetConnections,
+	'U': WidgetHostInfo,
+	'O': WidgetOverview,
+	'I': WidgetTopDisk,
+	'F': WidgetTopFiles,
+	'B': WidgetTopNet,
+	'K': WidgetSwitches,
+	'A': WidgetDiskLatency,
+	'V': WidgetDiskSpace,
+	'X': WidgetFileLimits,
 	'h': WidgetHelp,
 	'H': WidgetHelp,
 }
@@ -286,233 +722,1810 @@ type PoptopConfig struct {
 	// How frequently we want to sample (e.g. get current CPU load)
 	SampleInterval time.Duration
 
+	// Lengthen the sample interval while every chart is idle and shorten it again when one moves,
+	// keeping it between AdaptiveMin and AdaptiveMax
+	AdaptiveSampling bool
+	AdaptiveMin      time.Duration
+	AdaptiveMax      time.Duration
+
+	// Charts which are sampled at their own interval rather than SampleInterval, e.g. load which
+	// changes slowly, keyed by widget
+	WidgetIntervals map[int]time.Duration
+
+	// How frequently we want to refresh the top processes / memory lists
+	TopInterval time.Duration
+
+	// How long external commands like ps and nvidia-smi may run before we give up on them for that sample
+	CommandTimeout time.Duration
+
 	// How long to collect data before rolling over (i.e. width of chart x axis in time)
 	ChartDuration time.Duration
 
 	// How many samples will we retain (not set, but calculated using SampleInterval and ChartDuration
 	NumSamples int
 
-	// How many samples will be averaged into a single datapoint
-	SmoothingSamples int
+	// The most points a chart will retain, if ChartDuration needs more samples than this then several
+	// samples are averaged into each point. 0 means no cap.
+	MaxSamples int
 
-	// If we receive any flags for specific widgets we switch into a mode where we only show the specificed widgets
-	SelectWidgetsMode bool
+	// How many samples are averaged into each retained point (not set, but calculated using NumSamples and MaxSamples)
+	SamplesPerPoint int
 
-	// Number of lines to print in the top processes / memory lists
-	TopRowsShown int
+	// How many samples will be averaged into a single datapoint. + and - change it while the charts
+	// are sampling, so it's accessed atomically through Smoothing once they've started.
+	SmoothingSamples int32
 
-	// Split into horizontal panes rather than vertical
-	SplitHorizontally bool
+	// Smooth with a rolling median of SmoothingSamples samples rather than their mean
+	SmoothMedian bool
 
-	// Tile windows rather than put them all in a vertical or horizontal row
-	TileWindows bool
-}
+	// Draw sparklines rather than line charts so more widgets fit on screen
+	Compact bool
 
-// Kong CLI parser option configuration
-var cli struct {
-	Help            bool `short:"h" help:"Show help information"`
-	RedrawInterval  int  `short:"r" help:"Redraw interval in milliseconds (how often to repaint charts)" default:"500"`
-	SampleInterval  int  `short:"s" help:"Sample interval in milliseconds (how often to fetch a new datapoint" default:"500"`
-	ChartDuration   int  `short:"d" help:"Duration of the charted series in seconds (i.e. width of chart x-axis in time), 60 == 1 minute" default:"120"`
-	SplitHorizontal bool `short:"z" help:"Arrange panes horizontally rather than vertically"`
-	TileWindows     bool `short:"w" help:"Tile windows rather than placing them in a horizontal or vertical line"`
-	Smooth          int  `short:"a" help:"How many samples will be included in running average" default:"4"`
-	CpuLoad         bool `short:"L" help:"Add CPU Load chart to layout" default:"false"`
-	CpuPercent      bool `short:"C" help:"Add CPU % chart to layout" default:"false"`
-	DiskIops        bool `short:"D" help:"Add Disk IOPS chart to layout" default:"false"`
-	DiskIo          bool `short:"E" help:"Add Disk IO chart to layout" default:"false"`
-	NetworkIo       bool `short:"N" help:"Add Network IO chart to layout" default:"false"`
-	TopCpu          bool `short:"T" help:"Add Top Processes by CPU list to layout" default:"false"`
-	TopMemory       bool `short:"M" help:"Add Top Processes by Memory list to layout" default:"false"`
-}
+	// Start the Y axis of charts at zero so small changes aren't exaggerated, otherwise the axis
+	// zooms in to the range of the data
+	ZeroAnchor bool
 
-const description string = "A modern top command that charts system metrics like CPU load, network IO, etc in the terminal."
+	// Fix the Y axis of these widgets' charts at 0 to the given max, clipping larger values
+	MaxY map[int]float64
 
-const helpContent string = `Examples:
-  poptop -CL -d 30        Show only CPU Load and % charts for 30 second duration.
+	// Plot these widgets' charts on a log scale, for metrics like throughput which span orders of magnitude
+	LogScale map[int]bool
 
-  poptop -w -LCDN         Show 4 specific charts arranged in a square.
+	// Draw these series in the given colors rather than their defaults, keyed by names like "recv", see seriesColorNames
+	SeriesColors map[string]cell.Color
 
+	// The index in themes of the color theme to start with, t cycles through them at runtime
+	Theme int
 
-"What's going on with my local system?". Poptop turns your terminal into a dynamic charting tool for system metrics. While the top and htop commands show precise point-in-time data, Poptop aims to provide metrics over a time window to give a better at-a-glance summary of your system's activity. And make it look cool.
+	// Pick the light or default theme to suit the terminal's background once it's been detected
+	ThemeFromTerminal bool
 
-# Layout
+	// The line style of widget borders, linestyle.None drops them to give the widgets their space
+	Border linestyle.LineStyle
 
-Poptop displays some default charts, but also allows you to select your own. For example, 'poptop -LC' will display only CPU load and % charts. You can also add and remove charts at runtime by pressing the key corresponding to their flag (e.g. press C to toggle the CPU % chart).
+	// Draw these widgets' borders in the given line style rather than Border
+	Borders map[int]linestyle.LineStyle
 
-By default, all charts will be stacked vertically. You can use the -z flag to stack them horizontally instead.
+	// Draw a flat line at the average of each chart series' visible values
+	ShowAverages bool
 
-You can also use the -w flag to arrange charts in a square, i.e. to switch between vertical and horizontal stacking as the layout is built. 'z' and 'w' can also be pressed at runtime to change the layout dynamically.
+	// Show available memory in bytes in the Overview rather than the percentage used
+	MemAvailable bool
 
-# Metrics
+	// Show the p50/p95/max of each series over the visible window in chart titles
+	ShowStats bool
 
-## CPU Load (1min, 5min, 15min)
+	// Chart the min, average and max CPU idle % rather than busy %
+	CpuIdle bool
 
- Charts CPU load at 1, 5, 15min averages by calling sysctl.
+	// Shade the CPU chart between min and max with the average on top, rather than drawing three lines
+	CpuBand bool
 
- Load is one of the simplest metrics for understanding how busy your system is. It means roughly how many processes are executing or waiting to execute on a CPU. If load is higher than the number of CPU cores on your system then it indicates processes are having to wait for execution.
+	// Chart the user, system and iowait % of CPU time rather than the min, average and max busy %
+	CpuBreakdown bool
 
-## CPU (%) (min, avg, max)
+	// Label chart X axes with the time of day each point was sampled rather than seconds since the start of the chart
+	ClockLabels bool
 
- A chart to show min, average, max CPU busy % time. On MacOS this calls host_processor_info(). The judgement call here is that min, avg, max is a simpler way to understand CPU load rather than a single average, or charting per-CPU time.
+	// Label every nth sample on chart X axes, 0 to space the labels out to fit each chart's width
+	LabelEvery int
 
- CPU time here means the total time minus CPU idle time and IO wait time.
+	// Show a status bar along the bottom of the screen with the current settings
+	StatusBar bool
 
-## Network IO (KiB/s) (send, recv)
+	// Show a legend of the colors shared across charts above the status bar
+	Legend bool
 
- Chart to show throughput on all network devices in kibibytes per second using data from the netstat command.
+	// Drop every widget's titled border to give the widgets their space, b toggles it at runtime
+	HideTitles atomic.Bool
 
-## Disk IOPS (read, write)
+	// Chart the network and disk counters as running totals since poptop started rather than as rates
+	Cumulative bool
 
- Chart to show Disk IOPS (input/output operations per second) over time using data from iostat. Arguably, in an everyday scenario with many heavy processes then IOPS is a simpler metric than throughput, but if disk load is skewed to a specific process (e.g. heavy file copies, database operations), then disk throughput may be a better metric.
+	// Label the Y axes of the network and disk charts with precise numbers rather than compact ones like 125k
+	PreciseAxis bool
 
-## Disk IO (KiB/s) (read, write)
+	// Chart network throughput in bits rather than KiB
+	Bits bool
 
- Chart to show disk IO throughput in kibibytes per second based on iostat output. This chart currently shows only a single disk.
+	// Prime the counters of rate charts as they're created, so they chart from their first tick
+	SampleOnStart bool
 
-## Top CPU Processes (%, pid, command)
+	// If we receive any flags for specific widgets we switch into a mode where we only show the specificed widgets
+	SelectWidgetsMode bool
 
- Show a list of top CPU processes output by the ps command, i.e. which processes are consuming the most CPU. This is sampled at one-fourth of the sample interval rate since this is a point-in-time list rather than a chart. Run 'man ps' for more information on calculation methodology.
+	// Number of lines to print in the top processes / memory lists
+	TopRowsShown int
 
-## Top Memory Processes (%, pid, command)
+	// Show the full command path and arguments in the top processes / memory lists
+	FullCommand bool
 
- Show a list of top Memory processes output by the ps command, i.e. which processes are consuming the most real memory. This is sampled at one-fourth of the sample interval rate since this is a point-in-time list rather than a chart. Run 'man ps' for more information on calculation methodology.`
+	// The mount point of the filesystem the Disk Space chart shows
+	DiskSpacePath string
 
-func (this *PoptopConfig) selectWidget(widget int) {
-	if !this.SelectWidgetsMode {
-		this.SelectWidgetsMode = true
-		this.Widgets = []int{}
-	}
+	// Sum processes with the same command into one row of the top processes / memory lists. g
+	// toggles it while the lists refresh, so it's atomic.
+	GroupProcesses atomic.Bool
 
-	this.Widgets = append(this.Widgets, widget)
-}
+	// Rows of the top processes / memory lists at or above these percentages are drawn in ColorHot1, 0 to never highlight
+	HotCpuPerc float64
+	HotMemPerc float64
 
-func (this *PoptopConfig) ApplyFlags() error {
-	if cli.RedrawInterval < 50 {
-		return fmt.Errorf("You've set the redraw interval to %dms, this is likely to stress the system so we error out for values less than 50. The redraw-interval flag is in milliseconds.\n", cli.RedrawInterval)
-	}
-	this.RedrawInterval = time.Duration(cli.RedrawInterval) * time.Millisecond
+	// How long rows of processes which have just entered the top processes / memory lists are
+	// drawn in ColorNewProcess, 0 to never highlight them
+	NewProcessHighlight time.Duration
 
-	if cli.SampleInterval < 20 {
-		return fmt.Errorf("You've set the sample interval to %dms, this is likely to stress the system so we error out for values less than 20. The sample-interval flag is in milliseconds.\n", cli.SampleInterval)
-	}
-	this.SampleInterval = time.Duration(cli.SampleInterval) * time.Millisecond
+	// How the top CPU and memory lists are sorted, keyed by WidgetTopCPU and WidgetTopMem. A list
+	// without an entry is sorted by its own metric.
+	TopSorts map[int]*topSort
 
-	this.ChartDuration = time.Duration(cli.ChartDuration) * time.Second
-	this.SmoothingSamples = cli.Smooth
-	this.SplitHorizontally = cli.SplitHorizontal
-	this.TileWindows = cli.TileWindows
+	// Network interfaces to chart separately, if empty we chart the sum of all interfaces
+	NetInterfaces []string
 
-	if cli.CpuLoad {
-		this.selectWidget(WidgetCPULoad)
-	}
+	// Network interfaces to leave out of the summed network chart, either exact names or globs like "docker*"
+	ExcludeInterfaces []string
 
-	if cli.CpuPercent {
-		this.selectWidget(WidgetCPUPerc)
-	}
+	// Chart IPv4 and IPv6 network traffic separately where the system reports it, ignored if NetInterfaces is set
+	NetSplitFamily bool
 
-	if cli.DiskIops {
-		this.selectWidget(WidgetDiskIOPS)
-	}
+	// Chart packets per second sent and received below network throughput
+	NetPackets bool
 
-	if cli.DiskIo {
-		this.selectWidget(WidgetDiskIO)
-	}
+	// Chart network errors and dropped packets per second below network throughput
+	NetErrors bool
 
-	if cli.NetworkIo {
-		this.selectWidget(WidgetNetworkIO)
-	}
+	// Print one JSON object per sample to stdout rather than drawing charts in the terminal
+	JSONOutput bool
 
-	if cli.TopCpu {
-		this.selectWidget(WidgetTopCPU)
-	}
+	// Sample each enabled metric once, print a table to stdout and exit
+	Once bool
 
-	if cli.TopMemory {
-		this.selectWidget(WidgetTopMem)
-	}
+	// Exit cleanly after running for this long, e.g. to record a fixed stretch of samples, 0 to run until quit
+	RunDuration time.Duration
 
-	return nil
-}
+	// Print the network interface or disk names and exit, to help pick them out for other flags
+	ListInterfaces bool
+	ListDisks      bool
 
-func DefaultConfig() *PoptopConfig {
-	return &PoptopConfig{
-		Widgets:           []int{WidgetCPULoad, WidgetCPUPerc, WidgetDiskIOPS, WidgetTopCPU},
-		SelectWidgetsMode: false,
-		TopRowsShown:      25,
-	}
-}
+	// Split into horizontal panes rather than vertical
+	SplitHorizontally bool
 
-func (this *PoptopConfig) Finalize() {
-	// Calculate the number of samples we'll retain by dividing the chart duration by the sampling interval
-	this.NumSamples = int(math.Ceil(float64(this.ChartDuration) / float64(this.SampleInterval)))
-}
+	// Tile windows rather than put them all in a vertical or horizontal row
+	TileWindows bool
 
-const rootID = "root"
+	// When the widget count isn't a power of two some widgets get larger panes, give them to the
+	// first widgets rather than the last
+	BigPaneFirst bool
 
-func applyLayout(ctx context.Context, rootContainer *container.Container, config *PoptopConfig, widgetCache map[int][]container.Option) {
-	w, err := getWidgets(ctx, config, widgetCache)
-	if err != nil {
-		panic(err)
-	}
+	// Size panes by percentage so every widget gets about the same area, whatever the widget count
+	EqualPanes bool
 
-	gridOpts, err := layout(w, config)
-	if err != nil {
-		panic(err)
-	}
+	// Start with sampling paused, so the charts only sample when stepped or resumed
+	StartPaused bool
 
-	if err := rootContainer.Update(rootID, gridOpts...); err != nil {
-		panic(err)
-	}
-}
+	// The terminal library to draw with, termbox or tcell
+	Backend string
 
-func main() {
-	var err error
-	ctx, cancel := context.WithCancel(context.Background())
+	// Lay widgets out in a fixed grid of this many columns and rows rather than splitting
+	// on powers of two, set to 0 to disable
+	GridCols int
+	GridRows int
 
-	config := DefaultConfig()
-	kongCtx := kong.Parse(&cli, kong.Name("poptop"), kong.Description(description), kong.UsageOnError(), kong.NoDefaultHelp())
+	// Write every chart sample to this file so the session can be replayed later
+	RecordPath string
 
-	if cli.Help {
-		kong.DefaultHelpPrinter(kong.HelpOptions{}, kongCtx)
-		fmt.Printf("\n\n%s\n\n%s", helpContent, getBuildInfo())
-		os.Exit(0)
-	}
+	// Feed the charts from a file written with RecordPath rather than sampling the live system
+	ReplayPath string
 
-	err = config.ApplyFlags()
+	// How much faster than the original session to replay a recording
+	ReplaySpeed float64
 
-	if err != nil {
-		fmt.Print(err.Error())
-		os.Exit(1)
-	}
+	// Feed the charts from samples piped to stdin as JSON lines rather than sampling the live system
+	Stdin bool
 
-	config.Finalize()
+	// Feed the charts from poptop running on this host over ssh, e.g. "user@host", and the command
+	// which runs poptop there
+	RemoteHost    string
+	RemoteCommand string
 
-	var terminal terminalapi.Terminal
+	// Serve the latest chart samples over HTTP on this address, e.g. ":9100" or "unix:/tmp/poptop.sock"
+	ServeAddr string
 
-	terminal, err = termbox.New(termbox.ColorMode(terminalapi.ColorMode256))
-	//t, err = tcell.New(tcell.ColorMode(terminalapi.ColorMode256))
+	// Serve Go's pprof profiles of poptop itself on this address, e.g. "localhost:6060"
+	PprofAddr string
 
-	if err != nil {
-		panic(err)
-	}
+	// Set up in main from RecordPath, ReplayPath, Stdin or RemoteHost, and ServeAddr
+	recorder *sampleRecorder
+	replay   *replaySource
+	snapshot *metricsSnapshot
 
-	rootContainer, err := container.New(terminal, container.ID(rootID))
-	if err != nil {
-		panic(err)
-	}
+	// The sample and redraw intervals, which can be changed at runtime
+	sampleClock *liveInterval
+	redrawClock *liveInterval
 
-	widgetCache := newWidgetCache()
+	// How the charts which aren't sampled on the sample clock are sampled, set up in Finalize
+	samplings map[int]*chartSampling
 
-	applyLayout(ctx, rootContainer, config, widgetCache)
+	// Filters the top processes / memory lists, typed in at runtime
+	topFilter *processFilter
+
+	// Pauses sampling when a chart crosses its --freeze-on threshold, nil if none were set
+	freezeOn *freezer
+
+	// A brief message along the bottom of the screen, e.g. when a key which isn't a hotkey is pressed
+	toast toast
+
+	// Every chart's series, which pressing r clears
+	chartSeries seriesRegistry
+
+	// The points in time marked on the charts by pressing m, which pressing r also clears
+	markers chartMarkers
+
+	// Cancels the widgets which only run while they're in the layout, see stopRemovedWidgets
+	widgetCancels map[int]context.CancelFunc
+
+	// Tracks the sampling goroutines so we can wait for them to exit before closing the terminal
+	workers sync.WaitGroup
+
+	// The terminal drawn to, nil until it's opened, closed once by closeTerminal
+	terminal      terminalapi.Terminal
+	terminalClose sync.Once
+
+	// Set to 1 when a widget has changed since the screen was last drawn, accessed atomically
+	changed int32
+
+	// The widget last drawn with the keyboard focus, which hotkeys like o and < act on, or nil.
+	// termdash moves the focus on Tab or a click and the widget notes it as it's drawn.
+	focused atomic.Pointer[focusWatcher]
+
+	// Guards TopSorts, which o swaps while the top lists are sorting
+	topSortsMu sync.Mutex
+
+	// The IDs of titled containers nested inside a widget's container, whose borders applyLayout
+	// drops along with the widgets' own when titles are hidden. Only added to as widgets are built.
+	nestedTitles []string
+}
+
+// Below these intervals we're likely to stress the system, so they're enforced for both flags and hotkeys
+const (
+	minRedrawInterval = 50 * time.Millisecond
+	minSampleInterval = 20 * time.Millisecond
+
+	// we warn at startup if the chart series are likely to use more memory than this
+	seriesMemoryBudget = 16 * 1024 * 1024
+
+	// how long to wait on quit for sampling goroutines to exit before closing the terminal anyway
+	shutdownTimeout = 2 * time.Second
+
+	// the longest interval the hotkeys will slow down to
+	maxLiveInterval = time.Minute
+)
+
+// Kong CLI parser option configuration
+var cli struct {
+	Help              bool     `short:"h" help:"Show help information"`
+	Version           bool     `help:"Print the version, git commit and Go version, then exit"`
+	RedrawInterval    string   `short:"r" help:"Redraw interval, e.g. 500ms, or a number of milliseconds (how often to repaint charts)" default:"500ms"`
+	SampleInterval    string   `short:"s" help:"Sample interval, e.g. 500ms, or a number of milliseconds (how often to fetch a new datapoint)" default:"500ms"`
+	TopInterval       string   `short:"t" help:"Top process list refresh interval, e.g. 2s, or a number of milliseconds, defaults to 4x the sample interval" default:"0"`
+	CommandTimeout    string   `help:"How long external commands like ps and nvidia-smi may run before giving up on them for that sample, e.g. 2s, or a number of milliseconds" default:"2s"`
+	ChartDuration     string   `short:"d" help:"Duration of the charted series, e.g. 2m, or a number of seconds (i.e. width of chart x-axis in time)" default:"2m"`
+	SplitHorizontal   bool     `short:"z" help:"Arrange panes horizontally rather than vertically"`
+	TileWindows       bool     `short:"w" help:"Tile windows rather than placing them in a horizontal or vertical line"`
+	BigPanes          string   `help:"Which widgets get the larger panes when the widget count isn't a power of two, first or last" enum:"first,last" default:"last"`
+	Equal             bool     `help:"Give every widget about the same area, rather than splitting panes in half, when the widget count isn't a power of two" default:"false"`
+	Grid              string   `short:"g" help:"Arrange widgets in a fixed grid of COLSxROWS, e.g. 2x3, extra widgets wrap onto new rows"`
+	Smooth            int      `short:"a" help:"How many samples will be included in running average, 1 charts raw samples" default:"4"`
+	Raw               bool     `help:"Chart raw samples with no running average, the same as -a 1" default:"false"`
+	SmoothMode        string   `help:"How samples are smoothed, the mean or the median of the -a samples, which rejects single sample spikes" enum:"mean,median" default:"mean"`
+	MaxSamples        int      `help:"Cap the number of points each chart keeps, averaging several samples into each point when the chart duration needs more, e.g. for -d 1h -s 50ms. 0 means no cap" default:"0"`
+	Backend           string   `help:"Terminal library to draw with, termbox or tcell, which handles Unicode and resizing better on some platforms" enum:"termbox,tcell" default:"termbox"`
+	Compact           bool     `short:"c" help:"Draw compact sparklines rather than line charts to fit more widgets on screen" default:"false"`
+	Hires             bool     `help:"Not needed, line charts already draw at the finest resolution termdash has" hidden:""`
+	ZeroAnchor        bool     `help:"Start chart Y axes at zero, and percentage charts at 0-100, rather than zooming in to the range of the data" default:"true" negatable:""`
+	Legend            bool     `help:"Show a legend of the colors shared across charts, e.g. reads and writes, above the status bar, press l at runtime to toggle it" default:"false"`
+	NoTitles          bool     `help:"Drop the titled borders around widgets to give charts more room on small terminals, press b at runtime to bring them back" default:"false"`
+	StatusBar         bool     `help:"Show a status bar along the bottom with the sample interval, chart duration, smoothing, layout and widgets" default:"true" negatable:""`
+	Theme             string   `help:"Color theme to start with, one of default, ocean, ember, light or mono, press t to cycle through them at runtime" default:"default"`
+	ThemeFromTerminal bool     `help:"Use the light theme if the terminal has a light background and the default theme otherwise, detected from COLORFGBG or by asking the terminal" default:"false"`
+	Border            []string `help:"Line style of widget borders, one of round, light, double or none, which drops borders and titles to give widgets the space. Prefix a widget's flag to style only its border, e.g. T=none, can be repeated" placeholder:"[WIDGET=]STYLE"`
+	Color             []string `help:"Draw a series in a terminal color number from 0 to 255 rather than its default, as the series name and the color, e.g. recv=34 or load15=244, can be repeated"`
+	MaxY              []string `help:"Fix a chart's Y axis at 0 to a max, clipping larger values, as the widget's flag and the max, e.g. C=100 or N=5000, can be repeated"`
+	Adaptive          bool     `help:"Lengthen the sample interval while every chart is idle to save power, and shorten it again as soon as one moves" default:"false"`
+	AdaptiveMin       string   `help:"The shortest sample interval --adaptive samples at, e.g. 250ms, or a number of milliseconds, defaults to the sample interval" default:""`
+	AdaptiveMax       string   `help:"The longest sample interval --adaptive slows down to, e.g. 10s, or a number of milliseconds, defaults to 8x the shortest" default:""`
+	WidgetInterval    []string `help:"Sample a chart at its own interval rather than the sample interval, as the chart's flag and a duration, e.g. L=5s to sample the slowly changing load less often, can be repeated" placeholder:"CHART=DURATION"`
+	LogScale          []string `help:"Plot these charts on a log scale, as their widget flags, e.g. NE for the network and disk IO charts, can be repeated"`
+	Averages          bool     `help:"Draw a dimmed flat line on each chart at the average of each series over the charted duration" default:"false"`
+	FreezeOn          []string `help:"Pause sampling and beep when a chart crosses a threshold, as the widget's flag and the threshold, e.g. C=90 or N=5000, can be repeated. Press p to resume"`
+	RefreshPaused     bool     `help:"Start with sampling paused, then press . to take one sample at a time or p to resume, e.g. for repeatable screenshots" default:"false"`
+	Stats             bool     `short:"p" help:"Show the p50/p95/max of each series over the chart window in chart titles" default:"false"`
+	CpuIdle           bool     `help:"Chart the min, avg and max CPU idle % rather than busy %, i.e. the headroom left" default:"false"`
+	CpuBand           bool     `help:"Shade the CPU chart between the min and max with the average drawn on top, rather than three separate lines" default:"false"`
+	CpuBreakdown      bool     `help:"Chart the user, system and iowait % of CPU time rather than the min, avg and max busy %" default:"false"`
+	ClockLabels       bool     `help:"Label chart X axes with the time of day (HH:MM:SS) rather than seconds, e.g. to line up a spike with log timestamps" default:"false"`
+	LabelEvery        int      `help:"Label every Nth sample on chart X axes, by default labels are spaced out to fit each chart's width" placeholder:"N" default:"0"`
+	Cumulative        bool     `help:"Chart network and disk counters as running totals since starting rather than per second rates, e.g. to check how much a job transferred" default:"false"`
+	SampleOnStart     bool     `help:"Take a first sample of the rate charts, e.g. network and disk IO, as they're created so they chart from the first tick rather than the second" default:"true" negatable:""`
+	Bits              bool     `help:"Chart network throughput in bits per second, e.g. 940M on a gigabit link, rather than KiB/s" default:"false"`
+	PreciseAxis       bool     `help:"Label the network and disk charts' Y axes with precise numbers, e.g. 125000, rather than compact ones like 125k" default:"false"`
+	CpuLoad           bool     `short:"L" help:"Add CPU Load chart to layout" default:"false"`
+	CpuPercent        bool     `short:"C" help:"Add CPU % chart to layout" default:"false"`
+	DiskIops          bool     `short:"D" help:"Add Disk IOPS chart to layout" default:"false"`
+	DiskIo            bool     `short:"E" help:"Add Disk IO chart to layout" default:"false"`
+	NetworkIo         bool     `short:"N" help:"Add Network IO chart to layout" default:"false"`
+	TopCpu            bool     `short:"T" help:"Add Top Processes by CPU list to layout" default:"false"`
+	TopMemory         bool     `short:"M" help:"Add Top Processes by Memory list to layout" default:"false"`
+	Gpu               bool     `short:"G" help:"Add GPU chart to layout (requires nvidia-smi)" default:"false"`
+	Connections       bool     `short:"S" help:"Add network Connections chart to layout" default:"false"`
+	Switches          bool     `short:"K" help:"Add Context Switches and interrupts chart to layout (Linux only)" default:"false"`
+	DiskLatency       bool     `short:"A" help:"Add Disk Latency chart of the average milliseconds per read and write to layout" default:"false"`
+	DiskSpace         bool     `short:"V" help:"Add Disk Space chart of the bytes used on a filesystem, with an estimate of when it fills up, to layout" default:"false"`
+	DiskSpacePath     string   `help:"The mount point of the filesystem the Disk Space chart shows" placeholder:"PATH" default:"/"`
+	FileLimits        bool     `short:"X" help:"Add File Limits chart of the percentage of the file handle limit and of inodes in use to layout" default:"false"`
+	HostInfo          bool     `short:"U" help:"Add System Info (uptime, boot time, users) to layout" default:"false"`
+	Json              bool     `short:"j" help:"Don't draw charts, instead print one JSON object per sample interval to stdout" default:"false"`
+	NetInterface      []string `short:"i" help:"Chart this network interface separately rather than summing all interfaces, can be repeated"`
+	ExcludeInterface  []string `short:"x" help:"Leave this network interface out of the network chart, supports globs like 'docker*', can be repeated. Pass an empty string to include every interface" default:"lo,lo0"`
+	NetSplitFamily    bool     `help:"Split the network chart into IPv4 and IPv6 send and receive series, where the system reports them (Linux only)" default:"false"`
+	NetPackets        bool     `help:"Chart packets per second sent and received below the network throughput, to catch floods of small packets" default:"false"`
+	NetErrors         bool     `help:"Chart network errors and dropped packets per second below the network throughput, to diagnose a flaky NIC" default:"false"`
+	Overview          bool     `short:"O" help:"Add compact Overview of key metrics with sparklines to layout" default:"false"`
+	MemAvailable      bool     `help:"Show the memory available in the Overview, e.g. 5.2GiB, rather than the percentage used" default:"false"`
+	TopDisk           bool     `short:"I" help:"Add Top Processes by Disk IO list to layout (not available on MacOS)" default:"false"`
+	TopFiles          bool     `short:"F" help:"Add Top Processes by open files and threads list to layout" default:"false"`
+	TopNet            bool     `short:"B" help:"Add Top Processes by network bandwidth list to layout, which needs nethogs and usually root" default:"false"`
+	FullCommand       bool     `short:"f" help:"Show full command path and arguments in top process lists" default:"false"`
+	HotCpu            float64  `help:"Highlight rows of the Top CPU list using at least this CPU %, 0 to turn off" default:"50"`
+	HotMem            float64  `help:"Highlight rows of the Top Memory list using at least this memory %, 0 to turn off" default:"50"`
+	HighlightNew      string   `help:"How long to highlight rows of processes which have just entered a top list, e.g. 10s, or a number of milliseconds, 0 to turn off, defaults to one top refresh" default:""`
+	SortTop           []string `help:"Sort a top process list by cpu or mem rather than its own metric, as the list's flag and the sort, e.g. T=mem to sort the only list shown by memory, can be repeated. Press o at runtime to swap the sorts" placeholder:"LIST=SORT"`
+	Group             bool     `short:"k" help:"Group processes with the same command into one row of the top process lists, summing their CPU and memory" default:"false"`
+	Once              bool     `help:"Sample each enabled metric once, print a table to stdout and exit" default:"false"`
+	ListInterfaces    bool     `help:"Print the names of the network interfaces, e.g. for --net-interface or --exclude-interface, and exit" default:"false"`
+	ListDisks         bool     `help:"Print the names of the disks the disk charts add up and exit" default:"false"`
+	Record            string   `help:"Record every chart sample to this file so the session can be replayed with --replay" type:"path"`
+	Replay            string   `help:"Replay a session recorded with --record rather than charting the live system" type:"path"`
+	Stdin             bool     `help:"Chart samples piped to stdin rather than the live system, as lines of --json output or of a --record recording, e.g. ssh host poptop --json | poptop --stdin" default:"false"`
+	Remote            string   `help:"Chart a remote host rather than the live system by running poptop there over ssh, e.g. user@host, which needs poptop installed on the host and ssh keys set up" placeholder:"HOST"`
+	RemoteCommand     string   `help:"The command which runs poptop on the --remote host" default:"poptop"`
+	ReplaySpeed       float64  `help:"Speed multiplier when replaying a recording, e.g. 2 replays twice as fast as it was recorded" default:"1"`
+	Serve             string   `help:"Serve the latest chart samples over HTTP on this address, as JSON at /metrics.json and for Prometheus at /metrics, e.g. :9100 or unix:/tmp/poptop.sock" placeholder:"ADDR"`
+	Pprof             string   `help:"Serve Go's pprof profiles of poptop itself on this address, e.g. localhost:6060, for profiling poptop" placeholder:"ADDR" hidden:""`
+	DurationRuntime   string   `help:"Exit cleanly after running for this long, e.g. 60s or a number of seconds, handy with --record or --json to capture a fixed stretch of metrics"`
+}
+
+const description string = "A modern top command that charts system metrics like CPU load, network IO, etc in the terminal."
+
+const helpContent string = `Examples:
+  poptop -CL -d 30s       Show only CPU Load and % charts for 30 second duration.
+
+  poptop -s 250ms -d 5m   Sample every 250 milliseconds and chart the last 5 minutes.
+
+  poptop -w -LCDN         Show 4 specific charts arranged in a square.
+
+  poptop -j -LN | jq .    Print CPU Load and Network IO as JSON rather than charting them.
+
+  poptop --once -LCN      Print a single reading of CPU Load, CPU % and Network IO and exit.
+
+
+"What's going on with my local system?". Poptop turns your terminal into a dynamic charting tool for system metrics. While the top and htop commands show precise point-in-time data, Poptop aims to provide metrics over a time window to give a better at-a-glance summary of your system's activity. And make it look cool.
+
+# Layout
+
+Poptop displays some default charts, but also allows you to select your own. For example, 'poptop -LC' will display only CPU load and % charts. You can also add and remove charts at runtime by pressing the key corresponding to their flag (e.g. press C to toggle the CPU % chart). Press ? at runtime to see a list of all hotkeys, pressing a key which isn't one briefly lists the valid keys in the status bar.
+
+The status bar along the bottom of the screen shows the current sample and redraw intervals, chart duration, smoothing, layout and widgets, which change as you press hotkeys. Use --no-status-bar to give its row to the widgets.
+
+Use --legend or press l to show a row above the status bar explaining the colors charts share, e.g. that every disk chart draws reads and writes in the same two colors and the CPU chart's min, avg and max lines. It follows the theme and any --color, so it stays accurate as either changes.
+
+By default, all charts will be stacked vertically. You can use the -z flag to stack them horizontally instead.
+
+You can also use the -w flag to arrange charts in a square, i.e. to switch between vertical and horizontal stacking as the layout is built. 'z' and 'w' can also be pressed at runtime to change the layout dynamically, and < or > moves the chart you last added (or otherwise the last chart) earlier or later in the layout.
+
+Press Tab to focus the next widget, or click on one, which draws its border in the title color. Widgets drawn without a border, with --border none or after pressing b, highlight their top row instead. Tab steps through each chart of a widget made of several, like the network throughput and packet charts of --net-packets. The hotkeys which act on one widget then act on the focused one, < and > move it rather than the last added chart and o swaps only its sort if it's a top process list. Esc clears the focus, so with a widget focused it takes a second Esc to quit. Terminals send Shift-Tab as an escape sequence which the terminal libraries poptop draws with don't pass on, so Tab only cycles forwards.
+
+Panes are split in half until there's one per chart, so when the number of charts isn't a power of two some get larger panes, e.g. with 3 charts the last takes half the screen. Use '--big-panes first' to give the larger panes to the first charts instead. Or use --equal to size the panes so that every chart gets about the same area.
+
+For a predictable layout use the -g flag to place charts in a fixed grid, e.g. 'poptop -g 2x3' arranges charts left to right, top to bottom in 2 columns and 3 rows. If there are more charts than grid cells then extra rows are added.
+
+Use the -c flag to draw compact sparklines rather than full line charts, which fits many more charts on screen at the cost of axes. Line charts are drawn in braille characters, plotting two points across and four up each cell, which is already the finest resolution termdash draws at. If the terminal's font has no braille characters the lines come out as boxes or gaps, use -c then, as sparklines are drawn with block characters that every terminal font has.
+
+Widget borders are drawn with rounded corners, use --border to draw them in light, double or none, e.g. '--border double'. With none the borders and their titles are dropped, which gives every widget their rows and columns, though the titles carry each chart's latest values. Prefix a widget's flag to change only its border, e.g. '--border T=none' for a borderless Top CPU list, or '--border light --border O=double' to set every border and then the Overview's.
+
+On a small terminal use --no-titles or press b to drop every widget's titled border, which gives the charts the rows and columns the borders took. The titles show each chart's latest values, so press b again to bring them back for a look, the charts keep their history either way.
+
+Poptop draws with termbox by default. If characters or resizing look wrong in your terminal, try '--backend tcell' instead.
+
+Chart Y axes start at zero so that a CPU chart moving between 40% and 42% doesn't look like wild swings, and percentage charts span 0 to 100%. Use --no-zero-anchor to zoom the Y axis in to the range of the data instead. To keep a chart's scale stable use --max-y with the chart's flag, e.g. '--max-y N=5000' fixes the Network IO Y axis at 0 to 5000 KiB/s, drawing larger values at the top of the chart rather than rescaling.
+
+Charted values are a moving average over several samples, set with the -a flag, which can hide short spikes. Use --raw or '-a 1' to chart raw samples instead. Press + or - at runtime to smooth over more or fewer samples, while smoothing is on each chart title ends with the number of samples, e.g. '(smoothed x4)'. Use '--smooth-mode median' to chart the rolling median of the samples instead, which drops one-off spikes rather than spreading them over the window, the titles then end with e.g. '(median x4)'.
+
+Press [ or ] at runtime to sample twice or half as often, and { or } to redraw twice or half as often. Charts keep the same number of samples, so sampling less often charts a longer duration. The top process lists keep the interval they started with. The screen is only redrawn when something on it has changed, so redrawing faster than sampling costs little, and nothing is redrawn while paused.
+
+Use --adaptive to save power on an idle system. While every chart has stayed flat for 10 samples the sample interval doubles, up to --adaptive-max, and as soon as any chart moves it drops back to --adaptive-min, e.g. 'poptop --adaptive -s 250ms --adaptive-max 4s' samples every 4s while idle and every 250ms during activity. The minimum defaults to the sample interval and the maximum to 8 times the minimum. As with [ and ], the charts keep the same number of samples so cover a longer duration while idle, and the status bar shows the current interval. --adaptive sets the interval itself after every sample, so it overrides [ and ], which only last until the next sample.
+
+Use --widget-interval to sample a chart at its own interval, e.g. '--widget-interval L=5s --widget-interval N=250ms' samples the slowly changing load every 5s and network IO every 250ms, which saves the overhead of sampling slow metrics often while keeping fast ones responsive. Each chart still covers the chart duration, and [ and ] speed up or slow down every chart's sampling in proportion. The Connections chart is sampled every 4 sample intervals unless it's given an interval, as listing connections can be slow.
+
+Long chart durations with short sample intervals keep a lot of samples, e.g. '-d 1h -s 50ms' keeps 72,000 per series, and poptop warns at startup if the charts are likely to use more than 16 MiB. Use --max-samples to cap the points each chart keeps, several samples are then averaged into each point and smoothing with -a works on those points.
+
+Chart X axes are labelled in seconds since the start of the chart window. Use --clock-labels to label them with the time of day instead, which makes it easier to line a spike up with timestamps in logs. Labels are spaced out to suit each chart's width, use --label-every to label every Nth sample instead. Press m to mark now on every line chart, the marker is drawn as a numbered label like '▲1' under the point sampled when it was made and scrolls off with the data, which makes it easy to line up something you did with what the charts do after it. Pressing r clears the markers along with the charts.
+
+Each chart title shows the latest value of each series. Use the -p flag to also show the 50th percentile, 95th percentile and max of each series over the chart window, e.g. '1min: 2.3 [1.9/2.8/3.0]'.
+
+Network and disk throughput can span several orders of magnitude, so a burst hides everything else on a linear Y axis. Use --log-scale with the flags of the charts to plot on a log scale, e.g. '--log-scale NE' for the network and disk IO charts. The Y axis is still labelled with the real values. Compact sparklines are always linear.
+
+Use --averages to draw a dimmed flat line on each chart at the average of each series, which follows the chart as it scrolls and makes it easy to see whether the latest values are high or low for the window. Compact sparklines don't show averages.
+
+Use --color to draw a series in a different terminal color, from 0 to 255, e.g. '--color recv=34' draws received network traffic in green wherever it's charted. Series are named like they are in --json output, e.g. load1, avg, sent, recv, read, write, time_wait or ctxt, the GPU chart's util and vram, the Disk Space chart's used and free, and the File Limits chart's handles and inodes. Average lines for recolored series are drawn in gray.
+
+Use --theme to pick a color theme, one of default, ocean, ember, light or mono, or press t at runtime to cycle through them. Themes swap the default colors for their own as the screen is drawn so every widget changes at once. Colors set with --color are left alone unless they're one of the default colors.
+
+The default palette is drawn for a dark background, on a light terminal use '--theme light' or --theme-from-terminal to pick the light theme only when the background is light. The background is read from the COLORFGBG variable that some terminals set, otherwise poptop asks the terminal at startup and waits up to 200ms for the answer. If neither says, the default theme is used.
+
+# Pausing
+
+Press p at runtime to pause sampling, which freezes the charts and top lists so you can take a closer look, then p again to resume. While paused press . to take a single sample, or use --refresh-paused to start out paused and step through samples from the beginning, which is handy for repeatable screenshots. The top lists stay as they were until sampling resumes. To catch an intermittent spike use --freeze-on with a chart's flag and a threshold, e.g. '--freeze-on C=90 --freeze-on N=5000', and poptop pauses and beeps as soon as any of that chart's values cross it. The threshold applies to the values the chart shows, e.g. idle % with --cpu-idle. Once resumed a chart only freezes again after dropping back below its threshold and crossing it again.
+
+Press r to clear the history of every chart and start charting afresh, e.g. after a burst of activity has squashed the rest of the chart, without having to restart.
+
+Press s to save the data behind every chart to a CSV file in the current directory named for the time, e.g. poptop-20260102-150405.csv, which is handy for bug reports and sharing as it's easier than capturing the terminal. The status bar shows where it was saved. There's a column for each series, e.g. cpuLoad.load1, holding the points the chart currently covers from oldest to newest, and the rows line up at the newest point. Values are saved in the units they're sampled in, so network throughput is in KiB even with --bits. Every chart shown since starting is saved, including ones which have since been toggled off.
+
+# Recording
+
+Use '--record session.jsonl' to write every chart sample to a file, then '--replay session.jsonl' to play the session back into the charts rather than sampling the live system, which is handy for debugging and demos. Samples are replayed with their original timing, or faster or slower with e.g. '--replay-speed 4'. Top process lists, the Overview and System Info always show the live system.
+
+Use --stdin to chart samples piped in rather than the live system, e.g. 'ssh host poptop --json -s 1s | poptop --stdin -s 1s' charts a remote host in the local terminal. Each line is either a line of --json output, which has a timestamp and an object for each chart such as load or cpu, or a line of a --record recording, so 'cat session.jsonl | poptop --stdin' replays a recording too. Samples are charted with the timing they were taken at, so sample at the same interval on both ends. Charts that --json doesn't output, the --cpu-breakdown chart and the --net-packets and --net-errors charts, stay empty, while the top process lists, Overview and System Info show the local system.
+
+Use '--remote user@host' to chart another machine, which runs poptop there over ssh in --json mode and charts its samples as --stdin would. poptop needs to be installed on the host, use --remote-command if it's not on the PATH, and ssh must be able to log in without a password, e.g. with keys or an agent. If the connection drops poptop says so and reconnects every few seconds, carrying on the charts from where they left off. Only the charts shown at startup that --json outputs are sampled on the host, with the flags which change what they sample passed on, i.e. -s, -i, --exclude-interface, --cumulative, --net-split-family and --disk-space-path. The top process lists, Overview and System Info show the local system.
+
+Use '--duration-runtime 60s' to exit cleanly after a minute, e.g. '--record session.jsonl --duration-runtime 60s' or '--json --duration-runtime 60s > samples.jsonl' to capture a fixed stretch of metrics unattended.
+
+Use '--serve :9100' to also serve the latest sample of every chart over HTTP while the charts are showing, as JSON at /metrics.json and in the Prometheus text format at /metrics, e.g. 'curl localhost:9100/metrics.json', or point Prometheus at it to scrape metrics like poptop_cpu_load{window="1m"} and poptop_network_kibibytes_per_second{direction="sent"}. With --cumulative the counters are exposed as totals, e.g. poptop_network_kibibytes_total. Pass 'unix:/path/to/socket' to serve on a Unix socket instead. Only the charts being shown are sampled, so only they are served.
+
+# Metrics
+
+## CPU Load (1min, 5min, 15min)
+
+ Charts CPU load at 1, 5, 15min averages by calling sysctl.
+
+ Load is one of the simplest metrics for understanding how busy your system is. It means roughly how many processes are executing or waiting to execute on a CPU. If load is higher than the number of CPU cores on your system then it indicates processes are having to wait for execution. To make this easy to spot the 1min series turns from green to red whenever it's above the number of logical CPUs.
+
+## CPU (%) (min, avg, max)
+
+ A chart to show min, average, max CPU busy % time. On MacOS this calls host_processor_info(). The judgement call here is that min, avg, max is a simpler way to understand CPU load rather than a single average, or charting per-CPU time.
+
+ CPU time here means the total time minus CPU idle time and IO wait time.
+
+ Use --cpu-idle to chart idle % instead, i.e. 100% minus busy, if you find headroom easier to reason about. The min is then the least idle CPU so it's drawn in the hot color.
+
+ Use --cpu-band to shade the area between the min and max with the average drawn on top, which shows the spread across CPUs at a glance. Compact sparklines always show three lines.
+
+ Use --cpu-breakdown to instead chart the % of CPU time spent in user space (including niced processes), the kernel, and waiting on IO. A high iowait is a good sign of a disk bound workload. Only Linux reports iowait, so elsewhere just user and system are charted.
+
+## Network IO (KiB/s) (send, recv)
+
+ Chart to show throughput on all network devices in kibibytes per second using data from the netstat command. Use the -i flag to instead chart specific interfaces separately, e.g. 'poptop -N -i en0 -i utun3' to separate wifi from VPN traffic.
+
+ Loopback traffic usually isn't interesting so the lo and lo0 interfaces are excluded by default. Use the -x flag to exclude other interfaces, e.g. 'poptop -N -x lo -x "docker*"', or 'poptop -N -x ""' to include every interface. Run 'poptop --list-interfaces' to see the interface names, or 'poptop --list-disks' for the disks which the disk charts add up.
+
+ Use --net-split-family to chart IPv4 and IPv6 traffic separately. These counts come from the kernel's IP statistics, so they cover every interface including loopback and aren't available with -i. This is only supported on Linux, elsewhere the chart falls back to combined send and receive.
+
+ Use --net-packets to also chart packets per second sent and received below the throughput, for the same interfaces. A flood of small packets can overwhelm a host while barely showing up as throughput. The --max-y, --log-scale and --freeze-on settings for the network chart only apply to throughput.
+
+ Use --net-errors to chart network errors and dropped packets per second the same way, alongside the packets if --net-packets is also set. These are usually zero so any errors at all, drawn in red, are a strong sign of a flaky NIC or cable.
+
+ Use --bits to chart throughput in bits per second, the way links are rated, rather than KiB/s. The title then reads "Network IO (bit/s)" with values like 940.0Mbps, and the Y axis is labelled e.g. 940M. --max-y and --freeze-on thresholds for the chart are in bits too, e.g. '--max-y N=1e9' for a gigabit link, while --json, --record, --serve and the Overview stay in KiB/s.
+
+## Disk IOPS (read, write)
+
+ Chart to show Disk IOPS (input/output operations per second) over time using data from iostat. Arguably, in an everyday scenario with many heavy processes then IOPS is a simpler metric than throughput, but if disk load is skewed to a specific process (e.g. heavy file copies, database operations), then disk throughput may be a better metric.
+
+## Disk IO (KiB/s) (read, write)
+
+ Chart to show disk IO throughput in kibibytes per second based on iostat output. This chart currently shows only a single disk.
+
+ The disk charts read the kernel's disk counters through gopsutil. Where those aren't available, such as MacOS builds without cgo, they fall back to parsing 'iostat' and say so in their titles, e.g. "Disk IOPS via iostat". iostat only reports transfers and megabytes, so the fallback charts show a single total rather than read and write.
+
+ Use --cumulative to chart the network and disk charts as running totals since poptop started rather than per second rates, e.g. "Network IO (KiB)" and "Disk Ops", which is handy for checking how much a job transferred in total. The Y axis labels switch to K, M, G and so on as the totals grow. With --json the totals are output in place of the rates, and each line has "cumulative": true so --stdin charts them as totals.
+
+ The network and disk charts label their Y axes compactly, e.g. 125k or 1.5M rather than 125000 or 1500000, so large rates are easy to scan. Their titles still show precise values, use --precise-axis to label the axes precisely too.
+
+ Rates are worked out from the change in a counter between two samples, so the network, disk, Context Switches and Disk Latency charts take a first sample as they're created, which only primes their counters, and start charting on the first tick. While paused or replaying this is skipped and the charts start on their second sample, use --no-sample-on-start to always wait for it.
+
+## Top CPU Processes (%, pid, command)
+
+ Show a list of top CPU processes output by the ps command, i.e. which processes are consuming the most CPU. This is sampled at one-fourth of the sample interval rate since this is a point-in-time list rather than a chart, use the -t flag to set a different refresh interval. Run 'man ps' for more information on calculation methodology.
+
+ Windows has no ps command, and minimal systems may not have it installed, so there processes are listed through the system APIs instead, which is a little slower. As with ps the CPU % of each process is averaged over its lifetime. poptop warns at startup when a widget's tool such as ps or nvidia-smi is missing, in the status bar and again once it exits.
+
+ By default only the executable name is shown, use the -f flag to show the full command path and arguments, e.g. to tell apart several python or node processes. Lines longer than the widget are truncated.
+
+ Press / at runtime to filter both top lists to processes whose command or user contains the text you type, ignoring case. Press Enter to stop typing and keep the filter, or Esc to clear it.
+
+ Use the -k flag, or press g at runtime, to group processes with the same command into one row which sums their CPU and memory and shows the number of instances in place of the pid, e.g. 'x12'.
+
+ Each list is sorted by its own metric, use --sort-top to sort a list by the other, e.g. '-T --sort-top T=mem' to see the biggest memory users when only the CPU list fits. The list then shows memory % and its title says it's sorted by memory. Press o at runtime to swap each list between sorting by CPU and memory, starting from the sorts set with --sort-top, or focus one list with Tab to swap only its sort.
+
+ The CPU and memory lists show every process, click on a list and scroll through it with the arrow keys, PgUp / PgDn or the mouse wheel.
+
+ If ps takes longer than the command timeout, 2s by default or set with --command-timeout, the lists show an error and try again at the next refresh. The GPU chart does the same for nvidia-smi.
+
+ Processes using at least 50% CPU or memory are highlighted, use --hot-cpu and --hot-mem to set other thresholds, or 0 to turn highlighting off. Processes which have just entered the top of a list are highlighted in green for one list refresh, set how long with --highlight-new, or 0 to turn that off.
+
+## Top Memory Processes (%, pid, command)
+
+ Show a list of top Memory processes output by the ps command, i.e. which processes are consuming the most real memory. This is sampled at one-fourth of the sample interval rate since this is a point-in-time list rather than a chart, use the -t flag to set a different refresh interval. Run 'man ps' for more information on calculation methodology.
+
+## GPU (%) (util, vram)
+
+ Chart to show GPU utilization and VRAM used as a percentage of total VRAM using data from nvidia-smi, so this only works for NVIDIA GPUs. Each GPU is charted as a separate pair of lines.
+
+## Connections (established, time_wait, listen)
+
+ Chart to show the number of open network connections by state, which is useful for spotting connection leaks. Listing connections can be slow so this is sampled at one-fourth of the sample interval rate. Depending on your system you may need elevated privileges to see every process's connections.
+
+## Context Switches (/s) (ctxt, intr)
+
+ Chart to show context switches and interrupts per second across the system, from /proc/stat. A sudden jump in context switches with no more work getting done is a sign of a scheduling storm, e.g. lock contention or too many threads. This is only supported on Linux.
+
+## Disk Latency (ms) (read, write)
+
+ Chart to show the average time each disk read and write took over the last sample, which is closer to how much disk pressure slows things down than IOPS, as a disk that's struggling takes longer for each request however many there are. On Linux this is from /proc/diskstats, on MacOS it needs a build with cgo as iostat doesn't report latency. A sample with no reads or writes is charted as 0ms.
+
+## Disk Space (used, free)
+
+ Chart to show the bytes used on the filesystem mounted at / over time, set another mount point with --disk-space-path. While usage is climbing the title estimates how long until the filesystem is full from the trend across the chart, e.g. 'full in ~3h', which catches runaway logs before they fill the disk. Usage changes slowly so it's sampled at a fifth of the sample rate.
+
+## File Limits (handles, inodes)
+
+ Chart to show how close the system is to running out of file handles or inodes, as a percentage of the limit, since running out of either causes failures that are hard to trace back, e.g. "Too many open files" or "No space left on device" on a disk with space to spare. Handles are the system-wide count from /proc/sys/fs/file-nr against its maximum, which only Linux reports, so elsewhere only inodes are charted. Inodes are for whichever mounted filesystem has used the highest share of its inodes, run 'df -i' to see which. A series turns red at 90% of its limit. These change slowly so are sampled at a fifth of the sample rate.
+
+## System Info
+
+ Show the hostname, platform, uptime, boot time and number of logged in users. These change slowly so are only sampled every few seconds.
+
+## Overview
+
+ A dense summary of the current CPU load, CPU %, memory %, network throughput and disk throughput, each alongside a sparkline of recent values. This fits a lot of information into a small terminal.
+
+ Use --mem-available to show the memory available for starting new processes rather than the percentage used, in the units that suit it, e.g. 5.2GiB. This counts memory the system can reclaim such as the file cache, so it's usually more than the free memory. The sparkline then dips as memory runs low.
+
+## Top Disk Processes (read KiB/s, write KiB/s, pid, command)
+
+ Show a list of the processes reading from and writing to disk the most since the last refresh, using per-process IO counters. These are only available on some platforms, e.g. Linux but not MacOS, and processes owned by other users may be left out unless you have permission to read their counters. Like the other top lists this is refreshed every top interval, set with the -t flag.
+
+## Top Open Files Processes (files, threads, pid, command)
+
+ Show a list of the processes with the most open file descriptors along with their thread counts, which helps catch file descriptor leaks before they hit ulimits. Counting another user's open files usually needs elevated privileges, so those are shown as '-' and ranked last. MacOS can't count open files per process, so there this lists the processes with the most threads instead. Like the other top lists this is refreshed every top interval.
+
+## Top Network Processes (sent KiB/s, recv KiB/s, pid, command)
+
+ Show a list of the processes sending and receiving the most over the network, to find what's eating the bandwidth. Operating systems don't count traffic per process, so this runs nethogs in the background while the list is in the layout, which matches captured packets to the processes owning their sockets. nethogs has to be installed and usually needs root, or the cap_net_admin and cap_net_raw capabilities, otherwise the list says why it isn't available. nethogs refreshes every top interval to the nearest second, set with the -t flag.`
+
+func (this *PoptopConfig) selectWidget(widget int) {
+	if !this.SelectWidgetsMode {
+		this.SelectWidgetsMode = true
+		this.Widgets = []int{}
+	}
+
+	this.Widgets = append(this.Widgets, widget)
+}
+
+func (this *PoptopConfig) ApplyFlags() error {
+	redrawInterval, err := parseDurationFlag("redraw-interval", cli.RedrawInterval, time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if redrawInterval < minRedrawInterval {
+		return fmt.Errorf("You've set the redraw interval to %v, this is likely to stress the system so we error out for values less than 50ms.\n", redrawInterval)
+	}
+	this.RedrawInterval = redrawInterval
+
+	sampleInterval, err := parseDurationFlag("sample-interval", cli.SampleInterval, time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if sampleInterval < minSampleInterval {
+		return fmt.Errorf("You've set the sample interval to %v, this is likely to stress the system so we error out for values less than 20ms.\n", sampleInterval)
+	}
+	this.SampleInterval = sampleInterval
+
+	topInterval, err := parseDurationFlag("top-interval", cli.TopInterval, time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if topInterval == 0 {
+		// sample top less frequently than other charts by default because it's a point-in-time measure
+		this.TopInterval = this.SampleInterval * 4
+	} else if topInterval < 100*time.Millisecond {
+		return fmt.Errorf("You've set the top interval to %v, running ps this often is likely to stress the system so we error out for values less than 100ms.\n", topInterval)
+	} else {
+		this.TopInterval = topInterval
+	}
+
+	commandTimeout, err := parseDurationFlag("command-timeout", cli.CommandTimeout, time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if commandTimeout <= 0 {
+		return fmt.Errorf("You've set the command timeout to %v, it must be greater than 0.\n", commandTimeout)
+	}
+	this.CommandTimeout = commandTimeout
+
+	chartDuration, err := parseDurationFlag("chart-duration", cli.ChartDuration, time.Second)
+	if err != nil {
+		return err
+	}
+	if chartDuration < this.SampleInterval {
+		return fmt.Errorf("You've set the chart duration to %v which is shorter than the sample interval of %v, so there would be nothing to chart.\n", chartDuration, this.SampleInterval)
+	}
+	this.ChartDuration = chartDuration
+
+	if cli.MaxSamples < 0 || cli.MaxSamples == 1 {
+		return fmt.Errorf("You've set the max samples to %d, it must be at least 2, or 0 for no cap.\n", cli.MaxSamples)
+	}
+	this.MaxSamples = cli.MaxSamples
+	this.SmoothingSamples = int32(cli.Smooth)
+	if cli.Raw {
+		this.SmoothingSamples = 1
+	}
+	this.SmoothMedian = cli.SmoothMode == "median"
+	this.ShowStats = cli.Stats
+	this.CpuIdle = cli.CpuIdle
+	this.CpuBand = cli.CpuBand
+	this.CpuBreakdown = cli.CpuBreakdown
+
+	if this.CpuIdle && this.CpuBreakdown {
+		return fmt.Errorf("The --cpu-idle and --cpu-breakdown flags can't be used together.\n")
+	}
+	if this.CpuBand && this.CpuBreakdown {
+		return fmt.Errorf("The --cpu-band and --cpu-breakdown flags can't be used together.\n")
+	}
+	this.ClockLabels = cli.ClockLabels
+	if cli.LabelEvery < 0 {
+		return fmt.Errorf("Couldn't use %d for the --label-every flag, use a number of samples, or 0 to fit the labels to each chart's width.\n", cli.LabelEvery)
+	}
+	this.LabelEvery = cli.LabelEvery
+	this.ZeroAnchor = cli.ZeroAnchor
+	this.StatusBar = cli.StatusBar
+	this.Legend = cli.Legend
+	this.HideTitles.Store(cli.NoTitles)
+
+	maxY, err := parseMaxY(cli.MaxY)
+	if err != nil {
+		return err
+	}
+	this.MaxY = maxY
+
+	seriesColors, err := parseSeriesColors(cli.Color)
+	if err != nil {
+		return err
+	}
+	this.SeriesColors = seriesColors
+
+	this.Theme = find(themeNames(), cli.Theme)
+	if this.Theme == -1 {
+		return fmt.Errorf("There's no theme called '%s', the themes are %s.\n", cli.Theme, strings.Join(themeNames(), ", "))
+	}
+	if cli.ThemeFromTerminal && cli.Theme != "default" {
+		return fmt.Errorf("The --theme-from-terminal flag picks the theme so can't be used with --theme.\n")
+	}
+	this.ThemeFromTerminal = cli.ThemeFromTerminal
+
+	border, borders, err := parseBorders(cli.Border)
+	if err != nil {
+		return err
+	}
+	this.Border, this.Borders = border, borders
+
+	logScale, err := parseLogScale(cli.LogScale)
+	if err != nil {
+		return err
+	}
+	this.LogScale = logScale
+	this.Compact = cli.Compact
+	if cli.Hires {
+		return fmt.Errorf("Line charts are already drawn in braille, two points across and four up each cell, which is the finest resolution termdash draws at, so --hires has nothing to switch on. If braille comes out as boxes or gaps in your terminal's font, use -c to draw sparklines instead.\n")
+	}
+	this.SplitHorizontally = cli.SplitHorizontal
+	this.TileWindows = cli.TileWindows
+	this.BigPaneFirst = cli.BigPanes == "first"
+	this.EqualPanes = cli.Equal
+	this.StartPaused = cli.RefreshPaused
+	this.ShowAverages = cli.Averages
+	this.MemAvailable = cli.MemAvailable
+	this.Backend = cli.Backend
+
+	if cli.Grid != "" {
+		cols, rows, err := parseGrid(cli.Grid)
+		if err != nil {
+			return err
+		}
+		this.GridCols = cols
+		this.GridRows = rows
+	}
+	this.FullCommand = cli.FullCommand
+	this.DiskSpacePath = cli.DiskSpacePath
+
+	if cli.HotCpu < 0 || cli.HotMem < 0 {
+		return fmt.Errorf("You've set the hot CPU and memory thresholds to %v%% and %v%%, they can't be negative.\n", cli.HotCpu, cli.HotMem)
+	}
+	this.HotCpuPerc = cli.HotCpu
+	this.HotMemPerc = cli.HotMem
+
+	this.NewProcessHighlight = this.TopInterval
+	if cli.HighlightNew != "" {
+		highlight, err := parseDurationFlag("highlight-new", cli.HighlightNew, time.Millisecond)
+		if err != nil {
+			return err
+		}
+		if highlight < 0 {
+			return fmt.Errorf("You've set the new process highlight to %v, it can't be negative.\n", highlight)
+		}
+		this.NewProcessHighlight = highlight
+	}
+
+	topSorts, err := parseTopSorts(cli.SortTop)
+	if err != nil {
+		return err
+	}
+	this.TopSorts = topSorts
+	this.GroupProcesses.Store(cli.Group)
+	this.JSONOutput = cli.Json
+	this.Once = cli.Once
+	this.ListInterfaces = cli.ListInterfaces
+	this.ListDisks = cli.ListDisks
+
+	if this.Once && this.JSONOutput {
+		return fmt.Errorf("The --once and --json flags can't be used together.\n")
+	}
+
+	if cli.DurationRuntime != "" {
+		if this.Once {
+			return fmt.Errorf("The --duration-runtime flag can't be used with --once, which already exits after one sample.\n")
+		}
+		runDuration, err := parseDurationFlag("duration-runtime", cli.DurationRuntime, time.Second)
+		if err != nil {
+			return err
+		}
+		if runDuration <= 0 {
+			return fmt.Errorf("You've set the runtime duration to %v, it must be greater than 0.\n", runDuration)
+		}
+		this.RunDuration = runDuration
+	}
+	this.NetInterfaces = cli.NetInterface
+	this.ExcludeInterfaces = cli.ExcludeInterface
+	this.NetSplitFamily = cli.NetSplitFamily
+	this.NetPackets = cli.NetPackets
+	this.NetErrors = cli.NetErrors
+
+	if cli.Record != "" && cli.Replay != "" {
+		return fmt.Errorf("The --record and --replay flags can't be used together.\n")
+	}
+	this.Cumulative = cli.Cumulative
+	this.PreciseAxis = cli.PreciseAxis
+	this.Bits = cli.Bits
+	this.SampleOnStart = cli.SampleOnStart
+	if this.Cumulative && this.Once {
+		return fmt.Errorf("The --cumulative flag only applies to charts and JSON output so can't be used with --once.\n")
+	}
+
+	if len(cli.FreezeOn) > 0 {
+		if this.JSONOutput || this.Once {
+			return fmt.Errorf("The --freeze-on flag only applies to charts so can't be used with JSON output or --once.\n")
+		}
+		thresholds, err := parseChartValues("freeze-on", cli.FreezeOn)
+		if err != nil {
+			return err
+		}
+		this.freezeOn = newFreezer(thresholds)
+	}
+
+	if cli.Stdin && (cli.Record != "" || cli.Replay != "") {
+		return fmt.Errorf("The --stdin flag charts the piped samples so can't be used with --record or --replay.\n")
+	}
+	if cli.Stdin && (this.JSONOutput || this.Once) {
+		return fmt.Errorf("The --stdin flag only applies to charts so can't be used with JSON output or --once.\n")
+	}
+	this.Stdin = cli.Stdin
+
+	if cli.Remote != "" && (cli.Stdin || cli.Record != "" || cli.Replay != "") {
+		return fmt.Errorf("The --remote flag charts the remote host so can't be used with --stdin, --record or --replay.\n")
+	}
+	if cli.Remote != "" && (this.JSONOutput || this.Once) {
+		return fmt.Errorf("The --remote flag only applies to charts so can't be used with JSON output or --once.\n")
+	}
+	this.RemoteHost = cli.Remote
+	this.RemoteCommand = cli.RemoteCommand
+
+	if (cli.Record != "" || cli.Replay != "") && (this.JSONOutput || this.Once) {
+		return fmt.Errorf("The --record and --replay flags only apply to charts so can't be used with JSON output or --once.\n")
+	}
+	if cli.Serve != "" && (this.JSONOutput || this.Once) {
+		return fmt.Errorf("The --serve flag serves the charts' samples so can't be used with JSON output or --once.\n")
+	}
+	this.ServeAddr = cli.Serve
+	this.PprofAddr = cli.Pprof
+	if cli.ReplaySpeed <= 0 {
+		return fmt.Errorf("You've set the replay speed to %v, it must be greater than 0.\n", cli.ReplaySpeed)
+	}
+	this.RecordPath = cli.Record
+	this.ReplayPath = cli.Replay
+	this.ReplaySpeed = cli.ReplaySpeed
+	if poll := this.pollInterval(); poll < minSampleInterval {
+		return fmt.Errorf("Replaying at %vx polls for samples every %v, this is likely to stress the system so we error out for values less than 20ms, use a lower --replay-speed.\n", this.ReplaySpeed, poll)
+	}
+
+	if err := this.applyAdaptiveFlags(); err != nil {
+		return err
+	}
+
+	widgetIntervals, err := parseWidgetIntervals(cli.WidgetInterval)
+	if err != nil {
+		return err
+	}
+	if len(widgetIntervals) > 0 && (cli.Replay != "" || cli.Stdin || cli.Remote != "") {
+		return fmt.Errorf("Samples are replayed at the interval they were recorded, so the --widget-interval flag can't be used with --replay, --stdin or --remote.\n")
+	}
+	for widget, interval := range widgetIntervals {
+		if interval > this.ChartDuration {
+			return fmt.Errorf("You've set the %s chart's interval to %v which is longer than the chart duration of %v, so there would be nothing to chart.\n", widgetNames[widget], interval, this.ChartDuration)
+		}
+	}
+	this.WidgetIntervals = widgetIntervals
+
+	if cli.CpuLoad {
+		this.selectWidget(WidgetCPULoad)
+	}
+
+	if cli.CpuPercent {
+		this.selectWidget(WidgetCPUPerc)
+	}
+
+	if cli.DiskIops {
+		this.selectWidget(WidgetDiskIOPS)
+	}
+
+	if cli.DiskIo {
+		this.selectWidget(WidgetDiskIO)
+	}
+
+	if cli.NetworkIo {
+		this.selectWidget(WidgetNetworkIO)
+	}
+
+	if cli.TopCpu {
+		this.selectWidget(WidgetTopCPU)
+	}
+
+	if cli.TopMemory {
+		this.selectWidget(WidgetTopMem)
+	}
+
+	if cli.Gpu {
+		this.selectWidget(WidgetGPU)
+	}
+
+	if cli.Connections {
+		this.selectWidget(WidgetConnections)
+	}
+	if cli.Switches {
+		this.selectWidget(WidgetSwitches)
+	}
+	if cli.DiskLatency {
+		this.selectWidget(WidgetDiskLatency)
+	}
+	if cli.DiskSpace {
+		this.selectWidget(WidgetDiskSpace)
+	}
+	if cli.FileLimits {
+		this.selectWidget(WidgetFileLimits)
+	}
+
+	if cli.HostInfo {
+		this.selectWidget(WidgetHostInfo)
+	}
+
+	if cli.Overview {
+		this.selectWidget(WidgetOverview)
+	}
+
+	if cli.TopDisk {
+		this.selectWidget(WidgetTopDisk)
+	}
+
+	if cli.TopFiles {
+		this.selectWidget(WidgetTopFiles)
+	}
+	if cli.TopNet {
+		this.selectWidget(WidgetTopNet)
+	}
+
+	return nil
+}
+
+// Parses a duration flag which may be either a duration string like "250ms" or "2m", or for
+// backwards compatibility a plain integer which is interpreted in the given unit.
+func parseDurationFlag(name, value string, unit time.Duration) (time.Duration, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't parse '%s' for the %s flag, use a duration like 250ms, 30s or 2m, or a plain number of %s.\n", value, name, strings.TrimPrefix(unit.String(), "1"))
+	}
+
+	return d, nil
+}
+
+// Parses a grid flag value like "2x3" into columns and rows
+func parseGrid(value string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(value), "x")
+	if len(parts) == 2 {
+		cols, colErr := strconv.Atoi(parts[0])
+		rows, rowErr := strconv.Atoi(parts[1])
+		if colErr == nil && rowErr == nil && cols > 0 && rows > 0 {
+			return cols, rows, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("Couldn't parse '%s' for the grid flag, use COLSxROWS with positive numbers, e.g. 2x3.\n", value)
+}
+
+// Parses max-y flag values like "C=100" into a map from widget to the max of its Y axis
+func parseMaxY(values []string) (map[int]float64, error) {
+	return parseChartValues("max-y", values)
+}
+
+// Parses color flag values like "recv=34" into a map from series name to color
+func parseSeriesColors(values []string) (map[string]cell.Color, error) {
+	colors := map[string]cell.Color{}
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) == 2 && seriesColorNames[parts[0]] {
+			color, err := strconv.Atoi(parts[1])
+			if err == nil && color >= 0 && color <= 255 {
+				colors[parts[0]] = cell.ColorNumber(color)
+				continue
+			}
+		}
+
+		return nil, fmt.Errorf("Couldn't parse '%s' for the color flag, use a series name and a color number from 0 to 255, e.g. recv=34. The series are %s.\n",
+			value, strings.Join(sortedSeriesColorNames(), ", "))
+	}
+
+	return colors, nil
+}
+
+// Parses log-scale flag values like "NE" into the set of widgets whose charts use a log scale
+func parseLogScale(values []string) (map[int]bool, error) {
+	logScale := map[int]bool{}
+
+	for _, value := range values {
+		for _, shortcode := range value {
+			if widget, ok := shortcodeToWidget[shortcode]; ok && isChartWidget(widget) {
+				logScale[widget] = true
+				continue
+			}
+
+			return nil, fmt.Errorf("Couldn't parse '%s' for the log-scale flag, '%c' isn't a chart's flag, use e.g. NE for the network and disk IO charts.\n", value, shortcode)
+		}
+	}
+
+	return logScale, nil
+}
+
+// The border line styles by their names in the border flag
+var borderStyles = map[string]linestyle.LineStyle{
+	"round":  linestyle.Round,
+	"light":  linestyle.Light,
+	"double": linestyle.Double,
+	"none":   linestyle.None,
+}
+
+// Parses border flag values like "double" or "T=none" into the style of every widget's border and
+// the styles of the widgets given their own, the last style without a widget wins
+func parseBorders(values []string) (linestyle.LineStyle, map[int]linestyle.LineStyle, error) {
+	border := linestyle.Round
+	borders := map[int]linestyle.LineStyle{}
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) == 1 {
+			if style, ok := borderStyles[strings.ToLower(parts[0])]; ok {
+				border = style
+				continue
+			}
+		} else if len([]rune(parts[0])) == 1 {
+			widget, okWidget := shortcodeToWidget[[]rune(parts[0])[0]]
+			style, okStyle := borderStyles[strings.ToLower(parts[1])]
+			if okWidget && okStyle {
+				borders[widget] = style
+				continue
+			}
+		}
+
+		return 0, nil, fmt.Errorf("Couldn't parse '%s' for the border flag, use one of round, light, double or none, optionally after a widget's flag, e.g. double or T=none.\n", value)
+	}
+
+	return border, borders, nil
+}
+
+// Records the IDs of titled containers nested inside a widget's container
+func (this *PoptopConfig) nestTitles(ids ...string) {
+	for _, id := range ids {
+		if find(this.nestedTitles, id) == -1 {
+			this.nestedTitles = append(this.nestedTitles, id)
+		}
+	}
+}
+
+// Returns the line style of the widget's border
+func (this *PoptopConfig) BorderStyle(widget int) linestyle.LineStyle {
+	if style, ok := this.Borders[widget]; ok {
+		return style
+	}
+	return this.Border
+}
+
+// Parses sort-top flag values like "T=mem" into a map from top list widget to its sort
+func parseTopSorts(values []string) (map[int]*topSort, error) {
+	sorts := map[int]*topSort{}
+	names := []string{}
+	for _, sort := range topSorts {
+		names = append(names, sort.name)
+	}
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) == 2 && len([]rune(parts[0])) == 1 {
+			widget := shortcodeToWidget[[]rune(parts[0])[0]]
+			i := find(names, strings.ToLower(parts[1]))
+
+			if (widget == WidgetTopCPU || widget == WidgetTopMem) && i != -1 {
+				sorts[widget] = topSorts[i]
+				continue
+			}
+		}
+
+		return nil, fmt.Errorf("Couldn't parse '%s' for the sort-top flag, use T or M for the top CPU or memory list and one of %s, e.g. T=mem.\n", value, strings.Join(names, ", "))
+	}
+
+	return sorts, nil
+}
+
+// Returns how the top list widget is sorted, which is by its own metric unless it's been changed
+func (this *PoptopConfig) TopSort(widget int) *topSort {
+	this.topSortsMu.Lock()
+	sort, ok := this.TopSorts[widget]
+	this.topSortsMu.Unlock()
+
+	if ok {
+		return sort
+	}
+	if widget == WidgetTopMem {
+		return sortByMem
+	}
+	return sortByCpu
+}
+
+// Swaps each top list between sorting by CPU and by memory, or only the focused list if one is
+func (this *PoptopConfig) SwapTopSorts() {
+	focused := this.FocusedWidget()
+	sorts := map[int]*topSort{}
+	for _, widget := range []int{WidgetTopCPU, WidgetTopMem} {
+		sorts[widget] = this.TopSort(widget)
+		if focused != WidgetTopCPU && focused != WidgetTopMem || focused == widget {
+			sorts[widget] = sortByCpu
+			if this.TopSort(widget) == sortByCpu {
+				sorts[widget] = sortByMem
+			}
+		}
+	}
+	this.topSortsMu.Lock()
+	defer this.topSortsMu.Unlock()
+	this.TopSorts = sorts
+}
+
+// Returns the widget which has the keyboard focus, or noWidget if none has or it's since been removed from the layout
+func (this *PoptopConfig) FocusedWidget() int {
+	focused := this.focused.Load()
+	if focused == nil || find(this.Widgets, focused.widget) == -1 {
+		return noWidget
+	}
+	return focused.widget
+}
+
+// Sets up adaptive sampling from the --adaptive, --adaptive-min and --adaptive-max flags
+func (this *PoptopConfig) applyAdaptiveFlags() error {
+	if !cli.Adaptive {
+		if cli.AdaptiveMin != "" || cli.AdaptiveMax != "" {
+			return fmt.Errorf("The --adaptive-min and --adaptive-max flags only apply with --adaptive.\n")
+		}
+		return nil
+	}
+	if cli.Replay != "" || cli.Stdin || cli.Remote != "" {
+		return fmt.Errorf("Samples are replayed at the interval they were recorded, so the --adaptive flag can't be used with --replay, --stdin or --remote.\n")
+	}
+	if this.JSONOutput || this.Once {
+		return fmt.Errorf("The --adaptive flag only applies to charts so can't be used with JSON output or --once.\n")
+	}
+
+	this.AdaptiveSampling = true
+	this.AdaptiveMin = this.SampleInterval
+	if cli.AdaptiveMin != "" {
+		interval, err := parseDurationFlag("adaptive-min", cli.AdaptiveMin, time.Millisecond)
+		if err != nil {
+			return err
+		}
+		if interval < minSampleInterval {
+			return fmt.Errorf("You've set the adaptive minimum to %v, this is likely to stress the system so we error out for values less than 20ms.\n", interval)
+		}
+		this.AdaptiveMin = interval
+	}
+
+	this.AdaptiveMax = this.AdaptiveMin * 8
+	if cli.AdaptiveMax != "" {
+		interval, err := parseDurationFlag("adaptive-max", cli.AdaptiveMax, time.Millisecond)
+		if err != nil {
+			return err
+		}
+		if interval < this.AdaptiveMin {
+			return fmt.Errorf("You've set the adaptive maximum to %v which is shorter than the minimum of %v.\n", interval, this.AdaptiveMin)
+		}
+		this.AdaptiveMax = interval
+	}
+	return nil
+}
+
+// Parses widget-interval flag values like "L=5s" into a map from widget to sample interval
+func parseWidgetIntervals(values []string) (map[int]time.Duration, error) {
+	intervals := map[int]time.Duration{}
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) == 2 && len([]rune(parts[0])) == 1 {
+			widget, ok := shortcodeToWidget[[]rune(parts[0])[0]]
+			interval, err := parseDurationFlag("widget-interval", parts[1], time.Millisecond)
+
+			if ok && isChartWidget(widget) && err == nil && interval >= minSampleInterval {
+				intervals[widget] = interval
+				continue
+			}
+		}
+
+		return nil, fmt.Errorf("Couldn't parse '%s' for the widget-interval flag, use a chart's flag and a duration of at least %v, e.g. L=5s or N=250ms.\n", value, minSampleInterval)
+	}
+
+	return intervals, nil
+}
+
+// Parses flag values like "C=100" which set a positive value for a chart into a map from widget to value
+func parseChartValues(flag string, values []string) (map[int]float64, error) {
+	chartValues := map[int]float64{}
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) == 2 && len([]rune(parts[0])) == 1 {
+			widget, ok := shortcodeToWidget[[]rune(parts[0])[0]]
+			n, err := strconv.ParseFloat(parts[1], 64)
+
+			if ok && isChartWidget(widget) && err == nil && n > 0 {
+				chartValues[widget] = n
+				continue
+			}
+		}
+
+		return nil, fmt.Errorf("Couldn't parse '%s' for the %s flag, use a chart's flag and a positive value, e.g. C=100 or N=5000.\n", value, flag)
+	}
+
+	return chartValues, nil
+}
+
+func DefaultConfig() *PoptopConfig {
+	return &PoptopConfig{
+		Widgets:           []int{WidgetCPULoad, WidgetCPUPerc, WidgetDiskIOPS, WidgetTopCPU},
+		SelectWidgetsMode: false,
+		TopRowsShown:      25,
+		Border:            linestyle.Round,
+	}
+}
+
+func (this *PoptopConfig) Finalize() {
+	// Calculate the number of samples we'll retain by dividing the chart duration by the sampling interval
+	this.NumSamples, this.SamplesPerPoint = capSamples(int(math.Ceil(float64(this.ChartDuration)/float64(this.SampleInterval))), this.MaxSamples)
+
+	this.sampleClock = newLiveInterval(this.pollInterval())
+	this.redrawClock = newLiveInterval(this.RedrawInterval)
+
+	// listing connections is slow so like the top lists they're sampled at a quarter of the rate, and
+	// disk space and file limits change slowly so are sampled at a fifth, unless they've been given
+	// an interval
+	intervals := map[int]time.Duration{
+		WidgetConnections: this.SampleInterval * 4,
+		WidgetDiskSpace:   this.SampleInterval * 5,
+		WidgetFileLimits:  this.SampleInterval * 5,
+	}
+	for widget, interval := range this.WidgetIntervals {
+		intervals[widget] = interval
+	}
+	this.samplings = map[int]*chartSampling{}
+	this.widgetCancels = map[int]context.CancelFunc{}
+	markerWindow := float64(this.NumSamples * max(1, this.SamplesPerPoint))
+	for widget, interval := range intervals {
+		numSamples, perPoint := capSamples(int(math.Ceil(float64(this.ChartDuration)/float64(interval))), this.MaxSamples)
+		factor := float64(interval) / float64(this.SampleInterval)
+		this.samplings[widget] = &chartSampling{this.sampleClock.Scaled(factor), numSamples, perPoint, this.replaySpeed()}
+		markerWindow = math.Max(markerWindow, float64(numSamples*perPoint)*factor)
+	}
+	this.markers.SetWindow(markerWindow)
+
+	this.topFilter = newProcessFilter()
+	this.sampleClock.SetPaused(this.StartPaused)
+}
+
+// Returns how many times faster than it was recorded the replay plays, 1 unless replaying
+func (this *PoptopConfig) replaySpeed() float64 {
+	if this.ReplayPath == "" || this.ReplaySpeed <= 0 {
+		return 1
+	}
+	return this.ReplaySpeed
+}
+
+// Returns how often the sample clock ticks to start with. Samples arrive faster when replaying at
+// speed so they're polled for more often, while the charts keep the spacing they were recorded at.
+func (this *PoptopConfig) pollInterval() time.Duration {
+	return time.Duration(float64(this.SampleInterval) / this.replaySpeed())
+}
+
+// Returns how many points to retain for a chart needing nSamples samples and how many samples to
+// average into each point so that we keep at most maxSamples points, or every sample if maxSamples is 0
+func capSamples(nSamples, maxSamples int) (int, int) {
+	if maxSamples <= 0 || nSamples <= maxSamples {
+		return nSamples, 1
+	}
+
+	perPoint := int(math.Ceil(float64(nSamples) / float64(maxSamples)))
+	return int(math.Ceil(float64(nSamples) / float64(perPoint))), perPoint
+}
+
+// Returns the time between the points charted, which is the sample interval unless several
+// samples are being averaged into each point
+func (this *PoptopConfig) PointInterval() time.Duration {
+	return this.CurrentSampleInterval() * time.Duration(max(1, this.SamplesPerPoint))
+}
+
+// How a chart is sampled, which is on the sample clock unless it has its own interval
+type chartSampling struct {
+	// the clock the chart samples on, which is paused, stepped and sped up or slowed down along
+	// with the sample clock
+	clock *liveInterval
+
+	// how many points the chart's series retain and how many samples are averaged into each
+	numSamples int
+	perPoint   int
+
+	// how many times faster than it was recorded the replay plays, so the clock ticks that many
+	// times faster than the charted points are apart
+	speed float64
+}
+
+// Returns the interval the chart is currently sampled at
+func (this *chartSampling) Interval() time.Duration {
+	interval, _ := this.clock.Get()
+	return interval
+}
+
+// Returns the time between the points charted, as they were recorded when replaying at speed
+func (this *chartSampling) PointInterval() time.Duration {
+	return time.Duration(float64(this.Interval()*time.Duration(max(1, this.perPoint))) * this.speed)
+}
+
+// Returns how the widget's chart is sampled, which is on the sample clock unless it has its own interval
+func (this *PoptopConfig) widgetSampling(widget int) *chartSampling {
+	if sampling, ok := this.samplings[widget]; ok {
+		return sampling
+	}
+	return &chartSampling{this.sampleClock, this.NumSamples, this.SamplesPerPoint, this.replaySpeed()}
+}
+
+// Estimates the memory used by the chart series for the enabled widgets, each series stores
+// twice as many values as the chart retains points to allow for smoothing
+func (this *PoptopConfig) SeriesMemory() int {
+	memory := 0
+	for _, widget := range this.Widgets {
+		nSeries := 0
+		switch widget {
+		case WidgetCPULoad, WidgetCPUPerc:
+			nSeries += 3
+		case WidgetNetworkIO:
+			if this.NetSplitFamily && len(this.NetInterfaces) == 0 {
+				nSeries += 4
+			} else {
+				nSeries += 2 * max(1, len(this.NetInterfaces))
+			}
+			if this.NetPackets || this.NetErrors {
+				// the packet chart keeps all four series whichever are shown
+				nSeries += 4 * max(1, len(this.NetInterfaces))
+			}
+		case WidgetDiskIOPS, WidgetDiskIO, WidgetGPU, WidgetSwitches, WidgetDiskLatency, WidgetDiskSpace, WidgetFileLimits:
+			nSeries += 2
+		case WidgetConnections:
+			nSeries += 3
+		case WidgetOverview:
+			nSeries += 7
+		}
+		memory += nSeries * this.widgetSampling(widget).numSamples * 2 * 8
+	}
+
+	return memory
+}
+
+// Returns the series' values smoothed as configured
+func (this *PoptopConfig) Smoothed(series *BoundedSeries) []float64 {
+	if this.SmoothMedian {
+		return series.SmoothedValuesMedian(this.Smoothing())
+	}
+	return series.SmoothedValues(this.Smoothing())
+}
+
+// Returns the sample interval in use, which may have been changed at runtime since the flags were applied
+func (this *PoptopConfig) CurrentSampleInterval() time.Duration {
+	if this.sampleClock == nil {
+		return this.SampleInterval
+	}
+	interval, _ := this.sampleClock.Get()
+	return interval
+}
+
+// Multiplies the sample interval by factor, keeping it between the minimum and maxLiveInterval.
+// Charts keep the same number of samples, so a longer interval charts a longer duration.
+func (this *PoptopConfig) ScaleSampleInterval(factor float64) {
+	interval, _ := this.sampleClock.Get()
+	this.sampleClock.Set(clampInterval(time.Duration(float64(interval)*factor), minSampleInterval))
+}
+
+// Multiplies the redraw interval by factor, keeping it between the minimum and maxLiveInterval
+func (this *PoptopConfig) ScaleRedrawInterval(factor float64) {
+	interval, _ := this.redrawClock.Get()
+	this.redrawClock.Set(clampInterval(time.Duration(float64(interval)*factor), minRedrawInterval))
+}
+
+func clampInterval(interval, min time.Duration) time.Duration {
+	if interval < min {
+		return min
+	}
+	if interval > maxLiveInterval {
+		return maxLiveInterval
+	}
+	return interval
+}
+
+// Changes how many samples are averaged into each charted point. Series retain twice the number of
+// visible samples, so a window up to the number of visible samples can always be fully averaged.
+func (this *PoptopConfig) AdjustSmoothing(delta int) {
+	atomic.StoreInt32(&this.SmoothingSamples, int32(max(1, min(this.NumSamples, this.Smoothing()+delta))))
+}
+
+// Returns how many samples are averaged into each charted point
+func (this *PoptopConfig) Smoothing() int {
+	return int(atomic.LoadInt32(&this.SmoothingSamples))
+}
+
+const rootID = "root"
+
+// Below roughly this many columns and rows per widget, charts lose their axis labels
+// or can't be drawn at all
+const (
+	minWidgetCols = 30
+	minWidgetRows = 8
+)
+
+// Returns how many of numWidgets can reasonably be drawn on a terminal of the given size,
+// which is either all of them, just the first, or none if even a single widget won't fit.
+func widgetsThatFit(size image.Point, numWidgets int) int {
+	if size.X < minWidgetCols || size.Y < minWidgetRows {
+		return 0
+	}
+
+	if size.X*size.Y < numWidgets*minWidgetCols*minWidgetRows {
+		return 1
+	}
+
+	return numWidgets
+}
+
+func newTooSmallBox(size image.Point) ([]container.Option, error) {
+	textBox, err := text.New(text.WrapAtWords())
+	if err != nil {
+		return nil, err
+	}
+
+	msg := fmt.Sprintf("Terminal too small (%dx%d), make it at least %dx%d to see charts.", size.X, size.Y, minWidgetCols, minWidgetRows)
+	textBox.Write(msg, text.WriteReplace())
+
+	return []container.Option{container.Border(linestyle.None), container.KeyFocusSkip(), container.PlaceWidget(textBox)}, nil
+}
+
+func applyLayout(ctx context.Context, terminal terminalapi.Terminal, rootContainer *container.Container, config *PoptopConfig, widgetCache map[int][]container.Option, legendRow *legend, bar *statusBar) {
+	w, err := getWidgets(ctx, rootContainer, config, widgetCache)
+	if err != nil {
+		panic(err)
+	}
+	defer config.markChanged()
+
+	// read before the relayout, drawing the recreated containers unfocused clears it
+	focused := config.focused.Load()
+
+	size := terminal.Size()
+	now := time.Now()
+	showBar := config.toast.Draw(now) != "" || config.StatusBar
+	if showBar {
+		size.Y -= statusBarRows
+	}
+	if config.Legend {
+		size.Y -= legendRows
+	}
+	w = w[:widgetsThatFit(size, len(w))]
+
+	// the cached options are shared, so borders are dropped from copies
+	if config.HideTitles.Load() {
+		for i := range w {
+			w[i] = append(append([]container.Option{}, w[i]...), container.Border(linestyle.None))
+		}
+	}
+
+	var gridOpts []container.Option
+	if len(w) > 0 {
+		gridOpts, err = layout(w, config)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// the legend and status bar sit below the widget grid rather than being among the widgets
+	if len(w) > 0 && (config.Legend || showBar) {
+		bottom := legendRow.container()
+		if showBar {
+			if _, err := bar.Update(config, now); err != nil {
+				panic(err)
+			}
+			bottom = bar.container()
+			if config.Legend {
+				bottom = []container.Option{container.SplitHorizontal(container.Top(legendRow.container()...), container.Bottom(bar.container()...),
+					container.SplitFixed(legendRows))}
+			}
+		}
+		gridOpts = []container.Option{container.SplitHorizontal(container.Top(gridOpts...), container.Bottom(bottom...),
+			container.SplitFixed(size.Y))}
+	}
+
+	// fall back to a message rather than crashing if the layout can't be drawn at this size
+	if len(w) == 0 || rootContainer.Update(rootID, gridOpts...) != nil {
+		tooSmall, err := newTooSmallBox(size)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := rootContainer.Update(rootID, tooSmall...); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	// containers nested inside a widget's are only reachable by ID, this fails for those of
+	// widgets which aren't part of the layout
+	if config.HideTitles.Load() {
+		for _, id := range config.nestedTitles {
+			rootContainer.Update(id, container.Border(linestyle.None))
+		}
+	}
+
+	// the relayout recreated the containers, dropping the focus, so it's put back on the focused
+	// widget's. This fails if the widget didn't fit.
+	if focused != nil && find(config.Widgets, focused.widget) != -1 {
+		rootContainer.Update(focused.id, container.Focused())
+	}
+}
+
+// Opens the terminal with the given backend, both of which draw in 256 colors and report the same
+// keyboard and mouse events, so the rest of poptop doesn't need to know which is in use
+func newTerminal(backend string) (terminalapi.Terminal, error) {
+	if backend == "tcell" {
+		return tcell.New(tcell.ColorMode(terminalapi.ColorMode256))
+	}
+	return termbox.New(termbox.ColorMode(terminalapi.ColorMode256))
+}
+
+func main() {
+	var err error
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := DefaultConfig()
+	kongCtx := kong.Parse(&cli, kong.Name("poptop"), kong.Description(description), kong.UsageOnError(), kong.NoDefaultHelp())
+
+	info, _ := debug.ReadBuildInfo()
+	fillBuildInfo(info)
+
+	if cli.Help {
+		kong.DefaultHelpPrinter(kong.HelpOptions{}, kongCtx)
+		fmt.Printf("\n\n%s\n\n%s", helpContent, getBuildInfo())
+		os.Exit(0)
+	}
+
+	if cli.Version {
+		fmt.Print(getBuildInfo())
+		os.Exit(0)
+	}
+
+	err = config.ApplyFlags()
+
+	if err != nil {
+		fmt.Print(err.Error())
+		os.Exit(1)
+	}
+
+	config.Finalize()
+
+	// cancelling rather than using a deadline means running out of time shuts down exactly as
+	// quitting does, with samplers seeing context.Canceled and the recording closed on the way out
+	if config.RunDuration > 0 {
+		timer := time.AfterFunc(config.RunDuration, cancel)
+		defer timer.Stop()
+	}
+
+	if config.ListInterfaces || config.ListDisks {
+		if config.ListInterfaces {
+			err = listInterfaces(ctx, config, os.Stdout)
+		}
+		if err == nil && config.ListDisks {
+			err = listDisks(ctx, os.Stdout)
+		}
+		if err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// if the terminal takes over the screen these are left behind once we exit, and shown briefly
+	// in the status bar too
+	warnings := missingTools(ctx, config)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	if config.PprofAddr != "" {
+		if err := config.servePprof(ctx, config.PprofAddr); err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if config.JSONOutput {
+		// there's no terminal to catch Ctrl-C for us so stop cleanly on interrupt
+		sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// the output stops once the context is cancelled, which is how it's meant to end
+		if err := runJSON(sigCtx, config, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.Once {
+		if err := runOnce(ctx, config, os.Stdout); err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.RecordPath != "" {
+		config.recorder, err = newSampleRecorder(config.RecordPath)
+		if err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+		defer config.recorder.Close()
+	}
+
+	if config.ReplayPath != "" {
+		config.replay, err = newReplaySource(config.ReplayPath, config.ReplaySpeed)
+		if err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if config.Stdin {
+		config.replay = newStreamSource()
+
+		// reading stdin blocks until there's more input, so rather than being one of the workers
+		// we wait for on the way out this is left to stop when we exit
+		go func() {
+			err := config.replay.Stream(os.Stdin, func(err error) {
+				config.toast.Show(strings.TrimSpace(err.Error()), time.Now())
+			})
+			if err != nil {
+				config.toast.Show(fmt.Sprintf("Could not read stdin: %v", err), time.Now())
+			} else {
+				config.toast.Show("Reached the end of stdin, the charts won't update any more", time.Now())
+			}
+		}()
+	}
+
+	if config.RemoteHost != "" {
+		name, args, err := remoteCommand(config)
+		if err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+
+		config.replay = newStreamSource()
+		config.spawn(func() { config.streamRemote(ctx, name, args) })
+	}
+
+	if config.ServeAddr != "" {
+		config.snapshot = newMetricsSnapshot()
+		if err := config.serveMetrics(ctx, config.ServeAddr); err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if config.AdaptiveSampling {
+		config.runAdaptiveSampling(ctx)
+	}
+
+	// the terminal is about to take over the screen, but this is left behind once we exit
+	if mem := config.SeriesMemory(); mem > seriesMemoryBudget {
+		fmt.Fprintf(os.Stderr, "Warning: charting %v at a %v sample interval will use around %d MiB, use --max-samples to cap it.\n",
+			config.ChartDuration, config.SampleInterval, mem/1024/1024)
+	}
+
+	// the terminal has to be asked before termdash takes over its input
+	if config.ThemeFromTerminal && detectLightBackground() {
+		config.Theme = find(themeNames(), "light")
+	}
+
+	screen, err := newTerminal(config.Backend)
+	if err != nil {
+		panic(err)
+	}
+	terminal := newThemedTerminal(screen, config.Theme)
+	config.terminal = terminal
+	defer config.restoreTerminalOnPanic()
+
+	rootContainer, err := container.New(terminal, container.ID(rootID), container.KeyFocusNext(keyboard.KeyTab))
+	if err != nil {
+		panic(err)
+	}
+
+	widgetCache := newWidgetCache()
+
+	bar, err := newStatusBar()
+	if err != nil {
+		panic(err)
+	}
+	legendRow, err := newLegend(config)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(warnings) > 0 {
+		config.toast.Show(strings.Join(warnings, " "), time.Now())
+	}
+	applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+	helpOverlay, err := newHelpOverlay(config)
+	if err != nil {
+		panic(err)
+	}
+	showingHelpOverlay := false
+
+	// the layout is changed both by key presses and terminal resizes, so guard it
+	var layoutMu sync.Mutex
+
+	// the widget moved by < and > when none is focused, the most recently added or otherwise the
+	// last one in the layout
+	lastAdded := -1
+
+	// re-apply the layout when the terminal is resized in case widgets need to be
+	// hidden or shown to fit the new size
+	lastSize := terminal.Size()
+	config.spawn(func() {
+		err := periodicLive(ctx, config.redrawClock, func() error {
+			layoutMu.Lock()
+			defer layoutMu.Unlock()
+
+			// the toast also goes away by re-applying the layout once it expires
+			if size := terminal.Size(); (size != lastSize || config.toast.Stale(time.Now())) && !showingHelpOverlay {
+				lastSize = size
+				applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+			}
+
+			// settings changed by hotkeys show up here, the bar is always written even if it isn't shown
+			changed, err := bar.Update(config, time.Now())
+			if changed {
+				config.markChanged()
+			}
+			return err
+		})
+		if err != nil {
+			panic(err)
+		}
+	})
 
 	keyHandler := func(k *terminalapi.Keyboard) {
-		if k.Key == keyboard.KeyEsc || k.Key == keyboard.KeyCtrlC || k.Key == 'q' {
-			cancel()
-			terminal.Close()
+		layoutMu.Lock()
+		defer layoutMu.Unlock()
+
+		// while the help overlay is shown we swallow every key other than those that close it or quit
+		if showingHelpOverlay {
+			if k.Key == '?' || k.Key == keyboard.KeyEsc {
+				showingHelpOverlay = false
+				applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+			} else if k.Key == keyboard.KeyCtrlC || k.Key == 'q' {
+				cancel()
+			}
+			return
+		}
+
+		// while typing a filter we capture every key other than Ctrl-C
+		if filter, typing, _ := config.topFilter.Get(); typing && k.Key != keyboard.KeyCtrlC {
+			switch {
+			case k.Key == keyboard.KeyEsc:
+				config.topFilter.Set("", false)
+			case k.Key == keyboard.KeyEnter:
+				config.topFilter.Set(filter, false)
+			case k.Key == keyboard.KeyBackspace || k.Key == keyboard.KeyBackspace2:
+				if runes := []rune(filter); len(runes) > 0 {
+					config.topFilter.Set(string(runes[:len(runes)-1]), true)
+				}
+			case k.Key > 0 && unicode.IsPrint(rune(k.Key)):
+				config.topFilter.Set(filter+string(rune(k.Key)), true)
+			}
+			return
+		}
+
+		// Esc clears the filter if there is one rather than quitting, and then the focus
+		if filter, _, _ := config.topFilter.Get(); k.Key == keyboard.KeyEsc && filter != "" {
+			config.topFilter.Set("", false)
+			return
 		}
+		if k.Key == keyboard.KeyEsc && config.FocusedWidget() != noWidget {
+			config.focused.Store(nil)
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+			return
+		}
+
+		action, widgetRef := keyToAction(k.Key)
+		switch action {
+		case actionQuit:
+			cancel()
 
-		// if the key is a layout-related flag then we want to manipulate the layout
-		if widgetRef, ok := shortcodeToWidget[rune(k.Key)]; ok {
+		case actionUnknown:
+			// rather than silently ignoring a key which does nothing, point out the ones which do
+			config.toast.Show(unknownKeyText(rune(k.Key)), time.Now())
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+		case actionFilter:
+			filter, _, _ := config.topFilter.Get()
+			config.topFilter.Set(filter, true)
+
+		case actionHelpOverlay:
+			showingHelpOverlay = true
+			if err := rootContainer.Update(rootID, helpOverlay...); err != nil {
+				panic(err)
+			}
+
+		case actionToggleWidget:
 			index := find(config.Widgets, widgetRef)
 
 			// if the widget is being displayed then hide it, otherwise add it
@@ -526,43 +2539,428 @@ func main() {
 				}
 			} else {
 				config.Widgets = append(config.Widgets, widgetRef)
+				lastAdded = widgetRef
 			}
 
 			// we've edited the layout, now apply it
-			applyLayout(ctx, rootContainer, config, widgetCache)
-		}
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+		case actionMoveEarlier, actionMoveLater:
+			// the widget cache keeps each widget's series so moving it doesn't lose any data
+			widget := config.FocusedWidget()
+			if widget == noWidget {
+				widget = lastAdded
+			}
+			if find(config.Widgets, widget) == -1 {
+				widget = config.Widgets[len(config.Widgets)-1]
+			}
+
+			delta := 1
+			if action == actionMoveEarlier {
+				delta = -1
+			}
+			if moveWidget(config.Widgets, widget, delta) {
+				config.toast.Show(fmt.Sprintf("Moved %s to position %d of %d", widgetNames[widget],
+					find(config.Widgets, widget)+1, len(config.Widgets)), time.Now())
+				applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+			}
 
-		if k.Key == 'z' {
+		case actionFocusNext:
+			// the root container moves the focus itself, see KeyFocusNext
+
+		case actionSplit:
 			config.SplitHorizontally = !config.SplitHorizontally
-			applyLayout(ctx, rootContainer, config, widgetCache)
-		}
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
 
-		if k.Key == 'w' {
+		case actionTile:
 			config.TileWindows = !config.TileWindows
-			applyLayout(ctx, rootContainer, config, widgetCache)
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+		case actionLegend:
+			config.Legend = !config.Legend
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+		case actionTitles:
+			config.HideTitles.Store(!config.HideTitles.Load())
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+		// the top lists pick this up when they next refresh
+		case actionGroup:
+			config.GroupProcesses.Store(!config.GroupProcesses.Load())
+
+		case actionSortTop:
+			config.SwapTopSorts()
+
+		// charts pick up the new smoothing when they next sample
+		case actionSmoothMore:
+			config.AdjustSmoothing(1)
+
+		case actionSmoothLess:
+			config.AdjustSmoothing(-1)
+
+		case actionSampleFaster, actionSampleSlower:
+			if config.AdaptiveSampling {
+				config.toast.Show("--adaptive sets the sample interval, so [ and ] only last until the next sample", time.Now())
+				applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+			}
+			if action == actionSampleFaster {
+				config.ScaleSampleInterval(0.5)
+			} else {
+				config.ScaleSampleInterval(2)
+			}
+
+		case actionRedrawFaster:
+			config.ScaleRedrawInterval(0.5)
+
+		case actionRedrawSlower:
+			config.ScaleRedrawInterval(2)
+
+		case actionPause:
+			config.SetPaused(!config.Paused())
+
+		case actionStep:
+			config.sampleClock.Step()
+
+		case actionClear:
+			config.chartSeries.Reset()
+			config.markers.Reset()
+			config.toast.Show("Cleared the chart history", time.Now())
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+			// charts only redraw when they sample, so while paused take a sample to show the cleared charts
+			if config.Paused() {
+				config.sampleClock.Step()
+			}
+
+		// every cell is drawn in the new theme's colors from the next redraw
+		case actionTheme:
+			theme := terminal.NextTheme()
+			config.toast.Show(fmt.Sprintf("Theme: %s", theme.name), time.Now())
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+		case actionSave:
+			if path, err := config.chartSeries.Save(time.Now()); err != nil {
+				config.toast.Show(fmt.Sprintf("Couldn't save the chart data: %v", err), time.Now())
+			} else {
+				config.toast.Show(fmt.Sprintf("Saved the chart data to %s", path), time.Now())
+			}
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
+
+		// the charts pick the marker up as they next sample
+		case actionMark:
+			now := time.Now()
+			label := config.markers.Add(config.sampleClock.Ticks())
+			config.toast.Show(fmt.Sprintf("Marked %s on the charts as %s", now.Format(clockLabelFormat), label), now)
+			applyLayout(ctx, terminal, rootContainer, config, widgetCache, legendRow, bar)
 		}
 	}
 
-	err = termdash.Run(ctx, terminal, rootContainer, termdash.KeyboardSubscriber(keyHandler), termdash.RedrawInterval(config.RedrawInterval))
+	// we redraw ourselves rather than using termdash.Run so the redraw interval can change at runtime
+	controller, err := termdash.NewController(terminal, rootContainer, termdash.KeyboardSubscriber(keyHandler))
+	if err != nil {
+		panic(err)
+	}
+
+	config.markChanged()
+	err = periodicLive(ctx, config.redrawClock, func() error {
+		return config.redrawIfChanged(controller.Redraw)
+	})
 	if err != nil {
 		panic(err)
 	}
+
+	// only close the terminal once we've stopped redrawing to it and the samplers are no longer
+	// writing to widgets, otherwise quitting mid-sample can panic
+	controller.Close()
+	config.waitForWorkers(shutdownTimeout)
+	config.closeTerminal()
+}
+
+// Closes the terminal if it's open, returning it to how it was before poptop started
+func (this *PoptopConfig) closeTerminal() {
+	this.terminalClose.Do(func() {
+		if this.terminal != nil {
+			this.terminal.Close()
+		}
+	})
+}
+
+// Deferred to close the terminal on a panic before carrying on panicking, otherwise the trace is
+// printed to the alternate screen and the shell is left with mouse reporting on
+func (this *PoptopConfig) restoreTerminalOnPanic() {
+	if r := recover(); r != nil {
+		this.closeTerminal()
+		panic(r)
+	}
+}
+
+// Runs fn in a goroutine tracked by the config's workers, fn should return once the context is done
+func (this *PoptopConfig) spawn(fn func()) {
+	this.workers.Add(1)
+	go func() {
+		defer this.workers.Done()
+		defer this.restoreTerminalOnPanic()
+		fn()
+	}()
+}
+
+// Starts periodic in a goroutine tracked by the config's workers, noting that the screen needs
+// redrawing each time fn runs as it's expected to update a widget. The interval doesn't pause
+// along with sampling, so while sampling's paused fn runs without marking anything changed.
+// Errors from fn go to onError, which shows them on the widget, and fn runs again next time.
+func (this *PoptopConfig) goPeriodic(ctx context.Context, interval time.Duration, onError func(error), fn func() error) {
+	fn = reportingErrors(onError, fn)
+	marking := this.markingChanged(fn)
+	this.spawn(func() {
+		periodic(ctx, interval, func() error {
+			if this.Paused() {
+				return fn()
+			}
+			return marking()
+		})
+	})
+}
+
+// Starts periodicLive in a goroutine tracked by the config's workers, noting that the screen needs
+// redrawing each time fn runs as it's expected to update a widget. Errors from fn go to onError,
+// which shows them on the widget, and fn runs again on the next tick.
+func (this *PoptopConfig) goPeriodicLive(ctx context.Context, interval *liveInterval, onError func(error), fn func() error) {
+	fn = reportingErrors(onError, fn)
+	this.spawn(func() { periodicLive(ctx, interval, this.markingChanged(fn)) })
+}
+
+// Returns fn wrapped to pass its errors to onError rather than returning them, so a failed sample
+// doesn't stop the loop running it. A cancelled context is still returned as there's nothing to show.
+func reportingErrors(onError func(error), fn func() error) func() error {
+	return func() error {
+		err := fn()
+		if err == nil || errors.Is(err, context.Canceled) {
+			return err
+		}
+		onError(err)
+		return nil
+	}
+}
+
+// Shows an error from a widget without anywhere of its own to show it in the status bar's toast
+func (this *PoptopConfig) toastError(err error) {
+	this.toast.Show(strings.TrimSpace(err.Error()), time.Now())
+}
+
+// Returns fn wrapped to mark the screen changed once it's run
+func (this *PoptopConfig) markingChanged(fn func() error) func() error {
+	return func() error {
+		defer this.markChanged()
+		return fn()
+	}
+}
+
+// Notes that a widget has changed since the screen was last drawn. Call this after changing it,
+// so a redraw which has already started marks it again.
+func (this *PoptopConfig) markChanged() {
+	atomic.StoreInt32(&this.changed, 1)
+}
+
+// Calls redraw if a widget has changed since the last call. Widgets only change when they're
+// sampled, so this skips the draws between samples when redrawing faster than sampling, and every
+// draw while paused. termdash redraws by itself on key presses and mouse events.
+func (this *PoptopConfig) redrawIfChanged(redraw func() error) error {
+	if !atomic.CompareAndSwapInt32(&this.changed, 1, 0) {
+		return nil
+	}
+	return redraw()
+}
+
+// Waits for every goroutine started with spawn to return, giving up after timeout so a stuck
+// sample can't stop us quitting. Returns false if we timed out.
+func (this *PoptopConfig) waitForWorkers(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		this.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 // periodic executes the provided closure periodically every interval.
-// Exits when the context expires.
-func periodic(ctx context.Context, interval time.Duration, fn func() error) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// Exits when the context expires or the closure returns an error, see periodicLive.
+func periodic(ctx context.Context, interval time.Duration, fn func() error) error {
+	return periodicLive(ctx, newLiveInterval(interval), fn)
+}
+
+// An interval which can be changed or paused while periodicLive loops are running on it
+type liveInterval struct {
+	mu       sync.Mutex
+	interval time.Duration
+	paused   bool
+	changed  chan struct{} // closed and replaced each time the interval changes or is paused or resumed
+	stepped  chan struct{} // closed and replaced each time a single tick is stepped through while paused
+
+	// the ticks up to since, when the interval was last changed, paused or resumed, see Ticks
+	ticks float64
+	since time.Time
+
+	// set for an interval which is a multiple of another, see Scaled
+	parent *liveInterval
+	factor float64
+}
+
+func newLiveInterval(interval time.Duration) *liveInterval {
+	return &liveInterval{interval: interval, changed: make(chan struct{}), stepped: make(chan struct{}), since: time.Now()}
+}
+
+// Returns an interval which is factor times this one and follows it as it's changed, paused,
+// resumed and stepped. Those changes are made to this interval rather than the scaled one.
+func (this *liveInterval) Scaled(factor float64) *liveInterval {
+	return &liveInterval{parent: this, factor: factor}
+}
+
+// Returns the current interval and a channel which is closed when it next changes
+func (this *liveInterval) Get() (time.Duration, <-chan struct{}) {
+	if this.parent != nil {
+		interval, changed := this.parent.Get()
+		return time.Duration(float64(interval) * this.factor), changed
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.interval, this.changed
+}
+
+func (this *liveInterval) Set(interval time.Duration) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if interval == this.interval {
+		return
+	}
+	this.ticks, this.since = this.ticksAt(time.Now()), time.Now()
+	this.interval = interval
+	close(this.changed)
+	this.changed = make(chan struct{})
+}
+
+func (this *liveInterval) Paused() bool {
+	paused, _ := this.pauseState()
+	return paused
+}
+
+// Returns whether the interval is paused and a channel which is closed when it's next stepped
+func (this *liveInterval) pauseState() (bool, <-chan struct{}) {
+	if this.parent != nil {
+		return this.parent.pauseState()
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.paused, this.stepped
+}
+
+// Runs each periodicLive loop on this interval once if it's paused, otherwise does nothing
+func (this *liveInterval) Step() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if !this.paused {
+		return
+	}
+	this.ticks++
+	close(this.stepped)
+	this.stepped = make(chan struct{})
+}
+
+// Stops periodicLive loops on this interval from running until they're resumed
+func (this *liveInterval) SetPaused(paused bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if paused == this.paused {
+		return
+	}
+	this.ticks, this.since = this.ticksAt(time.Now()), time.Now()
+	this.paused = paused
+	close(this.changed)
+	this.changed = make(chan struct{})
+}
+
+// Returns roughly how many times the interval has ticked, counting steps and not the time spent
+// paused, so it numbers samples taken on it. Fractions are part way to the next tick.
+func (this *liveInterval) Ticks() float64 {
+	if this.parent != nil {
+		return this.scaleTicks(this.parent.Ticks())
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.ticksAt(time.Now())
+}
+
+// Converts ticks of the interval this is scaled from to ticks of this one
+func (this *liveInterval) scaleTicks(ticks float64) float64 {
+	if this.parent == nil {
+		return ticks
+	}
+	return this.parent.scaleTicks(ticks) / this.factor
+}
+
+func (this *liveInterval) ticksAt(now time.Time) float64 {
+	if this.paused || this.interval <= 0 {
+		return this.ticks
+	}
+	return this.ticks + float64(now.Sub(this.since))/float64(this.interval)
+}
+
+// periodicLive executes the provided closure periodically, recreating its ticker whenever
+// the interval changes. While it's paused the closure only runs when the interval is stepped.
+// Exits when the context expires, or returns the closure's error if it fails with anything but
+// context.Canceled. goPeriodicLive shows widgets' errors instead so their loops carry on.
+func periodicLive(ctx context.Context, interval *liveInterval, fn func() error) error {
+	run := func() error {
+		err := fn()
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
 	for {
-		select {
-		case <-ticker.C:
-			err := fn()
-			if err != nil && !errors.Is(err, context.Canceled) {
-				panic(err)
+		d, changed := interval.Get()
+		if paused, stepped := interval.pauseState(); paused {
+			select {
+			case <-stepped:
+				if err := run(); err != nil {
+					return err
+				}
+			case <-changed:
+			case <-ctx.Done():
+				return nil
 			}
-		case <-ctx.Done():
-			return
+			continue
 		}
+		ticker := time.NewTicker(d)
+
+	loop:
+		for {
+			select {
+			case <-ticker.C:
+				if err := run(); err != nil {
+					ticker.Stop()
+					return err
+				}
+			case <-changed:
+				break loop
+			case <-ctx.Done():
+				ticker.Stop()
+				return nil
+			}
+		}
+
+		ticker.Stop()
 	}
 }
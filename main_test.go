@@ -0,0 +1,717 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/private/canvas"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+	"github.com/mum4k/termdash/widgetapi"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+func TestWaitForWorkers(t *testing.T) {
+	config := &PoptopConfig{}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	var running int32
+	sample := func() error {
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		// simulate a slow sample that's still going when we quit
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+
+	config.goPeriodic(ctx, time.Millisecond, func(error) {}, sample)
+	config.goPeriodicLive(ctx, newLiveInterval(5*time.Millisecond), func(error) {}, sample)
+	config.spawn(func() { <-ctx.Done() })
+
+	if !config.waitForWorkers(time.Second) {
+		t.Fatal("Expected every worker to return after the context expired")
+	}
+	if n := atomic.LoadInt32(&running); n != 0 {
+		t.Errorf("Expected no samples running after the workers returned but got %d", n)
+	}
+
+	// a worker that never returns shouldn't stop us quitting
+	stuck := make(chan struct{})
+	defer close(stuck)
+	config.spawn(func() { <-stuck })
+
+	if config.waitForWorkers(10 * time.Millisecond) {
+		t.Error("Expected to time out waiting for a stuck worker")
+	}
+}
+
+func TestRedrawIfChanged(t *testing.T) {
+	config := &PoptopConfig{}
+	draws := 0
+	redraw := func() error {
+		draws++
+		return nil
+	}
+
+	config.redrawIfChanged(redraw)
+	if draws != 0 {
+		t.Error("Expected no redraw before anything changed")
+	}
+
+	config.markChanged()
+	config.redrawIfChanged(redraw)
+	config.redrawIfChanged(redraw)
+	if draws != 1 {
+		t.Errorf("Expected one redraw per change but got %d", draws)
+	}
+
+	// samplers mark the screen changed as they run
+	ctx, cancel := context.WithCancel(context.Background())
+	config.goPeriodic(ctx, time.Millisecond, func(error) {}, func() error {
+		cancel()
+		return nil
+	})
+	config.waitForWorkers(time.Second)
+	config.redrawIfChanged(redraw)
+	if draws != 2 {
+		t.Errorf("Expected a sample to trigger a redraw but got %d redraws", draws)
+	}
+
+	// samplers on a fixed interval, like the top lists, keep running while paused but nothing's redrawn
+	config.sampleClock = newLiveInterval(time.Second)
+	config.SetPaused(true)
+	ctx, cancel = context.WithCancel(context.Background())
+	config.goPeriodic(ctx, time.Millisecond, func(error) {}, func() error {
+		cancel()
+		return nil
+	})
+	config.waitForWorkers(time.Second)
+	config.redrawIfChanged(redraw)
+	if draws != 2 {
+		t.Errorf("Expected no redraw while paused but got %d redraws", draws)
+	}
+}
+
+func TestPeriodicErrors(t *testing.T) {
+	failure := errors.New("Couldn't sample.\n")
+
+	// on its own periodicLive stops at the first error and returns it
+	err := periodicLive(context.Background(), newLiveInterval(time.Millisecond), func() error {
+		return failure
+	})
+	if err != failure {
+		t.Errorf("Expected periodicLive to return the sample's error but got %v", err)
+	}
+
+	// a widget's loop reports each error and keeps sampling
+	config := &PoptopConfig{}
+	ctx, cancel := context.WithCancel(context.Background())
+	var reported, calls int32
+	config.goPeriodicLive(ctx, newLiveInterval(time.Millisecond), func(err error) {
+		if err == failure {
+			atomic.AddInt32(&reported, 1)
+		}
+	}, func() error {
+		if atomic.AddInt32(&calls, 1) == 3 {
+			cancel()
+		}
+		return failure
+	})
+	if !config.waitForWorkers(time.Second) {
+		t.Fatal("Expected the sampler to return after the context expired")
+	}
+	if n := atomic.LoadInt32(&reported); n < 3 {
+		t.Errorf("Expected every failed sample to be reported but got %d reports", n)
+	}
+}
+
+func TestCapSamples(t *testing.T) {
+	cases := []struct {
+		nSamples, maxSamples   int
+		expectedN, expectedPer int
+	}{
+		{240, 0, 240, 1},
+		{240, 500, 240, 1},
+		{240, 240, 240, 1},
+		// -d 3600 -s 50
+		{72000, 1000, 1000, 72},
+		{1001, 1000, 501, 2},
+	}
+
+	for _, c := range cases {
+		n, perPoint := capSamples(c.nSamples, c.maxSamples)
+		if n != c.expectedN || perPoint != c.expectedPer {
+			t.Errorf("Expected capSamples(%d, %d) to be %d points of %d but got %d of %d",
+				c.nSamples, c.maxSamples, c.expectedN, c.expectedPer, n, perPoint)
+		}
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := commandWithContext(context.Background(), 50*time.Millisecond, "sleep", "5")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the command to be killed after the timeout but it took %v", elapsed)
+	}
+
+	// shutting down still looks like a cancellation rather than a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := commandWithContext(ctx, time.Second, "sleep", "5"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled but got %v", err)
+	}
+
+	if out, err := commandWithContext(context.Background(), time.Second, "echo", "hi"); err != nil || string(out) != "hi\n" {
+		t.Errorf("Expected the command to finish within the timeout but got %q, %v", out, err)
+	}
+}
+
+func TestKeyToAction(t *testing.T) {
+	cases := []struct {
+		key    keyboard.Key
+		action keyAction
+		widget int
+	}{
+		{'C', actionToggleWidget, WidgetCPUPerc},
+		{'h', actionToggleWidget, WidgetHelp},
+		{'q', actionQuit, 0},
+		{keyboard.KeyEsc, actionQuit, 0},
+		{keyboard.KeyCtrlC, actionQuit, 0},
+		{'?', actionHelpOverlay, 0},
+		{'z', actionSplit, 0},
+		{'/', actionFilter, 0},
+		{'<', actionMoveEarlier, 0},
+		{'}', actionRedrawSlower, 0},
+
+		// still mapped under --adaptive, which overrides the interval they set at the next sample
+		{'[', actionSampleFaster, 0},
+		{']', actionSampleSlower, 0},
+
+		{'.', actionStep, 0},
+		{'b', actionTitles, 0},
+		{'x', actionUnknown, 0},
+		{keyboard.KeyTab, actionFocusNext, 0},
+
+		// special keys are left to the focused widget, even where their values look like runes
+		{keyboard.KeyArrowUp, actionNone, 0},
+		{keyboard.KeyArrowDown, actionNone, 0},
+		{keyboard.KeyPgDn, actionNone, 0},
+		{keyboard.KeyF1, actionNone, 0},
+		{keyboard.KeyF12, actionNone, 0},
+		{keyboard.KeyEnter, actionNone, 0},
+		{keyboard.KeyBackspace2, actionNone, 0},
+	}
+
+	for _, c := range cases {
+		action, widget := keyToAction(c.key)
+		if action != c.action || widget != c.widget {
+			t.Errorf("Expected %v to map to action %d and widget %d but got %d and %d", c.key, c.action, c.widget, action, widget)
+		}
+	}
+
+	text := unknownKeyText('x')
+	if !strings.HasPrefix(text, "'x' isn't a hotkey") || !strings.Contains(text, " C ") || !strings.Contains(text, " z ") {
+		t.Errorf("Expected the toast to list the valid keys but got %q", text)
+	}
+}
+
+func TestParseLogScale(t *testing.T) {
+	widgets, err := parseLogScale([]string{"NE", "D"})
+	if err != nil || len(widgets) != 3 || !widgets[WidgetNetworkIO] || !widgets[WidgetDiskIO] || !widgets[WidgetDiskIOPS] {
+		t.Errorf("Expected the network and disk charts but got %v, %v", widgets, err)
+	}
+	if _, err := parseLogScale([]string{"T"}); err == nil {
+		t.Error("Expected an error for a widget which isn't a chart")
+	}
+	if _, err := parseLogScale([]string{"Z"}); err == nil {
+		t.Error("Expected an error for a letter which isn't a widget's flag")
+	}
+}
+
+func TestEqualSplits(t *testing.T) {
+	expected := map[int][]int{
+		3: {33, 50},
+		4: {50, 50, 50},
+		5: {40, 50, 33, 50},
+		6: {50, 33, 50, 33, 50},
+		7: {42, 33, 50, 50, 50, 50},
+	}
+
+	for n, percs := range expected {
+		// walk the splits depth first, noting each split's percentage and each widget's share of the area
+		got := []int{}
+		areas := map[int]float64{}
+		var walk func(node *equalSplit, area float64)
+		walk = func(node *equalSplit, area float64) {
+			if node.a == nil {
+				areas[node.first] = area
+				return
+			}
+			got = append(got, node.perc)
+			walk(node.a, area*float64(node.perc)/100)
+			walk(node.b, area*float64(100-node.perc)/100)
+		}
+		walk(equalSplits(0, n), 100)
+
+		if len(got) != len(percs) {
+			t.Fatalf("Expected %d splits for %d widgets but got %v", len(percs), n, got)
+		}
+		for i := range percs {
+			if got[i] != percs[i] {
+				t.Errorf("Expected split percentages %v for %d widgets but got %v", percs, n, got)
+				break
+			}
+		}
+
+		if len(areas) != n {
+			t.Errorf("Expected every one of %d widgets to be placed but got %v", n, areas)
+		}
+		for widget, area := range areas {
+			if want := 100 / float64(n); area < want-2 || area > want+2 {
+				t.Errorf("Expected widget %d of %d to get about %.1f%% of the area but got %.1f%%", widget, n, want, area)
+			}
+		}
+	}
+}
+
+// A terminal which keeps what's drawn on it, to check where widgets were laid out
+type screenTerminal struct {
+	size  image.Point
+	cells [][]rune
+}
+
+func newScreenTerminal(size image.Point) *screenTerminal {
+	this := &screenTerminal{size: size}
+	this.Clear()
+	return this
+}
+
+func (this *screenTerminal) Size() image.Point     { return this.size }
+func (this *screenTerminal) Flush() error          { return nil }
+func (this *screenTerminal) SetCursor(image.Point) {}
+func (this *screenTerminal) HideCursor()           {}
+func (this *screenTerminal) Close()                {}
+func (this *screenTerminal) Event(ctx context.Context) terminalapi.Event {
+	<-ctx.Done()
+	return nil
+}
+
+func (this *screenTerminal) Clear(opts ...cell.Option) error {
+	this.cells = make([][]rune, this.size.Y)
+	for y := range this.cells {
+		this.cells[y] = []rune(strings.Repeat(" ", this.size.X))
+	}
+	return nil
+}
+
+func (this *screenTerminal) SetCell(p image.Point, r rune, opts ...cell.Option) error {
+	this.cells[p.Y][p.X] = r
+	return nil
+}
+
+// Returns the screen's rows, one per line
+func (this *screenTerminal) String() string {
+	rows := []string{}
+	for _, row := range this.cells {
+		rows = append(rows, string(row))
+	}
+	return strings.Join(rows, "\n")
+}
+
+// A text widget which notes the area it was last drawn in
+type areaWidget struct {
+	*text.Text
+	area image.Rectangle
+}
+
+func (this *areaWidget) Draw(cvs *canvas.Canvas, meta *widgetapi.Meta) error {
+	this.area = cvs.Area()
+	return this.Text.Draw(cvs, meta)
+}
+
+func TestLayoutBigPanes(t *testing.T) {
+	cases := []struct {
+		bigPaneFirst bool
+		tile         bool
+		big          int // the widget given the larger pane
+	}{
+		{false, false, 2},
+		{true, false, 0},
+		{false, true, 2},
+		{true, true, 0},
+	}
+
+	for _, c := range cases {
+		config := &PoptopConfig{BigPaneFirst: c.bigPaneFirst, TileWindows: c.tile}
+		drawn := []*areaWidget{}
+		widgets := Widgets{}
+		for i := 0; i < 3; i++ {
+			inner, err := text.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			inner.Write(fmt.Sprintf("widget%d", i))
+			drawn = append(drawn, &areaWidget{Text: inner})
+			widgets = append(widgets, []container.Option{container.PlaceWidget(drawn[i])})
+		}
+
+		opts, err := layout(widgets, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		terminal := newScreenTerminal(image.Point{80, 40})
+		root, err := container.New(terminal, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := root.Draw(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, widget := range drawn {
+			size := widget.area.Dx() * widget.area.Dy()
+			if big := drawn[c.big].area.Dx() * drawn[c.big].area.Dy(); i != c.big && size >= big {
+				t.Errorf("Expected widget %d to get the larger pane with big panes first %v and tiling %v but got %v and %v",
+					c.big, c.bigPaneFirst, c.tile, drawn[c.big].area, widget.area)
+			}
+
+			// the widgets still read in order, left to right and top to bottom
+			screen := terminal.String()
+			if i > 0 && strings.Index(screen, fmt.Sprintf("widget%d", i)) < strings.Index(screen, fmt.Sprintf("widget%d", i-1)) {
+				t.Errorf("Expected widget %d to follow widget %d with big panes first %v and tiling %v but got\n%s", i, i-1, c.bigPaneFirst, c.tile, screen)
+			}
+		}
+	}
+}
+
+func TestMoveWidget(t *testing.T) {
+	widgets := []int{WidgetCPULoad, WidgetCPUPerc, WidgetNetworkIO, WidgetTopCPU}
+
+	if !moveWidget(widgets, WidgetTopCPU, -2) {
+		t.Fatal("Expected the widget to move")
+	}
+	expected := []int{WidgetCPULoad, WidgetTopCPU, WidgetCPUPerc, WidgetNetworkIO}
+	for i := range expected {
+		if widgets[i] != expected[i] {
+			t.Fatalf("Expected %v but got %v", expected, widgets)
+		}
+	}
+
+	if !moveWidget(widgets, WidgetTopCPU, 10) || widgets[3] != WidgetTopCPU {
+		t.Errorf("Expected moving past the end to stop at the end but got %v", widgets)
+	}
+	if moveWidget(widgets, WidgetTopCPU, 1) {
+		t.Error("Expected a widget at the end not to move any later")
+	}
+	if moveWidget(widgets, WidgetGPU, -1) {
+		t.Error("Expected a widget which isn't in the layout not to move")
+	}
+}
+
+func TestFocusWatcher(t *testing.T) {
+	config := DefaultConfig()
+	config.Widgets = []int{WidgetCPULoad, WidgetNetworkIO}
+	config.Borders = map[int]linestyle.LineStyle{WidgetNetworkIO: linestyle.None}
+
+	draw := func(watcher *focusWatcher, focused bool) *canvas.Canvas {
+		cvs, err := canvas.New(image.Rect(0, 0, 10, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := watcher.Draw(cvs, &widgetapi.Meta{Focused: focused}); err != nil {
+			t.Fatal(err)
+		}
+		return cvs
+	}
+	newWatcher := func(widget int) *focusWatcher {
+		textBox, err := text.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newFocusWatcher(textBox, config, widget)
+	}
+
+	load, rx, tx := newWatcher(WidgetCPULoad), newWatcher(WidgetNetworkIO), newWatcher(WidgetNetworkIO)
+	draw(load, true)
+	if config.FocusedWidget() != WidgetCPULoad {
+		t.Errorf("Expected the CPU load chart to be focused but got %d", config.FocusedWidget())
+	}
+
+	// the focus moving on to one of the network charts, the other of which is drawn after it
+	draw(rx, true)
+	draw(load, false)
+	draw(tx, false)
+	if config.FocusedWidget() != WidgetNetworkIO {
+		t.Errorf("Expected the network chart to be focused but got %d", config.FocusedWidget())
+	}
+
+	draw(rx, false)
+	if config.FocusedWidget() != noWidget {
+		t.Errorf("Expected nothing to be focused but got %d", config.FocusedWidget())
+	}
+
+	// without a border the focus highlights the top row
+	cvs := draw(rx, true)
+	if top, _ := cvs.Cell(image.Point{0, 0}); top.Opts.BgColor != ColorWidgetFocused {
+		t.Errorf("Expected the focused borderless chart's top row to be highlighted but got %v", top.Opts.BgColor)
+	}
+	if below, _ := cvs.Cell(image.Point{0, 1}); below.Opts.BgColor == ColorWidgetFocused {
+		t.Error("Expected only the top row to be highlighted")
+	}
+	cvs = draw(load, true)
+	if top, _ := cvs.Cell(image.Point{0, 0}); top.Opts.BgColor == ColorWidgetFocused {
+		t.Error("Expected the focus to be shown by the border rather than highlighting")
+	}
+
+	config.Widgets = []int{WidgetNetworkIO}
+	if config.FocusedWidget() != noWidget {
+		t.Error("Expected a widget which isn't in the layout not to be focused")
+	}
+}
+
+func TestParseTopSorts(t *testing.T) {
+	sorts, err := parseTopSorts([]string{"T=mem", "M=CPU"})
+	if err != nil || len(sorts) != 2 || sorts[WidgetTopCPU] != sortByMem || sorts[WidgetTopMem] != sortByCpu {
+		t.Errorf("Expected both lists' sorts to be swapped but got %v, %v", sorts, err)
+	}
+
+	for _, value := range []string{"T=pid", "L=cpu", "T", "TM=cpu", "=mem"} {
+		if _, err := parseTopSorts([]string{value}); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseBorders(t *testing.T) {
+	border, borders, err := parseBorders([]string{"light", "T=none", "O=DOUBLE", "double"})
+	if err != nil || border != linestyle.Double || len(borders) != 2 || borders[WidgetTopCPU] != linestyle.None || borders[WidgetOverview] != linestyle.Double {
+		t.Errorf("Expected double borders with two widget styles but got %v, %v, %v", border, borders, err)
+	}
+
+	config := &PoptopConfig{Border: border, Borders: borders}
+	if config.BorderStyle(WidgetTopCPU) != linestyle.None || config.BorderStyle(WidgetCPULoad) != linestyle.Double {
+		t.Error("Expected widgets without their own border style to use the global one")
+	}
+
+	if border, _, err := parseBorders(nil); err != nil || border != linestyle.Round {
+		t.Errorf("Expected rounded borders by default but got %v, %v", border, err)
+	}
+
+	for _, value := range []string{"dotted", "L=thick", "Q=none", "LC=none", "=none", ""} {
+		if _, _, err := parseBorders([]string{value}); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseWidgetIntervals(t *testing.T) {
+	intervals, err := parseWidgetIntervals([]string{"L=5s", "N=250", "S=1m"})
+	if err != nil || len(intervals) != 3 || intervals[WidgetCPULoad] != 5*time.Second ||
+		intervals[WidgetNetworkIO] != 250*time.Millisecond || intervals[WidgetConnections] != time.Minute {
+		t.Errorf("Expected three widget intervals but got %v, %v", intervals, err)
+	}
+
+	for _, value := range []string{"T=1s", "L=1ms", "L=fast", "L", "LC=1s", "=1s"} {
+		if _, err := parseWidgetIntervals([]string{value}); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseDurationFlag(t *testing.T) {
+	cases := []struct {
+		value    string
+		unit     time.Duration
+		expected time.Duration
+	}{
+		{"250ms", time.Millisecond, 250 * time.Millisecond},
+		{"2m", time.Second, 2 * time.Minute},
+		{"1h30m", time.Second, 90 * time.Minute},
+		{"500", time.Millisecond, 500 * time.Millisecond},
+		{"60", time.Second, time.Minute},
+	}
+
+	for _, c := range cases {
+		if d, err := parseDurationFlag("sample-interval", c.value, c.unit); err != nil || d != c.expected {
+			t.Errorf("Expected %q in %v to be %v but got %v, %v", c.value, c.unit, c.expected, d, err)
+		}
+	}
+
+	for _, value := range []string{"fast", "5 s", "1.5", ""} {
+		if _, err := parseDurationFlag("sample-interval", value, time.Millisecond); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseMaxY(t *testing.T) {
+	cases := []struct {
+		values   []string
+		expected map[int]float64
+	}{
+		{nil, map[int]float64{}},
+		{[]string{"C=100"}, map[int]float64{WidgetCPUPerc: 100}},
+		{[]string{"N=5000", "L=2.5", "N=200"}, map[int]float64{WidgetNetworkIO: 200, WidgetCPULoad: 2.5}},
+	}
+
+	for _, c := range cases {
+		maxY, err := parseMaxY(c.values)
+		if err != nil || fmt.Sprint(maxY) != fmt.Sprint(c.expected) {
+			t.Errorf("Expected %v to be %v but got %v, %v", c.values, c.expected, maxY, err)
+		}
+	}
+
+	for _, value := range []string{"C=0", "C=-5", "C=lots", "T=100", "Z=100", "CN=100", "C", "=100"} {
+		if _, err := parseMaxY([]string{value}); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseGrid(t *testing.T) {
+	cases := []struct {
+		value      string
+		cols, rows int
+	}{
+		{"2x3", 2, 3},
+		{"1x1", 1, 1},
+		{"4X2", 4, 2},
+		{"10x12", 10, 12},
+	}
+
+	for _, c := range cases {
+		if cols, rows, err := parseGrid(c.value); err != nil || cols != c.cols || rows != c.rows {
+			t.Errorf("Expected %q to be %d columns and %d rows but got %d, %d, %v", c.value, c.cols, c.rows, cols, rows, err)
+		}
+	}
+
+	for _, value := range []string{"2", "0x3", "2x0", "-1x2", "2x3x4", "ax2", "x", ""} {
+		if _, _, err := parseGrid(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestWidgetSampling(t *testing.T) {
+	config := &PoptopConfig{
+		SampleInterval:  500 * time.Millisecond,
+		ChartDuration:   time.Minute,
+		WidgetIntervals: map[int]time.Duration{WidgetCPULoad: 5 * time.Second},
+	}
+	config.Finalize()
+
+	cases := []struct {
+		widget     int
+		interval   time.Duration
+		numSamples int
+	}{
+		{WidgetCPULoad, 5 * time.Second, 12},
+		{WidgetConnections, 2 * time.Second, 30},
+		{WidgetDiskIO, 500 * time.Millisecond, 120},
+	}
+	check := func(scale time.Duration) {
+		for _, c := range cases {
+			sampling := config.widgetSampling(c.widget)
+			if sampling.Interval() != c.interval*scale || sampling.numSamples != c.numSamples {
+				t.Errorf("Expected %s to be sampled every %v into %d points but got %v and %d",
+					widgetNames[c.widget], c.interval*scale, c.numSamples, sampling.Interval(), sampling.numSamples)
+			}
+		}
+	}
+	check(1)
+
+	// the charts follow the sample clock as it's slowed down and paused
+	config.ScaleSampleInterval(2)
+	check(2)
+	config.SetPaused(true)
+	if !config.widgetSampling(WidgetCPULoad).clock.Paused() {
+		t.Error("Expected the load chart's clock to pause along with the sample clock")
+	}
+}
+
+func TestReplaySpeedSampling(t *testing.T) {
+	config := &PoptopConfig{
+		SampleInterval:  time.Second,
+		ChartDuration:   time.Minute,
+		ReplayPath:      "session.jsonl",
+		ReplaySpeed:     4,
+		WidgetIntervals: map[int]time.Duration{WidgetCPULoad: 5 * time.Second},
+	}
+	config.Finalize()
+
+	// the clock polls four times as often, while the charts keep the recorded spacing and length
+	if config.NumSamples != 60 || config.CurrentSampleInterval() != 250*time.Millisecond {
+		t.Errorf("Expected 60 samples polled every 250ms but got %d every %v", config.NumSamples, config.CurrentSampleInterval())
+	}
+	for widget, expected := range map[int]time.Duration{WidgetCPUPerc: time.Second, WidgetCPULoad: 5 * time.Second} {
+		if interval := config.widgetSampling(widget).PointInterval(); interval != expected {
+			t.Errorf("Expected %s's points to be %v apart but got %v", widgetNames[widget], expected, interval)
+		}
+	}
+}
+
+func TestParseSeriesColors(t *testing.T) {
+	colors, err := parseSeriesColors([]string{"recv=34", "time_wait=0", "load15=255"})
+	if err != nil || len(colors) != 3 || colors["recv"] != cell.ColorNumber(34) || colors["time_wait"] != cell.ColorNumber(0) {
+		t.Errorf("Expected three series colors but got %v, %v", colors, err)
+	}
+
+	for _, value := range []string{"recv=256", "recv=-1", "recv=green", "recv", "c_recv=34", "=34"} {
+		if _, err := parseSeriesColors([]string{value}); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+
+	config := &PoptopConfig{SeriesColors: colors}
+	if color := config.SeriesColor("recv", ColorRead); color != cell.ColorNumber(34) {
+		t.Errorf("Expected the override for recv but got %v", color)
+	}
+	if color := config.SeriesColor("sent", ColorWrite); color != ColorWrite {
+		t.Errorf("Expected the default color for sent but got %v", color)
+	}
+}
+
+func TestFillBuildInfo(t *testing.T) {
+	defer func(version, commit, timestamp string) {
+		BuildVersion, BuildCommit, BuildTimestamp = version, commit, timestamp
+	}(BuildVersion, BuildCommit, BuildTimestamp)
+	BuildVersion, BuildCommit, BuildTimestamp = "", "", ""
+
+	fillBuildInfo(&debug.BuildInfo{
+		Main: debug.Module{Version: "v0.2.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2022-10-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	})
+	if BuildVersion != "v0.2.0" || BuildCommit != "0123456-dirty" || BuildTimestamp != "2022-10-01T12:00:00Z" {
+		t.Errorf("Expected the embedded build info but got %q %q %q", BuildVersion, BuildCommit, BuildTimestamp)
+	}
+
+	// values set at build time take precedence
+	BuildVersion, BuildCommit = "v1.0.0", "fedcba9"
+	fillBuildInfo(&debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "0123456789abcdef"}}})
+	if BuildVersion != "v1.0.0" || BuildCommit != "fedcba9" {
+		t.Errorf("Expected the build time info to be kept but got %q %q", BuildVersion, BuildCommit)
+	}
+	if info := getBuildInfo(); !strings.HasPrefix(info, "Poptop v1.0.0 ") || !strings.Contains(info, runtime.Version()) {
+		t.Errorf("Expected the version and Go version in %q", info)
+	}
+}
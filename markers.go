@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// X axis labels which mark a point start with this, followed by the marker's number, e.g. "▲2"
+const markerLabel = "▲"
+
+// A point marked on every chart by pressing m
+type chartMarker struct {
+	ticks float64 // the sample clock's ticks when the marker was made, see liveInterval.Ticks
+	label string
+}
+
+// The points marked on the charts, e.g. to line up a manual action with what the charts do after it.
+// termdash can't draw vertical lines across a chart, so markers are drawn as X axis labels at the
+// points they were made, and scroll off with the data. Markers are kept by the sample they were
+// made at rather than the time, so they stay on their point when sampling's paused.
+type chartMarkers struct {
+	mu      sync.Mutex
+	markers []chartMarker
+	count   int     // markers added since the last reset, to number them
+	window  float64 // how many ticks of the sample clock the longest chart spans, see SetWindow
+}
+
+// Sets how many ticks of the sample clock the longest chart spans, markers older than that have
+// scrolled off every chart and are dropped. 0 keeps every marker.
+func (this *chartMarkers) SetWindow(ticks float64) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.window = ticks
+}
+
+// Marks the point sampled at now, in ticks of the sample clock, and returns the marker's label
+func (this *chartMarkers) Add(now float64) string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.prune(now)
+	this.count++
+	marker := chartMarker{now, fmt.Sprintf("%s%d", markerLabel, this.count)}
+	this.markers = append(this.markers, marker)
+	return marker.label
+}
+
+// Returns the markers which haven't scrolled off by now, in the order they were made
+func (this *chartMarkers) Get(now float64) []chartMarker {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.prune(now)
+	return append([]chartMarker{}, this.markers...)
+}
+
+// Drops the markers which have scrolled off every chart
+func (this *chartMarkers) prune(now float64) {
+	if this.window <= 0 {
+		return
+	}
+	kept := 0
+	for kept < len(this.markers) && now-this.markers[kept].ticks > this.window {
+		kept++
+	}
+	this.markers = this.markers[kept:]
+}
+
+// Removes every marker, numbering starts again from 1
+func (this *chartMarkers) Reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.markers, this.count = nil, 0
+}
+
+// Replaces the labels of the points closest to each marker for a chart sampled by sampling, which
+// was created when its clock had ticked start times. Markers before its first point have scrolled off.
+func markLabels(labels map[int]string, markers []chartMarker, sampling *chartSampling, start float64) {
+	now, perPoint := sampling.clock.Ticks(), float64(max(1, sampling.perPoint))
+
+	// until the chart fills up its first point is the first sampled after start
+	newest := min(int(math.Round((now-start)/perPoint)), sampling.numSamples) - 1
+	for _, marker := range markers {
+		i := newest - int(math.Round((now-sampling.clock.scaleTicks(marker.ticks))/perPoint))
+		if i >= 0 && i < sampling.numSamples {
+			labels[i] = marker.label
+		}
+	}
+}
+
+// Returns true if the label marks a point
+func isMarkerLabel(label string) bool {
+	return strings.HasPrefix(label, markerLabel)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestChartMarkers(t *testing.T) {
+	var markers chartMarkers
+	markers.SetWindow(10)
+
+	if label := markers.Add(5); label != "▲1" {
+		t.Errorf("Expected the first marker to be ▲1 but got %s", label)
+	}
+	if label := markers.Add(8); label != "▲2" {
+		t.Errorf("Expected the second marker to be ▲2 but got %s", label)
+	}
+	if got := markers.Get(8); len(got) != 2 || got[1].ticks != 8 {
+		t.Errorf("Expected both markers in order but got %v", got)
+	}
+
+	// the first marker has scrolled off every chart
+	if got := markers.Get(17); len(got) != 1 || got[0].label != "▲2" {
+		t.Errorf("Expected only the second marker but got %v", got)
+	}
+
+	markers.Reset()
+	if len(markers.Get(17)) != 0 || markers.Add(17) != "▲1" {
+		t.Error("Expected resetting to remove the markers and start numbering again")
+	}
+}
+
+func TestMarkLabels(t *testing.T) {
+	// a paused clock only ticks when it's stepped
+	clock := newLiveInterval(time.Second)
+	clock.SetPaused(true)
+	for i := 0; i < 13; i++ {
+		clock.Step()
+	}
+	sampling := &chartSampling{clock: clock, numSamples: 10, perPoint: 1, speed: 1}
+	markers := []chartMarker{
+		{2, "▲1"},
+		{7.4, "▲2"},
+		{13, "▲3"},
+	}
+
+	labels := map[int]string{}
+	for i := 0; i < 10; i++ {
+		labels[i] = "x"
+	}
+	markLabels(labels, markers, sampling, 0)
+
+	// the first marker has scrolled off and the others land on the closest point
+	for i, label := range labels {
+		expected := "x"
+		if i == 3 {
+			expected = "▲2"
+		} else if i == 9 {
+			expected = "▲3"
+		}
+		if label != expected {
+			t.Errorf("Expected label %d to be %s but got %s", i, expected, label)
+		}
+	}
+
+	// until the chart fills up the points start from the chart's first sample
+	labels = map[int]string{}
+	markLabels(labels, markers, sampling, 10)
+	if len(labels) != 1 || labels[2] != "▲3" {
+		t.Errorf("Expected only the newest marker on the newest point but got %v", labels)
+	}
+}
+
+func TestLiveIntervalTicks(t *testing.T) {
+	clock := newLiveInterval(time.Hour)
+	clock.SetPaused(true)
+	clock.Step()
+	clock.Step()
+
+	// time spent paused isn't counted, so markers stay on the sample they were made at
+	if ticks := clock.Ticks(); math.Abs(ticks-2) > 0.01 {
+		t.Errorf("Expected 2 ticks from the steps but got %v", ticks)
+	}
+	if ticks := clock.Scaled(4).Ticks(); math.Abs(ticks-0.5) > 0.01 {
+		t.Errorf("Expected a clock 4 times as slow to have ticked half as often but got %v", ticks)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A metric printed by --once, where labels name each value returned by the collector
+type onceMetric struct {
+	name      string
+	labels    []string
+	format    func(float64) string
+	collector Collector
+}
+
+// Lists the metrics for the enabled chart widgets, sharing the collectors used by the charts
+func onceMetrics(config *PoptopConfig) []*onceMetric {
+	metrics := []*onceMetric{}
+
+	for _, widget := range config.Widgets {
+		switch widget {
+		case WidgetCPULoad:
+			metrics = append(metrics, &onceMetric{"CPU Load", []string{"1min", "5min", "15min"}, formatOnePoint, newLoadCollector()})
+
+		case WidgetCPUPerc:
+			if config.CpuBreakdown {
+				labels := []string{"user", "system", "iowait"}
+				collector := newCpuTimesCollector()
+				if !iowaitReported {
+					labels, collector = labels[:2], dropIowait(collector)
+				}
+				metrics = append(metrics, &onceMetric{"CPU (%)", labels, formatPercent, collector})
+			} else if config.CpuIdle {
+				metrics = append(metrics, &onceMetric{"CPU Idle (%)", []string{"min", "avg", "max"}, formatPercent, newCpuIdleCollector(newCpuCollector())})
+			} else {
+				metrics = append(metrics, &onceMetric{"CPU (%)", []string{"min", "avg", "max"}, formatPercent, newCpuCollector()})
+			}
+
+		case WidgetNetworkIO:
+			// fall back to combined send and receive where the address families aren't reported
+			split := len(config.NetInterfaces) == 0 && config.NetSplitFamily
+			if split {
+				_, err := familyCounters(context.Background())
+				split = err == nil
+			}
+
+			if split {
+				collector := newFamilyNetCollector(familyCounters, time.Now)
+				metrics = append(metrics, &onceMetric{"Network IO (KiB/s)", []string{"v4 send", "v4 recv", "v6 send", "v6 recv"}, formatNoPoint, collector})
+			} else if len(config.NetInterfaces) == 0 {
+				collector := newNetCollector(config.CurrentSampleInterval, netCounters(func(iface string) bool {
+					return !interfaceExcluded(config.ExcludeInterfaces, iface)
+				}))
+				metrics = append(metrics, &onceMetric{"Network IO (KiB/s)", []string{"send", "recv"}, formatNoPoint, collector})
+			}
+
+			for _, iface := range config.NetInterfaces {
+				name := iface
+				collector := newNetCollector(config.CurrentSampleInterval, netCounters(func(n string) bool {
+					return n == name
+				}))
+				metrics = append(metrics, &onceMetric{fmt.Sprintf("Network IO %s (KiB/s)", name), []string{"send", "recv"}, formatNoPoint, collector})
+			}
+
+		case WidgetDiskIOPS:
+			source := chooseDiskSource(context.Background(), config)
+			metrics = append(metrics, diskOnceMetric("Disk IOPS", source, newDiskIOPSCollector(config.CurrentSampleInterval, source.ops)))
+
+		case WidgetDiskIO:
+			source := chooseDiskSource(context.Background(), config)
+			metrics = append(metrics, diskOnceMetric("Disk IO (KiB/s)", source, newDiskIOCollector(source.bytes, time.Now)))
+
+		case WidgetConnections:
+			metrics = append(metrics, &onceMetric{"Connections", []string{"established", "time_wait", "listen"}, formatNoPoint, newConnectionsCollector()})
+
+		case WidgetSwitches:
+			if _, err := switchCounters(context.Background()); err == nil {
+				metrics = append(metrics, &onceMetric{"Context Switches (/s)", []string{"ctxt", "intr"}, formatNoPoint, newSwitchesCollector(switchCounters, time.Now)})
+			}
+
+		case WidgetDiskLatency:
+			if _, err := diskLatencyCounters(context.Background()); err == nil {
+				metrics = append(metrics, &onceMetric{"Disk Latency (ms)", []string{"read", "write"}, formatOnePoint, newDiskLatencyCollector(diskLatencyCounters)})
+			}
+
+		case WidgetDiskSpace:
+			metrics = append(metrics, &onceMetric{"Disk Space " + config.DiskSpacePath, []string{"used", "free"}, formatBytes, newDiskSpaceCollector(config.DiskSpacePath)})
+
+		case WidgetFileLimits:
+			metrics = append(metrics, &onceMetric{"File Limits (%)", []string{"handles", "inodes"}, formatPercent, newFileLimitsCollector(fileHandleCounts, fullestInodeUsage)})
+
+		case WidgetGPU:
+			if _, err := exec.LookPath(nvidiaSmi); err == nil {
+				metrics = append(metrics, &onceMetric{"GPU (%)", []string{"util", "vram"}, formatPercent, newGpuPercCollector(config.CommandTimeout)})
+			}
+		}
+	}
+
+	return metrics
+}
+
+// A disk metric labelled like its chart, where a source which only gives the total drops the write value
+func diskOnceMetric(name string, source *diskSource, collector Collector) *onceMetric {
+	name, entries := source.title(name, nil, nil, ColorRead, ColorWrite)
+	labels := []string{}
+	for _, entry := range entries {
+		labels = append(labels, entry.label)
+	}
+
+	return &onceMetric{name, labels, formatNoPoint, CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		values, err := collector.Collect(ctx)
+		if err != nil || values == nil {
+			return values, err
+		}
+		return values[:len(labels)], nil
+	})}
+}
+
+// Leaves the iowait % out of the CPU times, for platforms which don't report it
+func dropIowait(collector Collector) Collector {
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		values, err := collector.Collect(ctx)
+		if err != nil || len(values) < 2 {
+			return values, err
+		}
+		return values[:2], nil
+	})
+}
+
+// Collects utilization % and VRAM used % for each GPU, as charted by the GPU chart
+func newGpuPercCollector(timeout time.Duration) Collector {
+	gpu := newGpuCollector(timeout)
+
+	return CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		values, err := gpu.Collect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		perc := []float64{}
+		for i := 0; i+2 < len(values); i += 3 {
+			vram := math.NaN()
+			if values[i+2] > 0 {
+				vram = values[i+1] / values[i+2] * 100
+			}
+			perc = append(perc, values[i], vram)
+		}
+		return perc, nil
+	})
+}
+
+// Formats the values of a metric as e.g. "1min 2.3   5min 2.1   15min 1.9". If there are more
+// values than labels, e.g. for several GPUs, then the labels repeat with an index suffix.
+func formatOnceValues(metric *onceMetric, values []float64) string {
+	if values == nil {
+		return "n/a"
+	}
+
+	parts := []string{}
+	for i, value := range values {
+		label := metric.labels[i%len(metric.labels)]
+		if len(values) > len(metric.labels) {
+			label = fmt.Sprintf("%s%d", label, i/len(metric.labels))
+		}
+
+		formatted := "n/a"
+		if !math.IsNaN(value) {
+			formatted = metric.format(value)
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", label, formatted))
+	}
+
+	return strings.Join(parts, "   ")
+}
+
+// Samples each enabled metric once, prints a table to out and returns. Rate based metrics
+// need two readings, so every collector is sampled twice one sample interval apart.
+func runOnce(ctx context.Context, config *PoptopConfig, out io.Writer) error {
+	metrics := onceMetrics(config)
+	results := make([][]float64, len(metrics))
+
+	for round := 0; round < 2; round++ {
+		if round > 0 {
+			select {
+			case <-time.After(config.SampleInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		for i, metric := range metrics {
+			values, err := metric.collector.Collect(ctx)
+			if err != nil {
+				return err
+			}
+			results[i] = values
+		}
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	for i, metric := range metrics {
+		fmt.Fprintf(writer, "%s\t%s\n", metric.name, formatOnceValues(metric, results[i]))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	enabled := map[int]bool{}
+	for _, widget := range config.Widgets {
+		enabled[widget] = true
+	}
+
+	if enabled[WidgetHostInfo] {
+		info, err := hostInfoText(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "\nSystem Info\n%s\n", info)
+	}
+
+	if enabled[WidgetTopCPU] || enabled[WidgetTopMem] {
+		topCpu, topMem, err := topProcesses(ctx, config)
+		if err != nil {
+			return err
+		}
+
+		cpuSort, memSort := config.TopSort(WidgetTopCPU), config.TopSort(WidgetTopMem)
+		if enabled[WidgetTopCPU] {
+			fmt.Fprintf(out, "\nTop CPU Processes\n%s", strings.Join(formatTopRows(topCpu, cpuSort.column, cpuSort.perc), ""))
+		}
+
+		if enabled[WidgetTopMem] {
+			fmt.Fprintf(out, "\nTop Memory Processes\n%s", strings.Join(formatTopRows(topMem, memSort.column, memSort.perc), ""))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/container/grid"
+	"github.com/mum4k/termdash/widgets/sparkline"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// A single labelled sparkline in the overview widget
+type overviewRow struct {
+	label  string
+	format func(float64) string
+	color  cell.Color
+	series *BoundedSeries
+	spark  *sparkline.SparkLine
+}
+
+func (this *overviewRow) update(config *PoptopConfig) error {
+	label := this.label
+	if last := this.series.Last(); !math.IsNaN(last) {
+		label = fmt.Sprintf("%s %s", this.label, this.format(last))
+	}
+
+	this.spark.Clear()
+	return this.spark.Add(sparklineValues(config.Smoothed(this.series)),
+		sparkline.Label(label, cell.FgColor(ColorChartLabel)))
+}
+
+// Create a dense summary widget showing the current value of the key metrics (load, CPU, memory,
+// network and disk) alongside a sparkline of each, which is useful on small terminals.
+func newOverviewBox(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
+	rows := []*overviewRow{
+		{label: "Load 1min", format: formatOnePoint, color: ColorHot1},
+		{label: "CPU avg", format: formatPercent, color: ColorHot2},
+		{label: "Memory", format: formatPercent, color: ColorHot3},
+		{label: "Net send KiB/s", format: formatNoPoint, color: ColorWrite},
+		{label: "Net recv KiB/s", format: formatNoPoint, color: ColorRead},
+		{label: "Disk read KiB/s", format: formatNoPoint, color: ColorRead},
+		{label: "Disk write KiB/s", format: formatNoPoint, color: ColorWrite},
+	}
+
+	if config.MemAvailable {
+		rows[2].label, rows[2].format = "Memory avail", formatBytes
+	}
+
+	builder := grid.New()
+	for i, row := range rows {
+		spark, err := sparkline.New(sparkline.Color(row.color))
+		if err != nil {
+			return nil, err
+		}
+
+		row.spark = spark
+		row.series = newChartSeries(config, config.widgetSampling(WidgetOverview), "overview."+row.label)
+
+		// Tab stops once on the Overview, at its first row
+		opts := makeInnerContainer(spark, config, WidgetOverview)
+		if i > 0 {
+			opts = append(opts, container.KeyFocusSkip())
+		}
+		builder.Add(grid.RowHeightPercWithOpts(gridPerc(len(rows)), opts))
+	}
+
+	loadRow, cpuRow, memRow, sentRow, recvRow, readRow, writeRow := rows[0], rows[1], rows[2], rows[3], rows[4], rows[5], rows[6]
+	var sent, recv, read, write counterRate
+
+	// the same interfaces as the network chart, leaving out those matching --exclude-interface
+	netTotals := netCounters(func(iface string) bool {
+		return !interfaceExcluded(config.ExcludeInterfaces, iface)
+	})
+
+	config.goPeriodicLive(ctx, config.sampleClock, config.toastError, func() error {
+		now := time.Now()
+
+		loadAvg, err := load.AvgWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		loadRow.series.AddValue(loadAvg.Load1)
+
+		cpuAllPerc, err := cpu.PercentWithContext(ctx, 0, true)
+		if err != nil {
+			return err
+		}
+		cpuRow.series.AddValue(getAvg(cpuAllPerc))
+
+		vmem, err := mem.VirtualMemoryWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		if config.MemAvailable {
+			memRow.series.AddValue(float64(vmem.Available))
+		} else {
+			memRow.series.AddValue(vmem.UsedPercent)
+		}
+
+		totals, err := netTotals(ctx)
+		if err != nil {
+			return err
+		}
+		if rate, ok := sent.Rate(totals[0], now); ok {
+			sentRow.series.AddValue(rate / 1024)
+		}
+		if rate, ok := recv.Rate(totals[1], now); ok {
+			recvRow.series.AddValue(rate / 1024)
+		}
+
+		diskstats, err := disk.IOCountersWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		var readBytes, writeBytes uint64
+		for _, v := range diskstats {
+			readBytes += v.ReadBytes
+			writeBytes += v.WriteBytes
+		}
+		if rate, ok := read.Rate(readBytes, now); ok {
+			readRow.series.AddValue(rate / 1024)
+		}
+		if rate, ok := write.Rate(writeBytes, now); ok {
+			writeRow.series.AddValue(rate / 1024)
+		}
+
+		for _, row := range rows {
+			if err := row.update(config); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	gridOpts, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	title := cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(" Overview ")
+
+	return append(borderOptions(config, WidgetOverview, title), gridOpts...), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Listens on addr and serves Go's pprof profiles of poptop itself under /debug/pprof/ until the
+// context is done, for profiling poptop's own sampling and drawing, e.g.
+// 'go tool pprof http://localhost:6060/debug/pprof/profile'. The profiles get their own mux so
+// they're never served by --serve.
+func (this *PoptopConfig) servePprof(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Could not listen on %s for --pprof: %v\n", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	this.serveUntilDone(ctx, listener, mux)
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// How long to wait before reconnecting after losing the connection to a --remote host
+const remoteRetryInterval = 5 * time.Second
+
+// The charts poptop's JSON output has samples for, so the only ones which can be charted from a
+// remote host. The rest of the widgets show the local system or nothing.
+var remoteWidgets = []int{WidgetCPULoad, WidgetCPUPerc, WidgetNetworkIO, WidgetDiskIOPS, WidgetDiskIO, WidgetDiskLatency,
+	WidgetDiskSpace, WidgetConnections, WidgetSwitches, WidgetFileLimits, WidgetGPU}
+
+// Returns the ssh command line which runs poptop on the remote host, printing JSON samples of
+// the configured charts at the same interval that we chart them
+func remoteCommand(config *PoptopConfig) (string, []string, error) {
+	shortcodes := []rune{}
+	for _, shortcode := range widgetShortcodes() {
+		widget := shortcodeToWidget[shortcode]
+		if find(config.Widgets, widget) != -1 && find(remoteWidgets, widget) != -1 {
+			shortcodes = append(shortcodes, shortcode)
+		}
+	}
+	if len(shortcodes) == 0 {
+		return "", nil, fmt.Errorf("None of the widgets can be charted from a remote host, add at least one of the CPU Load, CPU Percent, Network IO, Disk IOPS, Disk IO, Disk Latency, Disk Space, Connections, Context Switches, File Limits or GPU charts.\n")
+	}
+
+	// ssh runs this through the remote shell, the remote command is left unquoted so it can be
+	// e.g. a path or 'sudo poptop'
+	remote := []string{config.RemoteCommand, "--json", "-s", config.SampleInterval.String(), "-" + string(shortcodes)}
+	for _, iface := range config.NetInterfaces {
+		remote = append(remote, shellQuote("--net-interface="+iface))
+	}
+	for _, iface := range config.ExcludeInterfaces {
+		remote = append(remote, shellQuote("--exclude-interface="+iface))
+	}
+
+	// the flags which change what the charts sample, so the host samples what we chart
+	if config.Cumulative {
+		remote = append(remote, "--cumulative")
+	}
+	if config.NetSplitFamily {
+		remote = append(remote, "--net-split-family")
+	}
+	if find(config.Widgets, WidgetDiskSpace) != -1 {
+		remote = append(remote, shellQuote("--disk-space-path="+config.DiskSpacePath))
+	}
+
+	// BatchMode stops ssh prompting for a password over the charts, and the keepalives notice a
+	// dropped connection rather than waiting on it forever
+	args := []string{"-o", "BatchMode=yes", "-o", "ServerAliveInterval=5", "-o", "ServerAliveCountMax=2",
+		config.RemoteHost, strings.Join(remote, " ")}
+	return "ssh", args, nil
+}
+
+// Quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Streams JSON samples from poptop running on the --remote host over ssh into the replay, until
+// the context is done. If the connection drops or poptop exits we say so in a toast and reconnect
+// after remoteRetryInterval, the charts carry on from where they left off.
+func (this *PoptopConfig) streamRemote(ctx context.Context, name string, args []string) {
+	for {
+		err := this.streamRemoteOnce(ctx, name, args)
+		if ctx.Err() != nil {
+			return
+		}
+		this.toast.Show(fmt.Sprintf("Lost the connection to %s (%v), reconnecting in %v",
+			this.RemoteHost, err, remoteRetryInterval), time.Now())
+
+		select {
+		case <-time.After(remoteRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Runs ssh once, streaming its output into the replay until it exits, and returns why it exited
+func (this *PoptopConfig) streamRemoteOnce(ctx context.Context, name string, args []string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	streamErr := this.replay.Stream(stdout, func(err error) {
+		this.toast.Show(strings.TrimSpace(err.Error()), time.Now())
+	})
+	err = cmd.Wait()
+
+	// ssh's own complaint, e.g. "Connection refused", says more than its exit status
+	if lines := strings.Split(strings.TrimSpace(stderr.String()), "\n"); lines[len(lines)-1] != "" {
+		return fmt.Errorf("%s", lines[len(lines)-1])
+	}
+	if err != nil {
+		return err
+	}
+	if streamErr != nil {
+		return streamErr
+	}
+	return fmt.Errorf("%s exited", this.RemoteCommand)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteCommand(t *testing.T) {
+	config := &PoptopConfig{
+		RemoteHost:        "me@host",
+		RemoteCommand:     "/opt/poptop",
+		SampleInterval:    2 * time.Second,
+		Widgets:           []int{WidgetTopCPU, WidgetDiskIO, WidgetCPULoad},
+		ExcludeInterfaces: []string{"it's"},
+	}
+
+	name, args, err := remoteCommand(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "ssh" || args[len(args)-2] != "me@host" {
+		t.Errorf("Expected to ssh to me@host but got %s %v", name, args)
+	}
+	expected := `/opt/poptop --json -s 2s -LE '--exclude-interface=it'\''s'`
+	if args[len(args)-1] != expected {
+		t.Errorf("Expected the remote command %q but got %q", expected, args[len(args)-1])
+	}
+
+	// the flags which change what's sampled are passed on
+	config.Widgets = []int{WidgetNetworkIO, WidgetDiskSpace}
+	config.NetInterfaces = []string{"eth0", "wlan0"}
+	config.ExcludeInterfaces = nil
+	config.Cumulative = true
+	config.NetSplitFamily = true
+	config.DiskSpacePath = "/home"
+	if _, args, err = remoteCommand(config); err != nil {
+		t.Fatal(err)
+	}
+	expected = `/opt/poptop --json -s 2s -NV '--net-interface=eth0' '--net-interface=wlan0' --cumulative --net-split-family '--disk-space-path=/home'`
+	if args[len(args)-1] != expected {
+		t.Errorf("Expected the remote command %q but got %q", expected, args[len(args)-1])
+	}
+
+	config.Widgets = []int{WidgetTopCPU, WidgetHostInfo}
+	if _, _, err := remoteCommand(config); err == nil || !strings.Contains(err.Error(), "remote") {
+		t.Errorf("Expected an error with no remote-capable widgets but got %v", err)
+	}
+}
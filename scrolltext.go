@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/mouse"
+	"github.com/mum4k/termdash/private/canvas"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+	"github.com/mum4k/termdash/widgetapi"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// A text box of rows under some pinned lines which can be scrolled through while focused (click
+// on it to focus it), using the arrow keys, PgUp / PgDn or the mouse wheel. The termdash text
+// widget scrolls by itself but jumps back to the top each time its contents are replaced, so we
+// track the position here and only write the rows from there down.
+type scrollText struct {
+	*text.Text
+
+	mu     sync.Mutex
+	pinned []*topLine
+	rows   []*topLine
+	offset int // index of the first row shown
+	height int // lines the box had room for when last drawn
+}
+
+func newScrollText() (*scrollText, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+	return &scrollText{Text: textBox}, nil
+}
+
+// Replaces the contents of the box, keeping the scroll position unless there are now fewer rows
+func (this *scrollText) SetLines(pinned, rows []*topLine) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.pinned, this.rows = pinned, rows
+	this.scroll(0)
+}
+
+// Moves the scroll position by delta rows and rewrites the box, caller must hold this.mu
+func (this *scrollText) scroll(delta int) {
+	this.offset = max(0, min(this.offset+delta, len(this.rows)-1))
+
+	lines := append([]*topLine{}, this.pinned...)
+	if len(this.rows) > 0 {
+		lines = append(lines, this.rows[this.offset:]...)
+	}
+	writeTopLines(this.Text, lines)
+}
+
+// The number of rows to scroll by for PgUp / PgDn, which is however many fit below the pinned lines
+func (this *scrollText) pageSize() int {
+	pinned := 0
+	for _, line := range this.pinned {
+		if line.text != "" {
+			pinned++
+		}
+	}
+	return max(1, this.height-pinned)
+}
+
+// Draw implements widgetapi.Widget.Draw, noting the height so we know how far a page scrolls
+func (this *scrollText) Draw(cvs *canvas.Canvas, meta *widgetapi.Meta) error {
+	this.mu.Lock()
+	this.height = cvs.Area().Dy()
+	this.mu.Unlock()
+
+	return this.Text.Draw(cvs, meta)
+}
+
+// Keyboard implements widgetapi.Widget.Keyboard
+func (this *scrollText) Keyboard(k *terminalapi.Keyboard, meta *widgetapi.EventMeta) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	switch k.Key {
+	case keyboard.KeyArrowUp:
+		this.scroll(-1)
+	case keyboard.KeyArrowDown:
+		this.scroll(1)
+	case keyboard.KeyPgUp:
+		this.scroll(-this.pageSize())
+	case keyboard.KeyPgDn:
+		this.scroll(this.pageSize())
+	}
+	return nil
+}
+
+// Mouse implements widgetapi.Widget.Mouse
+func (this *scrollText) Mouse(m *terminalapi.Mouse, meta *widgetapi.EventMeta) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	switch m.Button {
+	case mouse.ButtonWheelUp:
+		this.scroll(-1)
+	case mouse.ButtonWheelDown:
+		this.scroll(1)
+	}
+	return nil
+}
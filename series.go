@@ -1,11 +1,24 @@
 package main
 
-import "math"
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
 
+// A fixed size window of values used to compute moving averages and medians. NaN values take up
+// a slot in the window but are excluded from both.
 type fifoSet struct {
 	numValues int
 	values    []float64
 	sum       float64
+	numValid  int // how many of values are not NaN
 }
 
 func newFifoSet(numValues int) *fifoSet {
@@ -17,26 +30,75 @@ func newFifoSet(numValues int) *fifoSet {
 
 func (this *fifoSet) AddValue(v float64) {
 	vals := append(this.values, v)
-	this.sum += v
+	if !math.IsNaN(v) {
+		this.sum += v
+		this.numValid++
+	}
 	if len(vals) > this.numValues {
-		this.sum -= vals[0]
+		if dropped := vals[0]; !math.IsNaN(dropped) {
+			this.sum -= dropped
+			this.numValid--
+		}
 		vals = vals[1:]
 	}
 	this.values = vals
 }
 
+// Returns the average of the non-NaN values in the window, or NaN if there are none
 func (this *fifoSet) Avg() float64 {
-	return this.sum / float64(len(this.values))
+	if this.numValid == 0 {
+		return math.NaN()
+	}
+	return this.sum / float64(this.numValid)
+}
+
+// Returns the median of the non-NaN values in the window, or NaN if there are none. With an even
+// number of values this is the average of the middle two.
+func (this *fifoSet) Median() float64 {
+	if this.numValid == 0 {
+		return math.NaN()
+	}
+
+	sorted := make([]float64, 0, this.numValid)
+	for _, v := range this.values {
+		if !math.IsNaN(v) {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
 }
 
+// A series of chart values. A series is only added to and read by its chart's sampler, but the
+// mutex lets Reset clear it from the key handler.
 type BoundedSeries struct {
+	mu        sync.Mutex
 	values    []float64 // array of values
 	numValues int       // how many values have been requested to be stored
 	maxValues int       // how many values we're actually storing (larger to allow smoothing)
 	highWater int       // how many values have been populated
+	last      float64   // the most recently added value, before any averaging into points
+
+	// when perPoint > 1 each stored value is the average of perPoint added values, pending
+	// counts the values added towards the next point and pendingValid the non-NaN ones
+	perPoint     int
+	pending      int
+	pendingValid int
+	pendingSum   float64
 }
 
 func NewBoundedSeries(numValues int) *BoundedSeries {
+	return NewAveragedSeries(numValues, 1)
+}
+
+// Creates a series storing numValues points, where each point is the average of perPoint added
+// values. This caps the memory used by long charts at the cost of resolution.
+func NewAveragedSeries(numValues, perPoint int) *BoundedSeries {
 	maxValues := numValues * 2 // double the number of values to support moving averages
 	values := make([]float64, maxValues)
 
@@ -49,10 +111,69 @@ func NewBoundedSeries(numValues int) *BoundedSeries {
 		numValues: numValues,
 		maxValues: maxValues,
 		highWater: 0,
+		last:      math.NaN(),
+		perPoint:  max(1, perPoint),
 	}
 }
 
+// Creates a series sized for a chart sampled by sampling, averaging samples into points if the chart
+// needs more samples than the configured maximum. The series is registered with the config under
+// name, e.g. "cpuLoad.load1", so it's cleared along with the rest by pressing r and saved by pressing s.
+func newChartSeries(config *PoptopConfig, sampling *chartSampling, name string) *BoundedSeries {
+	series := NewAveragedSeries(sampling.numSamples, sampling.perPoint)
+	config.chartSeries.Add(name, series)
+	return series
+}
+
+// Creates a series for a network throughput chart, see newChartSeries. With --bits the series
+// holds bits, which are saved as the KiB they were sampled in.
+func newNetChartSeries(config *PoptopConfig, sampling *chartSampling, name string) *BoundedSeries {
+	if !config.Bits {
+		return newChartSeries(config, sampling, name)
+	}
+	series := NewAveragedSeries(sampling.numSamples, sampling.perPoint)
+	config.chartSeries.AddScaled(name, series, 1.0/bitsPerKiB)
+	return series
+}
+
+// Empties the series back to how it was created, as if no values had been added. The values
+// are replaced rather than overwritten, so slices returned before the reset are left as they were.
+func (this *BoundedSeries) Reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.values = make([]float64, this.maxValues)
+	for i := range this.values {
+		this.values[i] = math.NaN()
+	}
+	this.highWater = 0
+	this.last = math.NaN()
+	this.pending, this.pendingValid, this.pendingSum = 0, 0, 0
+}
+
 func (this *BoundedSeries) AddValue(v float64) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.last = v
+
+	if this.perPoint > 1 {
+		this.pending++
+		if !math.IsNaN(v) {
+			this.pendingSum += v
+			this.pendingValid++
+		}
+		if this.pending < this.perPoint {
+			return
+		}
+
+		v = math.NaN()
+		if this.pendingValid > 0 {
+			v = this.pendingSum / float64(this.pendingValid)
+		}
+		this.pending, this.pendingValid, this.pendingSum = 0, 0, 0
+	}
+
 	if this.highWater < this.maxValues {
 		this.values[this.highWater] = v
 		this.highWater++
@@ -66,16 +187,119 @@ func (this *BoundedSeries) AddValue(v float64) {
 }
 
 func (this *BoundedSeries) Values() []float64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
 	start := max(0, this.highWater-this.numValues)
 	end := min(this.highWater, start+this.numValues)
 	return this.values[start:end]
 }
 
+// Returns the most recently added value, or NaN if no values have been added. When averaging
+// samples into points this is the latest sample rather than the latest point.
+func (this *BoundedSeries) Last() float64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.last
+}
+
+// Returns the current Values() window without any NaN entries
+func (this *BoundedSeries) validValues() []float64 {
+	valid := []float64{}
+	for _, v := range this.Values() {
+		if !math.IsNaN(v) {
+			valid = append(valid, v)
+		}
+	}
+	return valid
+}
+
+// Returns the population variance of the current Values() window, ignoring NaN values.
+// Returns 0 rather than NaN if there are fewer than two values.
+func (this *BoundedSeries) Variance() float64 {
+	values := this.validValues()
+	if len(values) < 2 {
+		return 0
+	}
+
+	mean := getAvg(values)
+	sumSquares := float64(0)
+	for _, v := range values {
+		sumSquares += (v - mean) * (v - mean)
+	}
+
+	return sumSquares / float64(len(values))
+}
+
+// Returns the population standard deviation of the current Values() window, ignoring NaN values.
+func (this *BoundedSeries) StdDev() float64 {
+	return math.Sqrt(this.Variance())
+}
+
+// Returns the pth percentile (0-100) of the current Values() window, linearly interpolating
+// between the closest ranks. NaN values are ignored, and NaN is returned if there are no values.
+func (this *BoundedSeries) Percentile(p float64) float64 {
+	sorted := this.validValues()
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	rank = math.Max(0, math.Min(rank, float64(len(sorted)-1)))
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Returns the least squares slope of the current Values() window, in value per point, ignoring NaN
+// values. Returns NaN if there are fewer than two values.
+func (this *BoundedSeries) Slope() float64 {
+	var n, sumX, sumY, sumXY, sumXX float64
+	for i, v := range this.Values() {
+		if math.IsNaN(v) {
+			continue
+		}
+		x := float64(i)
+		n++
+		sumX += x
+		sumY += v
+		sumXY += x * v
+		sumXX += x * x
+	}
+
+	denom := n*sumXX - sumX*sumX
+	if n < 2 || denom == 0 {
+		return math.NaN()
+	}
+	return (n*sumXY - sumX*sumY) / denom
+}
+
+// Returns the values the chart shows, each the average of the windowSize values up to it
 func (this *BoundedSeries) SmoothedValues(windowSize int) []float64 {
+	return this.smoothedValues(windowSize, (*fifoSet).Avg)
+}
+
+// Returns the values the chart shows, each the median of the windowSize values up to it. This
+// rejects a single sample spike outright where the average smears it across the window.
+func (this *BoundedSeries) SmoothedValuesMedian(windowSize int) []float64 {
+	return this.smoothedValues(windowSize, (*fifoSet).Median)
+}
+
+// Returns the values the chart shows, each reduced from the windowSize values up to it
+func (this *BoundedSeries) smoothedValues(windowSize int, reduce func(*fifoSet) float64) []float64 {
 	if windowSize <= 1 {
 		return this.Values()
 	}
 
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	// Start early enough to warm up the moving average for the first visible value. We only
+	// ever read up to highWater, so the NaN-filled unpopulated tail of the backing array never
+	// reaches the average, early in the series the warmup is just cut short at index 0.
 	start := max(0, this.highWater-this.numValues-windowSize)
 	set := newFifoSet(windowSize)
 	series := make([]float64, this.numValues)
@@ -84,7 +308,7 @@ func (this *BoundedSeries) SmoothedValues(windowSize int) []float64 {
 	for i := start; i < this.highWater; i++ {
 		set.AddValue(this.values[i])
 		if i >= this.highWater-this.numValues {
-			series[j] = set.Avg()
+			series[j] = reduce(set)
 			j++
 		}
 	}
@@ -95,3 +319,149 @@ func (this *BoundedSeries) SmoothedValues(windowSize int) []float64 {
 
 	return series
 }
+
+// Averages values into at most width buckets of the same number of consecutive values, so a series
+// with more values than a chart has room for keeps the shape of the whole window. Returns the
+// bucket averages and how many values went into each, the last bucket may hold fewer. NaN values
+// are ignored, a bucket of only NaN values is NaN.
+func bucketValues(values []float64, width int) ([]float64, int) {
+	if width <= 0 || len(values) <= width {
+		width = len(values)
+	}
+	if width == 0 {
+		return []float64{}, 1
+	}
+
+	size := (len(values) + width - 1) / width
+	buckets := []float64{}
+
+	for start := 0; start < len(values); start += size {
+		avg, ok := seriesAvg(values[start:min(start+size, len(values))])
+		if !ok {
+			avg = math.NaN()
+		}
+		buckets = append(buckets, avg)
+	}
+
+	return buckets, size
+}
+
+// Tracks the previous value of a cumulative counter so it can be reported as a per second rate
+type counterRate struct {
+	last     uint64
+	lastTime time.Time
+}
+
+// Returns the per second rate since the last call. The bool is false if there's no meaningful
+// rate, i.e. on the first call since there's nothing to compare against yet, or if the counter
+// went backwards (e.g. a device was removed).
+func (this *counterRate) Rate(value uint64, now time.Time) (float64, bool) {
+	first := this.lastTime.IsZero()
+	elapsed := now.Sub(this.lastTime).Seconds()
+	reset := value < this.last
+	delta := value - this.last
+
+	this.last = value
+	this.lastTime = now
+
+	if first || reset || elapsed <= 0 {
+		return 0, false
+	}
+	return float64(delta) / elapsed, true
+}
+
+// The series of every chart, so they can all be cleared or saved at once
+type seriesRegistry struct {
+	mu     sync.Mutex
+	names  []string
+	series []*BoundedSeries
+	scales []float64 // what each series' values are multiplied by to save them, see AddScaled
+}
+
+func (this *seriesRegistry) Add(name string, series *BoundedSeries) {
+	this.AddScaled(name, series, 1)
+}
+
+// Registers a series which is charted in different units to the ones it's sampled in, e.g. network
+// throughput with --bits, so it's saved in the sampled units after multiplying its values by scale
+func (this *seriesRegistry) AddScaled(name string, series *BoundedSeries, scale float64) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.names = append(this.names, name)
+	this.series = append(this.series, series)
+	this.scales = append(this.scales, scale)
+}
+
+// Returns every registered series in the order they were added
+func (this *seriesRegistry) All() []*BoundedSeries {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]*BoundedSeries{}, this.series...)
+}
+
+// Clears every registered series, see BoundedSeries.Reset
+func (this *seriesRegistry) Reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, series := range this.series {
+		series.Reset()
+	}
+}
+
+// Writes the values of every registered series as CSV, with a column per series headed by its
+// name. The rows line up at the latest point of each series, so a series which has fewer points,
+// e.g. because its chart was added later, has empty cells at the top. Gaps are empty too. Values
+// are in the units they're sampled in, e.g. network throughput is in KiB even with --bits.
+func (this *seriesRegistry) WriteCSV(out io.Writer) error {
+	this.mu.Lock()
+	names := append([]string{}, this.names...)
+	series := append([]*BoundedSeries{}, this.series...)
+	scales := append([]float64{}, this.scales...)
+	this.mu.Unlock()
+
+	values := [][]float64{}
+	rows := 0
+	for _, s := range series {
+		values = append(values, s.Values())
+		rows = max(rows, len(values[len(values)-1]))
+	}
+
+	writer := csv.NewWriter(out)
+	if err := writer.Write(names); err != nil {
+		return err
+	}
+	for row := 0; row < rows; row++ {
+		record := make([]string, len(values))
+		for i, v := range values {
+			// the series' first point is on the row which leaves its last point on the last row
+			if j := row - (rows - len(v)); j >= 0 && !math.IsNaN(v[j]) {
+				record[i] = strconv.FormatFloat(v[j]*scales[i], 'f', -1, 64)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Saves the values of every registered series to a CSV file in the current directory named for
+// now, e.g. poptop-20260102-150405.csv, and returns its path
+func (this *seriesRegistry) Save(now time.Time) (string, error) {
+	path, err := filepath.Abs(now.Format("poptop-20060102-150405.csv"))
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := this.WriteCSV(file); err != nil {
+		file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"math"
 	"runtime/debug"
+	"strings"
 	"testing"
+	"time"
 )
 
 const float64EqualityThreshold = 1e-9
@@ -66,3 +68,309 @@ func TestBoundedSeriesSmoothing(t *testing.T) {
 	assertSliceEq(t, series.SmoothedValues(1), []float64{2, 3, 4, 5, 6})
 	assertSliceEq(t, series.SmoothedValues(3), []float64{1, 2, 3, 4, 5})
 }
+
+func TestBoundedSeriesPercentile(t *testing.T) {
+	series := NewBoundedSeries(5)
+	assertEq(t, series.Percentile(50), math.NaN())
+
+	series.AddValue(7)
+	assertEq(t, series.Percentile(0), 7)
+	assertEq(t, series.Percentile(50), 7)
+	assertEq(t, series.Percentile(100), 7)
+
+	for _, v := range []float64{3, 1, 9, 5} {
+		series.AddValue(v)
+	}
+
+	// sorted window is 1, 3, 5, 7, 9
+	assertEq(t, series.Percentile(0), 1)
+	assertEq(t, series.Percentile(25), 3)
+	assertEq(t, series.Percentile(50), 5)
+	assertEq(t, series.Percentile(95), 8.6)
+	assertEq(t, series.Percentile(100), 9)
+
+	// the oldest value (7) rolls out of the window, leaving 1, 3, 5, 9, 11
+	series.AddValue(11)
+	assertEq(t, series.Percentile(50), 5)
+	assertEq(t, series.Percentile(75), 9)
+	assertEq(t, series.Percentile(100), 11)
+}
+
+func TestBoundedSeriesStdDev(t *testing.T) {
+	series := NewBoundedSeries(8)
+	assertEq(t, series.Variance(), 0)
+	assertEq(t, series.StdDev(), 0)
+
+	series.AddValue(5)
+	assertEq(t, series.Variance(), 0)
+	assertEq(t, series.StdDev(), 0)
+
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		series.AddValue(v)
+	}
+
+	// the initial 5 has rolled out of the window, leaving a mean of 5
+	assertEq(t, series.Variance(), 4)
+	assertEq(t, series.StdDev(), 2)
+
+	// NaN values are ignored
+	series = NewBoundedSeries(4)
+	series.AddValue(math.NaN())
+	series.AddValue(1)
+	series.AddValue(math.NaN())
+	series.AddValue(3)
+	assertEq(t, series.Variance(), 1)
+	assertEq(t, series.StdDev(), 1)
+}
+
+func TestBoundedSeriesSlope(t *testing.T) {
+	series := NewBoundedSeries(5)
+	assertEq(t, series.Slope(), math.NaN())
+
+	series.AddValue(10)
+	assertEq(t, series.Slope(), math.NaN())
+
+	// a gap doesn't throw off the slope of the rest
+	series.AddValue(math.NaN())
+	series.AddValue(14)
+	series.AddValue(16)
+	assertEq(t, series.Slope(), 2)
+
+	// older values scroll out of the window
+	series.AddValue(10)
+	series.AddValue(10)
+	series.AddValue(10)
+	assertEq(t, series.Slope(), -1.4)
+}
+
+func TestBoundedSeriesSmoothingFewerValuesThanWindow(t *testing.T) {
+	series := NewBoundedSeries(6)
+
+	series.AddValue(4)
+	series.AddValue(8)
+	assertSliceEq(t, series.SmoothedValues(5), []float64{4, 6, math.NaN(), math.NaN(), math.NaN(), math.NaN()})
+
+	series.AddValue(6)
+	smoothed := series.SmoothedValues(5)
+	assertSliceEq(t, smoothed, []float64{4, 6, 6, math.NaN(), math.NaN(), math.NaN()})
+
+	// populated positions must never be NaN, only the not yet populated tail
+	for i, v := range smoothed {
+		if i < 3 && math.IsNaN(v) {
+			t.Errorf("Unexpected NaN at index %d", i)
+		}
+		if i >= 3 && !math.IsNaN(v) {
+			t.Errorf("Expected NaN at index %d, got %f", i, v)
+		}
+	}
+
+	// a window larger than the whole backing array
+	assertSliceEq(t, series.SmoothedValues(20), []float64{4, 6, 6, math.NaN(), math.NaN(), math.NaN()})
+}
+
+func TestFifoSetIgnoresNaN(t *testing.T) {
+	set := newFifoSet(3)
+	assertEq(t, set.Avg(), math.NaN())
+
+	set.AddValue(math.NaN())
+	assertEq(t, set.Avg(), math.NaN())
+
+	set.AddValue(2)
+	assertEq(t, set.Avg(), 2)
+
+	set.AddValue(4)
+	assertEq(t, set.Avg(), 3)
+
+	// the NaN drops out of the window
+	set.AddValue(6)
+	assertEq(t, set.Avg(), 4)
+
+	set.AddValue(math.NaN())
+	assertEq(t, set.Avg(), 5)
+
+	set.AddValue(math.NaN())
+	assertEq(t, set.Avg(), 6)
+
+	set.AddValue(math.NaN())
+	assertEq(t, set.Avg(), math.NaN())
+
+	set.AddValue(1)
+	assertEq(t, set.Avg(), 1)
+}
+
+func TestFifoSetMedian(t *testing.T) {
+	set := newFifoSet(4)
+	assertEq(t, set.Median(), math.NaN())
+
+	set.AddValue(math.NaN())
+	assertEq(t, set.Median(), math.NaN())
+
+	set.AddValue(5)
+	assertEq(t, set.Median(), 5)
+
+	set.AddValue(1)
+	set.AddValue(9)
+	assertEq(t, set.Median(), 5)
+
+	// the NaN drops out of the window, leaving an even number of values
+	set.AddValue(2)
+	assertEq(t, set.Median(), 3.5)
+}
+
+func TestBoundedSeriesSmoothingMeanVsMedian(t *testing.T) {
+	series := NewBoundedSeries(5)
+	for _, v := range []float64{1, 1, 9, 1, 1} {
+		series.AddValue(v)
+	}
+
+	// the average smears the spike across every window it's in, the median drops it
+	assertSliceEq(t, series.SmoothedValues(3), []float64{1, 1, 11.0 / 3, 11.0 / 3, 11.0 / 3})
+	assertSliceEq(t, series.SmoothedValuesMedian(3), []float64{1, 1, 1, 1, 1})
+
+	// a sustained step comes through the median once it's most of the window
+	series.AddValue(9)
+	series.AddValue(9)
+	assertSliceEq(t, series.SmoothedValuesMedian(3), []float64{1, 1, 1, 1, 9})
+	assertSliceEq(t, series.SmoothedValuesMedian(1), series.Values())
+}
+
+func TestBoundedSeriesSmoothingWithNaN(t *testing.T) {
+	series := NewBoundedSeries(4)
+
+	series.AddValue(1)
+	series.AddValue(math.NaN())
+	series.AddValue(3)
+	series.AddValue(5)
+	assertSliceEq(t, series.SmoothedValues(2), []float64{1, 1, 3, 4})
+}
+
+func TestCounterRate(t *testing.T) {
+	var rate counterRate
+	start := time.Unix(1000, 0)
+
+	// the first sample has nothing to compare against
+	_, ok := rate.Rate(4096, start)
+	if ok {
+		t.Errorf("Expected no rate for the first sample")
+	}
+
+	// 8 KiB over half a second is 16 KiB/s
+	r, ok := rate.Rate(4096+8192, start.Add(500*time.Millisecond))
+	if !ok {
+		t.Errorf("Expected a rate for the second sample")
+	}
+	assertEq(t, r/1024, 16)
+
+	// 3 KiB over two seconds
+	r, ok = rate.Rate(4096+8192+3072, start.Add(2500*time.Millisecond))
+	if !ok {
+		t.Errorf("Expected a rate for the third sample")
+	}
+	assertEq(t, r/1024, 1.5)
+
+	// a counter going backwards doesn't produce a huge bogus rate
+	_, ok = rate.Rate(100, start.Add(3*time.Second))
+	if ok {
+		t.Errorf("Expected no rate when the counter goes backwards")
+	}
+
+	r, ok = rate.Rate(1124, start.Add(4*time.Second))
+	if !ok {
+		t.Errorf("Expected a rate after the counter reset")
+	}
+	assertEq(t, r, 1024)
+}
+
+func TestAveragedSeries(t *testing.T) {
+	series := NewAveragedSeries(3, 2)
+
+	// a point is only stored once enough samples have been added, but Last is the latest sample
+	series.AddValue(1)
+	assertSliceEq(t, series.Values(), []float64{})
+	assertEq(t, series.Last(), 1)
+
+	series.AddValue(3)
+	assertSliceEq(t, series.Values(), []float64{2})
+
+	// NaN samples are left out of the average unless the whole point is NaN
+	series.AddValue(math.NaN())
+	series.AddValue(5)
+	series.AddValue(math.NaN())
+	series.AddValue(math.NaN())
+	assertSliceEq(t, series.Values(), []float64{2, 5, math.NaN()})
+	assertEq(t, series.Last(), math.NaN())
+
+	series.AddValue(6)
+	series.AddValue(8)
+	assertSliceEq(t, series.Values(), []float64{5, math.NaN(), 7})
+}
+
+func TestBucketValues(t *testing.T) {
+	// values that already fit are left as they are
+	buckets, size := bucketValues([]float64{1, 2, 3}, 5)
+	assertEq(t, float64(size), 1)
+	assertSliceEq(t, buckets, []float64{1, 2, 3})
+
+	// 7 values into 3 columns is buckets of 3, with the last one short
+	buckets, size = bucketValues([]float64{1, 5, 3, math.NaN(), 4, 2, 8}, 3)
+	assertEq(t, float64(size), 3)
+	assertSliceEq(t, buckets, []float64{3, 3, 8})
+
+	buckets, _ = bucketValues([]float64{math.NaN(), math.NaN(), 1, 1}, 2)
+	assertSliceEq(t, buckets, []float64{math.NaN(), 1})
+}
+
+func TestResetSeries(t *testing.T) {
+	var registry seriesRegistry
+	series := NewAveragedSeries(3, 2)
+	registry.Add("test", series)
+
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		series.AddValue(v)
+	}
+	before := series.Values()
+
+	registry.Reset()
+
+	if values := series.Values(); len(values) != 0 || !math.IsNaN(series.Last()) {
+		t.Errorf("Expected an empty series after the reset but got %v, last %v", values, series.Last())
+	}
+	if before[0] != 1.5 || before[1] != 3.5 {
+		t.Errorf("Expected values from before the reset to be left alone but got %v", before)
+	}
+
+	// the value pending from before the reset isn't averaged into the next point
+	series.AddValue(10)
+	series.AddValue(20)
+	if values := series.Values(); len(values) != 1 || values[0] != 15 {
+		t.Errorf("Expected a single point of 15 after the reset but got %v", values)
+	}
+}
+
+func TestWriteSeriesCSV(t *testing.T) {
+	var registry seriesRegistry
+	long := NewBoundedSeries(4)
+	short := NewBoundedSeries(4)
+	registry.Add("cpuLoad.load1", long)
+	registry.Add("connections.listen", short)
+
+	// charted in bits but saved in the KiB it was sampled in
+	bits := NewBoundedSeries(4)
+	registry.AddScaled("networkIO.sent", bits, 1.0/bitsPerKiB)
+
+	for _, v := range []float64{1, 2.5, math.NaN(), 4} {
+		long.AddValue(v)
+	}
+	short.AddValue(7)
+	bits.AddValue(2 * bitsPerKiB)
+
+	var out strings.Builder
+	if err := registry.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "cpuLoad.load1,connections.listen,networkIO.sent\n1,,\n2.5,,\n,,\n4,7,2\n"
+	if out.String() != expected {
+		t.Errorf("Expected the CSV %q but got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The latest values sampled for each chart, by the name charts are recorded under, e.g. "cpuPerc"
+type metricsSnapshot struct {
+	mu      sync.Mutex
+	metrics map[string]*snapshotMetric
+}
+
+type snapshotMetric struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+func newMetricsSnapshot() *metricsSnapshot {
+	return &metricsSnapshot{metrics: map[string]*snapshotMetric{}}
+}
+
+// Replaces the latest values of the named metric, labelling each value by what it is, see seriesLabels
+func (this *metricsSnapshot) Update(name string, values []float64, now time.Time) {
+	labelled := map[string]float64{}
+	for i, label := range seriesLabels(name, len(values)) {
+		labelled[label] = values[i]
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.metrics[name] = &snapshotMetric{Time: now, Values: labelled}
+}
+
+// Returns a copy of the latest values of every metric
+func (this *metricsSnapshot) Get() map[string]*snapshotMetric {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	metrics := map[string]*snapshotMetric{}
+	for name, metric := range this.metrics {
+		metrics[name] = metric
+	}
+	return metrics
+}
+
+// Passes samples through from the live collector, keeping the latest in the snapshot
+type snapshotCollector struct {
+	name     string
+	live     Collector
+	snapshot *metricsSnapshot
+}
+
+func (this *snapshotCollector) Collect(ctx context.Context) ([]float64, error) {
+	values, err := this.live.Collect(ctx)
+	if err != nil || values == nil {
+		return values, err
+	}
+
+	this.snapshot.Update(this.name, values, time.Now())
+	return values, nil
+}
+
+// What each value collected for a chart is, by the chart's name with any interface and Total suffix
+// dropped, see metricKind. The GPU chart collects these three values for each GPU.
+var metricSeries = map[string][]string{
+	"cpuLoad":          {"load1", "load5", "load15"},
+	"cpuPerc":          {"min", "avg", "max"},
+	"cpuTimes":         {"user", "system", "iowait"},
+	"networkIO":        {"sent", "recv"},
+	"networkIOFamily":  {"v4_sent", "v4_recv", "v6_sent", "v6_recv"},
+	"networkIOPackets": {"sent", "recv"},
+	"networkIOErrors":  {"errors", "drops"},
+	"diskIOPS":         {"read", "write"},
+	"diskIO":           {"read", "write"},
+	"connections":      {"established", "time_wait", "listen"},
+	"gpu":              {"util", "mem_used", "mem_total"},
+	"switches":         {"ctxt", "intr"},
+	"diskLatency":      {"read", "write"},
+	"diskSpace":        {"used", "free"},
+	"fileLimits":       {"handles", "inodes"},
+}
+
+// Returns the kind of chart a metric name is for, so that e.g. "networkIO_eth0Packets" and
+// "networkIOPacketsTotal" are both "networkIOPackets", along with the interface if the chart is
+// for a single network interface
+func metricKind(name string) (string, string) {
+	name = strings.TrimSuffix(name, "Total")
+	iface := strings.TrimPrefix(name, "networkIO_")
+	if iface == name {
+		return name, ""
+	}
+
+	name = "networkIO"
+	for _, suffix := range []string{"Packets", "Errors"} {
+		if strings.HasSuffix(iface, suffix) {
+			name += suffix
+			iface = strings.TrimSuffix(iface, suffix)
+		}
+	}
+	return name, iface
+}
+
+// Returns a label for each of the n values of the named metric, e.g. "sent" and "recv", falling
+// back to the value's index if the metric's values aren't known
+func seriesLabels(name string, n int) []string {
+	kind, _ := metricKind(name)
+	series := metricSeries[kind]
+	labels := make([]string, n)
+	for i := range labels {
+		switch {
+		case kind == "gpu":
+			labels[i] = fmt.Sprintf("gpu%d_%s", i/len(series), series[i%len(series)])
+		case i < len(series) && n == len(series):
+			labels[i] = series[i]
+		default:
+			labels[i] = fmt.Sprint(i)
+		}
+	}
+	return labels
+}
+
+// Serves the snapshot as JSON at /metrics.json and in the Prometheus text format at /metrics
+func metricsHandler(snapshot *metricsSnapshot) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics.json", func(w http.ResponseWriter, r *http.Request) {
+		// JSON has no NaN, so gaps are left out
+		metrics := snapshot.Get()
+		for name, metric := range metrics {
+			values := map[string]float64{}
+			for label, v := range metric.Values {
+				if !math.IsNaN(v) {
+					values[label] = v
+				}
+			}
+			metrics[name] = &snapshotMetric{Time: metric.Time, Values: values}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics)
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, prometheusText(snapshot.Get()))
+	})
+
+	return mux
+}
+
+// A Prometheus series one of a chart's values is exposed as, e.g. poptop_cpu_load{window="1m"}
+type promSeries struct {
+	name   string
+	labels string
+}
+
+// The Prometheus series for each value collected for each kind of chart, see metricKind. Rates are
+// named per second, and when charting cumulative totals the _per_second is swapped for _total.
+var promMetricSeries = map[string][]promSeries{
+	"cpuLoad":          {{"poptop_cpu_load", `window="1m"`}, {"poptop_cpu_load", `window="5m"`}, {"poptop_cpu_load", `window="15m"`}},
+	"cpuPerc":          {{"poptop_cpu_percent", `stat="min"`}, {"poptop_cpu_percent", `stat="avg"`}, {"poptop_cpu_percent", `stat="max"`}},
+	"cpuTimes":         {{"poptop_cpu_time_percent", `mode="user"`}, {"poptop_cpu_time_percent", `mode="system"`}, {"poptop_cpu_time_percent", `mode="iowait"`}},
+	"networkIO":        {{"poptop_network_kibibytes_per_second", `direction="sent"`}, {"poptop_network_kibibytes_per_second", `direction="recv"`}},
+	"networkIOPackets": {{"poptop_network_packets_per_second", `direction="sent"`}, {"poptop_network_packets_per_second", `direction="recv"`}},
+	"networkIOErrors":  {{"poptop_network_errors_per_second", ""}, {"poptop_network_drops_per_second", ""}},
+	"diskIOPS":         {{"poptop_disk_operations_per_second", `direction="read"`}, {"poptop_disk_operations_per_second", `direction="write"`}},
+	"diskIO":           {{"poptop_disk_kibibytes_per_second", `direction="read"`}, {"poptop_disk_kibibytes_per_second", `direction="write"`}},
+	"connections":      {{"poptop_connections", `state="established"`}, {"poptop_connections", `state="time_wait"`}, {"poptop_connections", `state="listen"`}},
+	"gpu":              {{"poptop_gpu_util_percent", ""}, {"poptop_gpu_memory_used_mib", ""}, {"poptop_gpu_memory_total_mib", ""}},
+	"switches":         {{"poptop_context_switches_per_second", ""}, {"poptop_interrupts_per_second", ""}},
+	"diskLatency":      {{"poptop_disk_latency_milliseconds", `direction="read"`}, {"poptop_disk_latency_milliseconds", `direction="write"`}},
+	"diskSpace":        {{"poptop_disk_used_bytes", ""}, {"poptop_disk_free_bytes", ""}},
+	"fileLimits":       {{"poptop_file_handles_percent", ""}, {"poptop_inodes_used_percent", ""}},
+	"networkIOFamily": {
+		{"poptop_network_kibibytes_per_second", `family="ipv4",direction="sent"`},
+		{"poptop_network_kibibytes_per_second", `family="ipv4",direction="recv"`},
+		{"poptop_network_kibibytes_per_second", `family="ipv6",direction="sent"`},
+		{"poptop_network_kibibytes_per_second", `family="ipv6",direction="recv"`},
+	},
+}
+
+var promHelp = map[string]string{
+	"poptop_cpu_load":                     "Load average over each window.",
+	"poptop_cpu_percent":                  "The min, avg and max busy % across CPUs, or idle % with --cpu-idle.",
+	"poptop_cpu_time_percent":             "The % of CPU time spent in each mode across all CPUs.",
+	"poptop_network_kibibytes_per_second": "Network throughput in KiB per second.",
+	"poptop_network_packets_per_second":   "Network packets per second.",
+	"poptop_network_errors_per_second":    "Network errors in and out per second.",
+	"poptop_network_drops_per_second":     "Network packets dropped in and out per second.",
+	"poptop_disk_operations_per_second":   "Disk operations per second.",
+	"poptop_disk_kibibytes_per_second":    "Disk throughput in KiB per second.",
+	"poptop_connections":                  "Network connections in each state.",
+	"poptop_gpu_util_percent":             "GPU utilization %.",
+	"poptop_gpu_memory_used_mib":          "GPU memory used in MiB.",
+	"poptop_gpu_memory_total_mib":         "GPU memory in MiB.",
+	"poptop_context_switches_per_second":  "Context switches per second.",
+	"poptop_interrupts_per_second":        "Interrupts per second.",
+	"poptop_disk_latency_milliseconds":    "Average milliseconds per disk request.",
+	"poptop_disk_used_bytes":              "Bytes used on the Disk Space chart's filesystem.",
+	"poptop_disk_free_bytes":              "Bytes free on the Disk Space chart's filesystem.",
+	"poptop_file_handles_percent":         "Percentage of the system's file handle limit in use.",
+	"poptop_inodes_used_percent":          "Percentage of inodes used on the fullest filesystem.",
+}
+
+// Returns the Prometheus series for value i of the named metric, and whether the value is a
+// cumulative counter rather than a gauge. False if the metric isn't known.
+func promSeriesFor(name string, i int) (promSeries, bool, bool) {
+	kind, iface := metricKind(name)
+	defs := promMetricSeries[kind]
+	if len(defs) == 0 || (kind != "gpu" && i >= len(defs)) {
+		return promSeries{}, false, false
+	}
+
+	series := defs[i%len(defs)]
+	labels := []string{}
+	if kind == "gpu" {
+		labels = append(labels, fmt.Sprintf("gpu=\"%d\"", i/len(defs)))
+	}
+	if iface != "" {
+		labels = append(labels, fmt.Sprintf("interface=%q", iface))
+	}
+	if series.labels != "" {
+		labels = append(labels, series.labels)
+	}
+	series.labels = strings.Join(labels, ",")
+
+	counter := strings.HasSuffix(name, "Total")
+	if counter {
+		series.name = strings.TrimSuffix(series.name, "_per_second") + "_total"
+	}
+	return series, counter, true
+}
+
+// Formats metrics in the Prometheus text exposition format, with each family's HELP and TYPE
+// followed by its samples. Families and samples are sorted so the output is stable.
+func prometheusText(metrics map[string]*snapshotMetric) string {
+	families := map[string][]string{}
+	types := map[string]string{}
+
+	for name, metric := range metrics {
+		labels := seriesLabels(name, len(metric.Values))
+		for i, label := range labels {
+			series, counter, ok := promSeriesFor(name, i)
+			if !ok {
+				// charts we don't have names for are still exposed, labelled by the chart and value
+				series = promSeries{"poptop_sample", fmt.Sprintf("metric=%q,series=%q", name, label)}
+			}
+
+			types[series.name] = "gauge"
+			if counter {
+				types[series.name] = "counter"
+			}
+			sample := series.name
+			if series.labels != "" {
+				sample += "{" + series.labels + "}"
+			}
+			families[series.name] = append(families[series.name], fmt.Sprintf("%s %v", sample, metric.Values[label]))
+		}
+	}
+
+	names := []string{}
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		help, ok := promHelp[name]
+		if rate := strings.TrimSuffix(name, "_total") + "_per_second"; !ok && name != rate {
+			help = strings.Replace(strings.TrimSuffix(promHelp[rate], "."), " per second", "", 1) + ", as a running total since poptop started."
+		}
+		if name == "poptop_sample" {
+			help = "The latest value of each series of charts without a metric of their own."
+		}
+
+		samples := families[name]
+		sort.Strings(samples)
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n%s\n", name, help, name, types[name], strings.Join(samples, "\n"))
+	}
+	return out.String()
+}
+
+// How long to wait for in-flight requests when shutting down the metrics server
+const serveShutdownTimeout = time.Second
+
+// Listens on addr, a TCP address like ":9100" or a Unix socket like "unix:/tmp/poptop.sock", then
+// serves the snapshot until the context is done. Listening happens up front so a bad address is
+// reported before the terminal takes over the screen.
+func (this *PoptopConfig) serveMetrics(ctx context.Context, addr string) error {
+	network := "tcp"
+	if path := strings.TrimPrefix(addr, "unix:"); path != addr {
+		network, addr = "unix", path
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("Could not listen on %s for --serve: %v\n", addr, err)
+	}
+
+	this.serveUntilDone(ctx, listener, metricsHandler(this.snapshot))
+	return nil
+}
+
+// Serves handler on listener until the context is done, then gives in-flight requests a moment to finish
+func (this *PoptopConfig) serveUntilDone(ctx context.Context, listener net.Listener, handler http.Handler) {
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+
+	this.spawn(func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	})
+}
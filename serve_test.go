@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeriesLabels(t *testing.T) {
+	cases := map[string][]string{
+		"cpuPerc":               {"min", "avg", "max"},
+		"networkIO_eth0":        {"sent", "recv"},
+		"networkIO_eth0Packets": {"sent", "recv"},
+		"networkIOErrorsTotal":  {"errors", "drops"},
+		"gpu":                   {"gpu0_util", "gpu0_mem_used", "gpu0_mem_total", "gpu1_util", "gpu1_mem_used", "gpu1_mem_total"},
+		"somethingNew":          {"0", "1"},
+	}
+
+	for name, expected := range cases {
+		labels := seriesLabels(name, len(expected))
+		if strings.Join(labels, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected %s to be labelled %v but got %v", name, expected, labels)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	snapshot := newMetricsSnapshot()
+	live := CollectorFunc(func(ctx context.Context) ([]float64, error) {
+		return []float64{1.5, 1, math.NaN()}, nil
+	})
+	collector := &snapshotCollector{"cpuLoad", live, snapshot}
+	collect(t, collector)
+
+	handler := metricsHandler(snapshot)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics.json", nil))
+
+	var metrics map[string]*snapshotMetric
+	if err := json.Unmarshal(recorder.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("Expected JSON but got %q: %v", recorder.Body.String(), err)
+	}
+	load := metrics["cpuLoad"]
+	if load == nil || load.Values["load1"] != 1.5 || load.Values["load5"] != 1 || time.Since(load.Time) > time.Minute {
+		t.Errorf("Expected the latest load sample but got %+v", load)
+	}
+	if _, ok := load.Values["load15"]; ok {
+		t.Error("Expected the NaN gap to be left out of the JSON")
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE poptop_cpu_load gauge",
+		`poptop_cpu_load{window="1m"} 1.5`,
+		`poptop_cpu_load{window="15m"} NaN`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected the Prometheus output to contain %q but got %q", line, body)
+		}
+	}
+}
+
+func TestPrometheusText(t *testing.T) {
+	snapshot := newMetricsSnapshot()
+	now := time.Now()
+	snapshot.Update("networkIO_eth0", []float64{4, 2}, now)
+	snapshot.Update("networkIO_eth0PacketsTotal", []float64{100, 200}, now)
+	snapshot.Update("gpu", []float64{50, 1024, 8192, 10, 512, 8192}, now)
+	snapshot.Update("somethingNew", []float64{7}, now)
+
+	expected := []string{
+		"# HELP poptop_gpu_memory_total_mib GPU memory in MiB.",
+		"# TYPE poptop_gpu_util_percent gauge",
+		`poptop_gpu_util_percent{gpu="0"} 50`,
+		`poptop_gpu_util_percent{gpu="1"} 10`,
+		`poptop_network_kibibytes_per_second{interface="eth0",direction="sent"} 4`,
+		"# HELP poptop_network_packets_total Network packets, as a running total since poptop started.",
+		"# TYPE poptop_network_packets_total counter",
+		`poptop_network_packets_total{interface="eth0",direction="recv"} 200`,
+		`poptop_sample{metric="somethingNew",series="0"} 7`,
+	}
+	snapshot.Update("networkIOErrors", []float64{1, 0}, now)
+	expected = append(expected, "poptop_network_errors_per_second 1")
+
+	text := prometheusText(snapshot.Get())
+	for _, line := range expected {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Expected the Prometheus output to contain %q but got:\n%s", line, text)
+		}
+	}
+}
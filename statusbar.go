@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// The number of rows the status bar takes up at the bottom of the screen
+const statusBarRows = 1
+
+// A row along the bottom of the screen which shows the current settings, or a toast while one
+// is showing. It sits outside the widget layout, so applyLayout reserves a row for it.
+type statusBar struct {
+	*text.Text
+	current string // the text last written, so it's only rewritten when it changes
+}
+
+func newStatusBar() (*statusBar, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+	return &statusBar{Text: textBox}, nil
+}
+
+// Returns the status bar's place below the widget grid
+func (this *statusBar) container() []container.Option {
+	return []container.Option{container.Border(linestyle.None), container.KeyFocusSkip(), container.PlaceWidget(this)}
+}
+
+// Rewrites the status bar with the toast showing at now if there is one, otherwise the settings.
+// Returns true if that changed what the bar shows.
+func (this *statusBar) Update(config *PoptopConfig, now time.Time) (bool, error) {
+	message := config.toast.current(now)
+	content := "toast:" + message
+	if message == "" {
+		content = statusText(config)
+	}
+	if content == this.current {
+		return false, nil
+	}
+	this.current = content
+
+	this.Text.Reset()
+	if message != "" {
+		return true, this.Text.Write(" "+message, text.WriteCellOpts(cell.FgColor(ColorWidgetTitle), cell.Bold()))
+	}
+	return true, this.Text.Write(content, text.WriteCellOpts(cell.FgColor(ColorChartLabel)))
+}
+
+// Describes the current sample and redraw intervals, chart duration, smoothing, layout and widgets, e.g.
+// " sample 500ms | redraw 500ms | chart 2m0s | smooth 4 | vertical | CPU Load, CPU Percent ", noting first if paused
+func statusText(config *PoptopConfig) string {
+	interval := config.CurrentSampleInterval()
+	redraw := config.RedrawInterval
+	if config.redrawClock != nil {
+		redraw, _ = config.redrawClock.Get()
+	}
+
+	// charts keep the same number of samples as the interval changes, so the duration changes with it
+	duration := config.ChartDuration
+	if config.NumSamples > 0 {
+		duration = time.Duration(config.NumSamples*max(1, config.SamplesPerPoint)) * interval
+	}
+
+	layout := "vertical"
+	if config.SplitHorizontally {
+		layout = "horizontal"
+	}
+	if config.GridCols > 0 {
+		layout = fmt.Sprintf("%dx%d grid", config.GridCols, config.GridRows)
+	} else if config.TileWindows {
+		layout += " tiles"
+	}
+
+	widgets := []string{}
+	for _, widget := range config.Widgets {
+		widgets = append(widgets, widgetNames[widget])
+	}
+
+	smoothing := fmt.Sprintf("smooth %d", config.Smoothing())
+	if config.SmoothMedian {
+		smoothing = fmt.Sprintf("median %d", config.Smoothing())
+	}
+
+	sample := fmt.Sprintf("sample %v", interval)
+	if config.AdaptiveSampling {
+		sample += " (adaptive)"
+	}
+
+	parts := []string{
+		sample,
+		fmt.Sprintf("redraw %v", redraw),
+		fmt.Sprintf("chart %v", duration.Round(time.Second)),
+		smoothing,
+		layout,
+		strings.Join(widgets, ", "),
+	}
+	if config.Paused() {
+		parts = append([]string{"PAUSED (p to resume)"}, parts...)
+	}
+	return " " + strings.Join(parts, " | ")
+}
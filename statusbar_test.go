@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusText(t *testing.T) {
+	config := &PoptopConfig{
+		SampleInterval:   500 * time.Millisecond,
+		RedrawInterval:   250 * time.Millisecond,
+		ChartDuration:    2 * time.Minute,
+		SmoothingSamples: 4,
+		Widgets:          []int{WidgetCPULoad, WidgetTopCPU},
+	}
+
+	expected := " sample 500ms | redraw 250ms | chart 2m0s | smooth 4 | vertical | CPU Load, Top CPU Processes"
+	if text := statusText(config); text != expected {
+		t.Errorf("Expected %q but got %q", expected, text)
+	}
+
+	// the duration follows the sample interval once it's changed at runtime
+	config.Finalize()
+	config.ScaleSampleInterval(2)
+	config.SplitHorizontally = true
+	config.TileWindows = true
+
+	expected = " sample 1s | redraw 250ms | chart 4m0s | smooth 4 | horizontal tiles | CPU Load, Top CPU Processes"
+	if text := statusText(config); text != expected {
+		t.Errorf("Expected %q but got %q", expected, text)
+	}
+}
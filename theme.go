@@ -0,0 +1,148 @@
+package main
+
+import (
+	"image"
+	"sync/atomic"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+// A color theme, which swaps the colors of the default palette for its own. Widgets are built with
+// the default colors and a theme is applied as each cell is drawn to the terminal, so switching
+// themes recolors every widget on the next redraw without rebuilding them or losing their history.
+type theme struct {
+	name string
+
+	// default palette colors to the colors this theme draws them in, colors without an entry are
+	// left alone
+	colors map[cell.Color]cell.Color
+}
+
+// Returns the color this theme draws color in
+func (this *theme) color(color cell.Color) cell.Color {
+	if themed, ok := this.colors[color]; ok {
+		return themed
+	}
+	return color
+}
+
+// The themes, in the order t cycles through them, the first being the default palette
+var themes = []*theme{
+	{name: "default"},
+	{
+		name: "ocean",
+		colors: map[cell.Color]cell.Color{
+			ColorAxis:            cell.ColorNumber(17),
+			ColorWidgetTitle:     cell.ColorNumber(117),
+			ColorHot1:            cell.ColorNumber(51),
+			ColorHot2:            cell.ColorNumber(75),
+			ColorHot3:            cell.ColorNumber(33),
+			ColorHot4:            cell.ColorNumber(122),
+			ColorError:           cell.ColorNumber(201),
+			dimColors[ColorHot1]: cell.ColorNumber(30),
+			dimColors[ColorHot2]: cell.ColorNumber(25),
+			dimColors[ColorHot3]: cell.ColorNumber(18),
+			dimColors[ColorHot4]: cell.ColorNumber(23),
+		},
+	},
+	{
+		name: "ember",
+		colors: map[cell.Color]cell.Color{
+			ColorWidgetTitle:     cell.ColorNumber(208),
+			ColorHot1:            cell.ColorNumber(202),
+			ColorHot2:            cell.ColorNumber(220),
+			ColorHot3:            cell.ColorNumber(166),
+			ColorHot4:            cell.ColorNumber(229),
+			ColorError:           cell.ColorNumber(201),
+			dimColors[ColorHot1]: cell.ColorNumber(88),
+			dimColors[ColorHot2]: cell.ColorNumber(136),
+			dimColors[ColorHot3]: cell.ColorNumber(94),
+			dimColors[ColorHot4]: cell.ColorNumber(101),
+		},
+	},
+	{
+		// for terminals with a light background, where the default palette's bright colors wash
+		// out, so series are darker and dimmed series are paler rather than darker
+		name: "light",
+		colors: map[cell.Color]cell.Color{
+			ColorAxis:            cell.ColorNumber(250),
+			ColorChartLabel:      cell.ColorNumber(241),
+			ColorWidgetBorder:    cell.ColorNumber(248),
+			ColorWidgetTitle:     cell.ColorNumber(30),
+			ColorHot1:            cell.ColorNumber(161),
+			ColorHot2:            cell.ColorNumber(166),
+			ColorHot3:            cell.ColorNumber(26),
+			ColorHot4:            cell.ColorNumber(28),
+			ColorError:           cell.ColorNumber(160),
+			dimColors[ColorHot1]: cell.ColorNumber(218),
+			dimColors[ColorHot2]: cell.ColorNumber(223),
+			dimColors[ColorHot3]: cell.ColorNumber(153),
+			dimColors[ColorHot4]: cell.ColorNumber(157),
+		},
+	},
+	{
+		// errors stay red so they still stand out
+		name: "mono",
+		colors: map[cell.Color]cell.Color{
+			ColorAxis:            cell.ColorNumber(238),
+			ColorWidgetTitle:     cell.ColorNumber(255),
+			ColorHot1:            cell.ColorNumber(255),
+			ColorHot2:            cell.ColorNumber(250),
+			ColorHot3:            cell.ColorNumber(245),
+			ColorHot4:            cell.ColorNumber(241),
+			dimColors[ColorHot1]: cell.ColorNumber(240),
+			dimColors[ColorHot2]: cell.ColorNumber(239),
+			dimColors[ColorHot3]: cell.ColorNumber(237),
+			dimColors[ColorHot4]: cell.ColorNumber(236),
+		},
+	},
+}
+
+// Returns the names of the themes in order, for the --theme flag's help and errors
+func themeNames() []string {
+	names := []string{}
+	for _, theme := range themes {
+		names = append(names, theme.name)
+	}
+	return names
+}
+
+// A terminal which draws every cell in the colors of the current theme. The theme can be changed
+// at any time, e.g. from the key handler while the controller is redrawing.
+type themedTerminal struct {
+	terminalapi.Terminal
+	current int32 // index into themes, accessed atomically
+}
+
+func newThemedTerminal(terminal terminalapi.Terminal, theme int) *themedTerminal {
+	return &themedTerminal{Terminal: terminal, current: int32(theme)}
+}
+
+// Returns the theme cells are currently drawn in
+func (this *themedTerminal) Theme() *theme {
+	return themes[atomic.LoadInt32(&this.current)]
+}
+
+// Switches to the next theme, wrapping around to the default after the last, and returns it
+func (this *themedTerminal) NextTheme() *theme {
+	next := (atomic.LoadInt32(&this.current) + 1) % int32(len(themes))
+	atomic.StoreInt32(&this.current, next)
+	return themes[next]
+}
+
+func (this *themedTerminal) SetCell(p image.Point, r rune, opts ...cell.Option) error {
+	return this.Terminal.SetCell(p, r, this.Theme().cellOptions(opts))
+}
+
+func (this *themedTerminal) Clear(opts ...cell.Option) error {
+	return this.Terminal.Clear(this.Theme().cellOptions(opts))
+}
+
+// Returns the cell options with their colors swapped for this theme's
+func (this *theme) cellOptions(opts []cell.Option) cell.Option {
+	options := cell.NewOptions(opts...)
+	options.FgColor = this.color(options.FgColor)
+	options.BgColor = this.color(options.BgColor)
+	return options
+}
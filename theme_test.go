@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mum4k/termdash/cell"
+)
+
+func TestThemeCellOptions(t *testing.T) {
+	ocean := themes[find(themeNames(), "ocean")]
+	options := cell.NewOptions()
+	ocean.cellOptions([]cell.Option{cell.FgColor(ColorHot1), cell.BgColor(cell.ColorNumber(34)), cell.Bold()}).Set(options)
+
+	if options.FgColor != ocean.colors[ColorHot1] || options.BgColor != cell.ColorNumber(34) || !options.Bold {
+		t.Errorf("Expected the hot color to be swapped with the rest left alone but got %+v", options)
+	}
+
+	// the default theme draws everything as it was built
+	themes[0].cellOptions([]cell.Option{cell.FgColor(ColorHot1)}).Set(options)
+	if options.FgColor != ColorHot1 {
+		t.Errorf("Expected the default theme to keep the hot color but got %v", options.FgColor)
+	}
+}
+
+func TestNextTheme(t *testing.T) {
+	terminal := newThemedTerminal(nil, len(themes)-2)
+
+	if theme := terminal.NextTheme(); theme != themes[len(themes)-1] || terminal.Theme() != theme {
+		t.Errorf("Expected the last theme but got %s", theme.name)
+	}
+	if theme := terminal.NextTheme(); theme != themes[0] {
+		t.Errorf("Expected to wrap around to the default theme but got %s", theme.name)
+	}
+}
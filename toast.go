@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// How long a toast stays on screen
+const toastDuration = 3 * time.Second
+
+// A brief message shown in the status bar, e.g. to list the valid keys when one which does nothing
+// is pressed. If the status bar is turned off the layout makes room for it while the toast is showing,
+// and is re-applied once the drawn message is stale so the toast goes away when it expires.
+type toast struct {
+	mu      sync.Mutex
+	message string
+	expires time.Time
+	drawn   string // the message the layout last drew, empty if none
+}
+
+// Shows message for toastDuration from now, replacing any toast already showing
+func (this *toast) Show(message string, now time.Time) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.message = message
+	this.expires = now.Add(toastDuration)
+}
+
+// Returns the message which should be showing at now, empty if there is none
+func (this *toast) current(now time.Time) string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.currentLocked(now)
+}
+
+func (this *toast) currentLocked(now time.Time) string {
+	if now.Before(this.expires) {
+		return this.message
+	}
+	return ""
+}
+
+// Returns the message for the layout to draw, empty if there is none, and notes that it was drawn
+func (this *toast) Draw(now time.Time) string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.drawn = this.currentLocked(now)
+	return this.drawn
+}
+
+// Returns true if the layout needs re-applying because the drawn message has expired or been replaced
+func (this *toast) Stale(now time.Time) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.drawn != this.currentLocked(now)
+}
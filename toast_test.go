@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToast(t *testing.T) {
+	now := time.Now()
+	var toast toast
+
+	if toast.Stale(now) || toast.Draw(now) != "" {
+		t.Error("Expected no toast before one is shown")
+	}
+
+	toast.Show("hello", now)
+	if !toast.Stale(now) {
+		t.Error("Expected the layout to be stale once a toast is shown")
+	}
+	if message := toast.Draw(now); message != "hello" {
+		t.Errorf("Expected to draw hello but got %q", message)
+	}
+	if toast.Stale(now.Add(toastDuration / 2)) {
+		t.Error("Expected the drawn toast to be current until it expires")
+	}
+
+	expired := now.Add(toastDuration)
+	if !toast.Stale(expired) {
+		t.Error("Expected the layout to be stale once the toast expires")
+	}
+	if message := toast.Draw(expired); message != "" || toast.Stale(expired) {
+		t.Errorf("Expected nothing to draw once expired but got %q", message)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Returns a warning for each external tool the enabled widgets would use which isn't installed,
+// so it's clear why a widget is blank or slower than usual. Every widget copes without its tool,
+// e.g. the GPU chart says nvidia-smi is missing, but that's easy to miss at a glance.
+func missingTools(ctx context.Context, config *PoptopConfig) []string {
+	enabled := map[int]bool{}
+	for _, widget := range config.Widgets {
+		enabled[widget] = true
+	}
+	missing := func(tool string) bool {
+		_, err := exec.LookPath(tool)
+		return err != nil
+	}
+
+	warnings := []string{}
+
+	if (enabled[WidgetTopCPU] || enabled[WidgetTopMem]) && runtime.GOOS != "windows" && missing("ps") {
+		warnings = append(warnings, "ps isn't in your PATH, so the top process lists are read through the slower system APIs instead.")
+	}
+
+	if enabled[WidgetTopNet] && missing(nethogs) {
+		warnings = append(warnings, fmt.Sprintf("%s isn't in your PATH, so there's no Top Network Processes list.", nethogs))
+	}
+
+	// replayed charts don't sample the live system so don't need their tools
+	if config.ReplayPath != "" || config.Stdin || config.RemoteHost != "" {
+		return warnings
+	}
+
+	if enabled[WidgetGPU] && missing(nvidiaSmi) {
+		warnings = append(warnings, fmt.Sprintf("%s isn't in your PATH, so there are no GPU metrics, only NVIDIA GPUs are supported.", nvidiaSmi))
+	}
+
+	// see chooseDiskSource, MacOS builds without cgo can only read the disk counters through iostat
+	if (enabled[WidgetDiskIOPS] || enabled[WidgetDiskIO]) && runtime.GOOS == "darwin" && missing("iostat") {
+		if stats, err := disk.IOCountersWithContext(ctx); err != nil || len(stats) == 0 {
+			warnings = append(warnings, "This build can't read the disk counters and iostat isn't in your PATH, so the disk charts will stay empty.")
+		}
+	}
+
+	return warnings
+}
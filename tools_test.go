@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMissingTools(t *testing.T) {
+	t.Setenv("PATH", "")
+	config := &PoptopConfig{Widgets: []int{WidgetCPULoad, WidgetTopCPU, WidgetGPU, WidgetTopNet}}
+
+	warnings := strings.Join(missingTools(context.Background(), config), "\n")
+	if !strings.Contains(warnings, nvidiaSmi) {
+		t.Errorf("Expected a warning that nvidia-smi is missing but got %q", warnings)
+	}
+	if !strings.Contains(warnings, nethogs) {
+		t.Errorf("Expected a warning that nethogs is missing but got %q", warnings)
+	}
+	if runtime.GOOS != "windows" && !strings.Contains(warnings, "ps isn't in your PATH") {
+		t.Errorf("Expected a warning that ps is missing but got %q", warnings)
+	}
+
+	config.Widgets = []int{WidgetCPULoad}
+	if warnings := missingTools(context.Background(), config); len(warnings) != 0 {
+		t.Errorf("Expected no warnings when no widget needs a tool but got %v", warnings)
+	}
+}
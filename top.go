@@ -2,78 +2,367 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mum4k/termdash/cell"
 	"github.com/mum4k/termdash/container"
 	"github.com/mum4k/termdash/widgets/text"
+	"github.com/shirou/gopsutil/v3/process"
 )
 
 // Initializes both a top CPU and top memory box
 // We do these together because they depend on the same call to `ps`
-func newTopBoxes(ctx context.Context, config *PoptopConfig) ([]container.Option, []container.Option, error) {
-	cpuTextBox, err := text.New()
+func newTopBoxes(ctx context.Context, root *container.Container, config *PoptopConfig) ([]container.Option, []container.Option, error) {
+	cpuTextBox, err := newScrollText()
 	if err != nil {
 		return nil, nil, err
 	}
-	memTextBox, err := text.New()
+	memTextBox, err := newScrollText()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Sample top less frequently than configured for other charts because it's a point-in-time measure
-	interval := config.SampleInterval * 4
+	cpuOpts, setCpuTitle := makeDynamicContainer(root, "topCpu", cpuTextBox, topTitle("CPU", config.TopSort(WidgetTopCPU), sortByCpu), config, WidgetTopCPU)
+	memOpts, setMemTitle := makeDynamicContainer(root, "topMem", memTextBox, topTitle("Memory", config.TopSort(WidgetTopMem), sortByMem), config, WidgetTopMem)
 
-	go periodic(ctx, interval, func() error {
-		topCpu, topMem, err := topProcesses(ctx, config)
-		if err != nil {
-			return err
+	boxes := &topBoxes{config: config, cpuTextBox: cpuTextBox, memTextBox: memTextBox, setCpuTitle: setCpuTitle, setMemTitle: setMemTitle}
+
+	config.goPeriodic(ctx, config.TopInterval, config.toastError, func() error {
+		// keep showing the processes as they were when sampling was paused
+		if config.Paused() {
+			return nil
 		}
+		return boxes.Refresh(ctx)
+	})
 
-		lines := []string{}
-		for _, proc := range topCpu {
-			lineItem := fmt.Sprintf("%3.0f%%  %-5d  %s\n", proc.CpuPerc, proc.Pid, proc.Command)
-			lines = append(lines, lineItem)
+	// redraw from the last ps output as the filter is typed rather than waiting for the next refresh
+	config.spawn(func() {
+		for {
+			_, _, changed := config.topFilter.Get()
+			select {
+			case <-changed:
+				boxes.Render()
+				config.markChanged()
+			case <-ctx.Done():
+				return
+			}
 		}
+	})
+
+	return cpuOpts, memOpts, nil
+}
+
+// Returns the title of the top list called name, which says what it's sorted by if that's been
+// changed from its usual sort, e.g. " Top CPU Processes by memory (%, pid, command) "
+func topTitle(name string, sort, usual *topSort) *cell.RichTextString {
+	by := ""
+	if sort != usual {
+		by = " by " + sort.title
+	}
+
+	return cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(fmt.Sprintf(" Top %s Processes%s (%%, pid, command) ", name, by))
+}
+
+// The top CPU and memory text boxes along with the most recent ps output, which we keep so
+// the boxes can be redrawn when the filter changes. The boxes list every process and can be scrolled.
+type topBoxes struct {
+	config      *PoptopConfig
+	cpuTextBox  *scrollText
+	memTextBox  *scrollText
+	setCpuTitle func(*cell.RichTextString)
+	setMemTitle func(*cell.RichTextString)
+
+	mu    sync.Mutex
+	procs []*PsProcess
+	err   error
+
+	// the processes which have lately entered the top of each list
+	cpuNewcomers newcomers
+	memNewcomers newcomers
+}
+
+// Runs ps and redraws the boxes. If ps fails we show the error in the boxes and try again next time,
+// unless the context was cancelled because we're shutting down, in which case the error is returned
+// so periodic can exit cleanly.
+func (this *topBoxes) Refresh(ctx context.Context) error {
+	procs, err := GetPsProcesses(ctx, this.config.FullCommand, this.config.CommandTimeout)
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	this.mu.Lock()
+	this.procs, this.err = procs, err
+	if err == nil {
+		// unfiltered so typing a filter doesn't highlight everything it brings into view
+		topCpu, topMem := rankProcesses(this.config, procs, "", this.config.TopRowsShown)
+		now, grouped := time.Now(), this.config.GroupProcesses.Load()
+		this.cpuNewcomers.Update(topCpu, this.config.TopSort(WidgetTopCPU), grouped, now)
+		this.memNewcomers.Update(topMem, this.config.TopSort(WidgetTopMem), grouped, now)
+	}
+	this.mu.Unlock()
+
+	this.Render()
+	return nil
+}
+
+// Writes the top processes by CPU and memory from the last ps output into the text boxes, along
+// with the filter if one is set
+func (this *topBoxes) Render() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.err != nil {
+		message := fmt.Sprintf(" Couldn't list processes: %v", this.err)
+		this.cpuTextBox.SetLines([]*topLine{{text: message}}, nil)
+		this.memTextBox.SetLines([]*topLine{{text: message}}, nil)
+		return
+	}
 
-		fullText := strings.Join(lines, "")
-		cpuTextBox.Write(fullText, text.WriteReplace())
+	filter, typing, _ := this.config.topFilter.Get()
+	header := ""
+	if typing {
+		header = fmt.Sprintf(" Filter: %s_\n", filter)
+	} else if filter != "" {
+		header = fmt.Sprintf(" Filter: %s (Esc to clear)\n", filter)
+	}
 
-		lines = []string{}
-		for _, proc := range topMem {
-			lineItem := fmt.Sprintf("%3.0f%%  %-5d  %s\n", proc.MemPerc, proc.Pid, proc.Command)
-			lines = append(lines, lineItem)
+	topCpu, topMem := rankProcesses(this.config, this.procs, filter, len(this.procs))
+
+	// the sorts can be swapped at runtime so the titles follow them
+	cpuSort, memSort := this.config.TopSort(WidgetTopCPU), this.config.TopSort(WidgetTopMem)
+	this.setCpuTitle(topTitle("CPU", cpuSort, sortByCpu))
+	this.setMemTitle(topTitle("Memory", memSort, sortByMem))
+
+	now, highlight := time.Now(), this.config.NewProcessHighlight
+	isNewCpu := func(proc *PsProcess) bool { return this.cpuNewcomers.IsNew(proc, now, highlight) }
+	isNewMem := func(proc *PsProcess) bool { return this.memNewcomers.IsNew(proc, now, highlight) }
+
+	this.cpuTextBox.SetLines(topListLines(header, topCpu, cpuSort.column, cpuSort.perc, cpuSort.hotThreshold(this.config), isNewCpu))
+	this.memTextBox.SetLines(topListLines(header, topMem, memSort.column, memSort.perc, memSort.hotThreshold(this.config), isNewMem))
+}
+
+// Lays out a top list as the header and column names, which stay put when scrolling, and a row per
+// process, marking the rows of processes at or above the hot threshold and those isNew picks out
+func topListLines(header string, procs []*PsProcess, percName string, perc func(*PsProcess) float64, hotThreshold float64, isNew func(*PsProcess) bool) ([]*topLine, []*topLine) {
+	formatted := formatTopRows(procs, percName, perc)
+
+	rows := []*topLine{}
+	for i, proc := range procs {
+		rows = append(rows, &topLine{text: formatted[i+1], hot: isHot(perc(proc), hotThreshold), new: isNew(proc)})
+	}
+	return []*topLine{{text: header}, {text: formatted[0]}}, rows
+}
+
+// A line of a top list, hot lines are drawn in ColorHot1 and new lines in ColorNewProcess
+type topLine struct {
+	text string
+	hot  bool
+	new  bool
+}
+
+// Tracks which processes have entered the top rows of a list, so their rows can be highlighted
+// for a while after. There are no newcomers on the first refresh, nor when the list's sort or
+// grouping changes, as every process would be new.
+type newcomers struct {
+	sort     *topSort
+	grouped  bool
+	previous map[string]bool      // the processes in the top rows at the last refresh
+	entered  map[string]time.Time // when each process not there the refresh before entered the top rows
+}
+
+// Notes the processes now in the top rows of a list sorted by the given sort, grouped by command if grouped is set
+func (this *newcomers) Update(top []*PsProcess, by *topSort, grouped bool, now time.Time) {
+	current := map[string]bool{}
+	for _, proc := range top {
+		current[proc.key()] = true
+	}
+
+	entered := map[string]time.Time{}
+	if this.previous != nil && by == this.sort && grouped == this.grouped {
+		for key := range current {
+			if since, ok := this.entered[key]; ok {
+				entered[key] = since
+			} else if !this.previous[key] {
+				entered[key] = now
+			}
 		}
+	}
 
-		fullText = strings.Join(lines, "")
-		memTextBox.Write(fullText, text.WriteReplace())
+	this.sort, this.grouped, this.previous, this.entered = by, grouped, current, entered
+}
 
-		return nil
-	})
+// Returns true if the process entered the top rows less than highlight ago
+func (this *newcomers) IsNew(proc *PsProcess, now time.Time, highlight time.Duration) bool {
+	since, ok := this.entered[proc.key()]
+	return ok && now.Sub(since) < highlight
+}
 
-	cpuTitle := cell.NewRichTextString(ColorWidgetTitle).
-		AddOpt(cell.Bold()).
-		AddText(" Top CPU Processes (%, pid, command) ")
+// Identifies a process across ps runs, grouped processes have no pid so are known by their command
+func (this *PsProcess) key() string {
+	if this.Instances > 0 {
+		return this.Command
+	}
+	return fmt.Sprintf("%d %s", this.Pid, this.Command)
+}
 
-	memTitle := cell.NewRichTextString(ColorWidgetTitle).
-		AddOpt(cell.Bold()).
-		AddText(" Top Memory Processes (%, pid, command) ")
+// Returns true if a process using perc % should be highlighted, a threshold of 0 never highlights
+func isHot(perc, threshold float64) bool {
+	return threshold > 0 && perc >= threshold
+}
 
-	cpuOpts := makeContainer(cpuTextBox, cpuTitle)
-	memOpts := makeContainer(memTextBox, memTitle)
+// Replaces the contents of the text box with the lines. The first write replaces the old
+// contents rather than resetting the box first, so we never draw a half written list.
+func writeTopLines(textBox *text.Text, lines []*topLine) {
+	replace := true
+	for _, line := range lines {
+		if line.text == "" {
+			continue
+		}
 
-	return cpuOpts, memOpts, nil
+		opts := []text.WriteOption{}
+		if line.new {
+			opts = append(opts, text.WriteCellOpts(cell.FgColor(ColorNewProcess), cell.Bold()))
+		} else if line.hot {
+			opts = append(opts, text.WriteCellOpts(cell.FgColor(ColorHot1)))
+		}
+		if replace {
+			opts = append(opts, text.WriteReplace())
+			replace = false
+		}
+		textBox.Write(line.text, opts...)
+	}
+
+	// nothing matched the filter
+	if replace {
+		textBox.Reset()
+	}
+}
+
+// The text the top lists are filtered by, which is typed in at runtime after pressing /
+type processFilter struct {
+	mu      sync.Mutex
+	text    string
+	typing  bool
+	changed chan struct{} // closed and replaced each time the filter changes
+}
+
+func newProcessFilter() *processFilter {
+	return &processFilter{changed: make(chan struct{})}
+}
+
+// Returns the filter text, whether it's still being typed, and a channel which is closed when it next changes.
+// A nil filter has no text and never changes.
+func (this *processFilter) Get() (string, bool, <-chan struct{}) {
+	if this == nil {
+		return "", false, nil
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.text, this.typing, this.changed
+}
+
+func (this *processFilter) Set(text string, typing bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.text = text
+	this.typing = typing
+	close(this.changed)
+	this.changed = make(chan struct{})
+}
+
+// Returns the processes whose command or user contains the filter text, ignoring case
+func filterProcesses(procs []*PsProcess, filter string) []*PsProcess {
+	if filter == "" {
+		return procs
+	}
+
+	filter = strings.ToLower(filter)
+	filtered := []*PsProcess{}
+	for _, proc := range procs {
+		if strings.Contains(strings.ToLower(proc.Command), filter) || strings.Contains(strings.ToLower(proc.User), filter) {
+			filtered = append(filtered, proc)
+		}
+	}
+
+	return filtered
+}
+
+// Formats a top list as a header row followed by a row per process. The percent and pid columns
+// are right aligned and sized to their widest value, so commands line up even when a process
+// uses 100% or more CPU or has a long pid. Grouped processes show the number of instances as e.g. x12.
+func formatTopRows(procs []*PsProcess, percName string, perc func(*PsProcess) float64) []string {
+	percs := make([]string, len(procs))
+	pids := make([]string, len(procs))
+	percWidth, pidWidth := len(percName), len("PID")
+
+	for i, proc := range procs {
+		percs[i] = fmt.Sprintf("%.0f%%", perc(proc))
+		pids[i] = strconv.Itoa(proc.Pid)
+		if proc.Instances > 0 {
+			pids[i] = fmt.Sprintf("x%d", proc.Instances)
+		}
+
+		percWidth = max(percWidth, len(percs[i]))
+		pidWidth = max(pidWidth, len(pids[i]))
+	}
+
+	rows := []string{fmt.Sprintf("%*s  %*s  %s\n", percWidth, percName, pidWidth, "PID", "COMMAND")}
+	for i, proc := range procs {
+		rows = append(rows, fmt.Sprintf("%*s  %*s  %s\n", percWidth, percs[i], pidWidth, pids[i], proc.Command))
+	}
+	return rows
+}
+
+func cpuPerc(proc *PsProcess) float64 {
+	return proc.CpuPerc
+}
+
+func memPerc(proc *PsProcess) float64 {
+	return proc.MemPerc
+}
+
+// What a top list is sorted by, which is also the percentage the list shows
+type topSort struct {
+	name   string // as given to the sort-top flag
+	title  string
+	column string
+	perc   func(*PsProcess) float64
+}
+
+var (
+	sortByCpu = &topSort{"cpu", "CPU", "%CPU", cpuPerc}
+	sortByMem = &topSort{"mem", "memory", "%MEM", memPerc}
+
+	topSorts = []*topSort{sortByCpu, sortByMem}
+)
+
+// Returns the percentage at or above which rows of a list with this sort are highlighted
+func (this *topSort) hotThreshold(config *PoptopConfig) float64 {
+	if this == sortByMem {
+		return config.HotMemPerc
+	}
+	return config.HotCpuPerc
 }
 
 type PsProcess struct {
-	User    string
-	Pid     int
-	CpuPerc float64
-	MemPerc float64
-	Command string
+	User    string  `json:"user"`
+	Pid     int     `json:"pid"`
+	CpuPerc float64 `json:"cpu_perc"`
+	MemPerc float64 `json:"mem_perc"`
+	Command string  `json:"command"`
+
+	// How many processes were summed into this one when grouping by command, 0 if not grouped
+	Instances int `json:"instances,omitempty"`
 }
 
 func parsePerc(field string) (float64, error) {
@@ -82,76 +371,114 @@ func parsePerc(field string) (float64, error) {
 	return strconv.ParseFloat(cleanField, 64)
 }
 
-func GetPsProcesses(ctx context.Context) ([]*PsProcess, error) {
-	args := []string{"auxc"}
-	out, err := commandWithContext(ctx, "ps", args...)
+func (this *PsProcess) String() string {
+	return fmt.Sprintf("%s,%d,%f,%f,%s\n", this.User, this.Pid, this.CpuPerc, this.MemPerc, this.Command)
+}
+
+// Collapses processes with the same command into a single process, summing their CPU% and memory%,
+// e.g. all browser helper processes become one row. Groups keep the order their command first appears in.
+func groupProcesses(procs []*PsProcess) []*PsProcess {
+	groups := []*PsProcess{}
+	byCommand := map[string]*PsProcess{}
+
+	for _, proc := range procs {
+		group, ok := byCommand[proc.Command]
+		if !ok {
+			group = &PsProcess{User: proc.User, Command: proc.Command}
+			byCommand[proc.Command] = group
+			groups = append(groups, group)
+		}
+
+		group.CpuPerc += proc.CpuPerc
+		group.MemPerc += proc.MemPerc
+		group.Instances++
+	}
+
+	return groups
+}
+
+// Lists processes with gopsutil rather than ps, for Windows which has no ps and systems without it
+// installed. This is slower as it makes several calls per process. Like ps the CPU % is averaged
+// over each process's lifetime. Processes we aren't permitted to inspect, or which exit while we're
+// listing them, are left out.
+func gopsutilProcesses(ctx context.Context, fullCommand bool) ([]*PsProcess, error) {
+	procs, err := process.ProcessesWithContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	lines := strings.Split(string(out), "\n")
 	processes := []*PsProcess{}
-
-	for _, line := range lines[1:] {
-		fields := strings.Fields(line)
-		if len(fields) < 10 {
-			break
+	for _, proc := range procs {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 
-		cmd := strings.Join(fields[10:], " ")
-		pid, err := strconv.Atoi(fields[1])
-		if err != nil {
-			return nil, err
+		var cmd string
+		if fullCommand {
+			cmd, err = proc.CmdlineWithContext(ctx)
+		} else {
+			cmd, err = proc.NameWithContext(ctx)
+		}
+		if err != nil || cmd == "" {
+			continue
 		}
 
-		cpuPerc, err := parsePerc(fields[2])
+		cpuPerc, err := proc.CPUPercentWithContext(ctx)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		memPerc, err := parsePerc(fields[3])
+		memPerc, err := proc.MemoryPercentWithContext(ctx)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		process := &PsProcess{
-			User:    fields[0],
-			Pid:     pid,
+		// other users' processes usually hide their owner
+		user, _ := proc.UsernameWithContext(ctx)
+
+		processes = append(processes, &PsProcess{
+			User:    user,
+			Pid:     int(proc.Pid),
 			CpuPerc: cpuPerc,
-			MemPerc: memPerc,
+			MemPerc: float64(memPerc),
 			Command: cmd,
-		}
-
-		processes = append(processes, process)
+		})
 	}
 
 	return processes, nil
 }
 
-func (this *PsProcess) String() string {
-	return fmt.Sprintf("%s,%d,%f,%f,%s\n", this.User, this.Pid, this.CpuPerc, this.MemPerc, this.Command)
-}
-
 // Create CPU and Memory top lists using output from a shared ps command execution.
 func topProcesses(ctx context.Context, config *PoptopConfig) ([]*PsProcess, []*PsProcess, error) {
-	procs, err := GetPsProcesses(ctx)
+	procs, err := GetPsProcesses(ctx, config.FullCommand, config.CommandTimeout)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	sort.Slice(procs, func(i, j int) bool {
-		return procs[i].CpuPerc > procs[j].CpuPerc
-	})
+	filter, _, _ := config.topFilter.Get()
+	topCpu, topMem := rankProcesses(config, procs, filter, config.TopRowsShown)
+	return topCpu, topMem, nil
+}
 
-	procsByCpu := make([]*PsProcess, min(config.TopRowsShown, len(procs)))
-	copy(procsByCpu, procs)
+// Sorts processes into CPU and Memory top lists of at most n processes, keeping only those matching
+// the filter. Each list is sorted as configured, which is by its own metric unless it's been changed.
+// If configured, processes are grouped by command before sorting.
+func rankProcesses(config *PoptopConfig, procs []*PsProcess, filter string, n int) ([]*PsProcess, []*PsProcess) {
+	procs = filterProcesses(procs, filter)
 
-	sort.Slice(procs, func(i, j int) bool {
-		return procs[i].MemPerc > procs[j].MemPerc
-	})
+	if config.GroupProcesses.Load() {
+		procs = groupProcesses(procs)
+	}
 
-	procsByMem := make([]*PsProcess, min(config.TopRowsShown, len(procs)))
-	copy(procsByMem, procs)
+	return sortProcesses(procs, config.TopSort(WidgetTopCPU), n), sortProcesses(procs, config.TopSort(WidgetTopMem), n)
+}
+
+// Returns the top n processes by the sort, without reordering procs
+func sortProcesses(procs []*PsProcess, by *topSort, n int) []*PsProcess {
+	sorted := append([]*PsProcess{}, procs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return by.perc(sorted[i]) > by.perc(sorted[j])
+	})
 
-	return procsByCpu, procsByMem, nil
+	return sorted[:min(n, len(sorted))]
 }
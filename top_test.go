@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/mouse"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+)
+
+func TestTopProcessesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	config := &PoptopConfig{TopRowsShown: 5}
+	if _, _, err := topProcesses(ctx, config); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled but got %v", err)
+	}
+
+	cpuTextBox, err := newScrollText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memTextBox, err := newScrollText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := &topBoxes{config: config, cpuTextBox: cpuTextBox, memTextBox: memTextBox}
+	err = boxes.Refresh(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled but got %v", err)
+	}
+
+	// periodic treats cancellation as a clean exit rather than an error
+	periodicCtx, periodicCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer periodicCancel()
+	err = periodic(periodicCtx, 10*time.Millisecond, func() error {
+		return boxes.Refresh(ctx)
+	})
+	if err != nil {
+		t.Errorf("Expected periodic to exit cleanly but got %v", err)
+	}
+}
+
+func TestGroupProcesses(t *testing.T) {
+	procs := []*PsProcess{
+		{User: "me", Pid: 10, CpuPerc: 5, MemPerc: 1, Command: "chrome"},
+		{User: "me", Pid: 11, CpuPerc: 2, MemPerc: 0.5, Command: "bash"},
+		{User: "me", Pid: 12, CpuPerc: 3, MemPerc: 2, Command: "chrome"},
+		{User: "me", Pid: 13, CpuPerc: 1.5, MemPerc: 4, Command: "chrome"},
+	}
+
+	groups := groupProcesses(procs)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups but got %d", len(groups))
+	}
+
+	if groups[0].Command != "chrome" || groups[0].Instances != 3 {
+		t.Errorf("Expected 3 chrome instances but got %d %s", groups[0].Instances, groups[0].Command)
+	}
+	assertEq(t, groups[0].CpuPerc, 9.5)
+	assertEq(t, groups[0].MemPerc, 7)
+
+	if groups[1].Command != "bash" || groups[1].Instances != 1 {
+		t.Errorf("Expected 1 bash instance but got %d %s", groups[1].Instances, groups[1].Command)
+	}
+	assertEq(t, groups[1].CpuPerc, 2)
+	assertEq(t, groups[1].MemPerc, 0.5)
+
+	if rows := formatTopRows(groups[:1], "%CPU", cpuPerc); rows[1] != " 10%   x3  chrome\n" {
+		t.Errorf("Unexpected grouped row %q", rows[1])
+	}
+}
+
+// Returns the pid of each process in order, to check the order processes are ranked or filtered in
+func pids(procs []*PsProcess) []float64 {
+	values := []float64{}
+	for _, proc := range procs {
+		values = append(values, float64(proc.Pid))
+	}
+	return values
+}
+
+func TestRankProcessesSorts(t *testing.T) {
+	procs := []*PsProcess{
+		{Pid: 1, CpuPerc: 5, MemPerc: 1},
+		{Pid: 2, CpuPerc: 1, MemPerc: 9},
+		{Pid: 3, CpuPerc: 3, MemPerc: 4},
+	}
+	config := &PoptopConfig{}
+	topCpu, topMem := rankProcesses(config, procs, "", 2)
+	if fmt.Sprint(pids(topCpu)) != "[1 3]" || fmt.Sprint(pids(topMem)) != "[2 3]" {
+		t.Errorf("Expected each list sorted by its own metric but got %v and %v", pids(topCpu), pids(topMem))
+	}
+
+	config.TopSorts = map[int]*topSort{WidgetTopCPU: sortByMem}
+	topCpu, _ = rankProcesses(config, procs, "", 3)
+	if fmt.Sprint(pids(topCpu)) != "[2 3 1]" {
+		t.Errorf("Expected the CPU list sorted by memory but got %v", pids(topCpu))
+	}
+
+	config.SwapTopSorts()
+	if config.TopSort(WidgetTopCPU) != sortByCpu || config.TopSort(WidgetTopMem) != sortByCpu {
+		t.Errorf("Expected swapping to sort both lists by CPU but got %s and %s", config.TopSort(WidgetTopCPU).name, config.TopSort(WidgetTopMem).name)
+	}
+
+	// with a top list focused only its sort is swapped
+	config.Widgets = []int{WidgetTopCPU, WidgetTopMem}
+	config.focused.Store(newFocusWatcher(nil, config, WidgetTopMem))
+	config.SwapTopSorts()
+	if config.TopSort(WidgetTopCPU) != sortByCpu || config.TopSort(WidgetTopMem) != sortByMem {
+		t.Errorf("Expected swapping to sort only the focused list by memory but got %s and %s", config.TopSort(WidgetTopCPU).name, config.TopSort(WidgetTopMem).name)
+	}
+	if procs[0].Pid != 1 || procs[1].Pid != 2 {
+		t.Error("Expected ranking not to reorder the processes")
+	}
+}
+
+func TestFilterProcesses(t *testing.T) {
+	procs := []*PsProcess{
+		{User: "root", Pid: 1, Command: "launchd"},
+		{User: "alice", Pid: 10, Command: "Google Chrome Helper"},
+		{User: "alice", Pid: 11, Command: "bash"},
+		{User: "bob", Pid: 12, Command: "chromedriver"},
+	}
+
+	assertSliceEq(t, pids(filterProcesses(procs, "")), []float64{1, 10, 11, 12})
+	assertSliceEq(t, pids(filterProcesses(procs, "chrome")), []float64{10, 12})
+	assertSliceEq(t, pids(filterProcesses(procs, "ALICE")), []float64{10, 11})
+	assertSliceEq(t, pids(filterProcesses(procs, "nothing")), []float64{})
+}
+
+func TestRankDiskIO(t *testing.T) {
+	last := map[int32]procIO{
+		1: {read: 1024, write: 0},
+		2: {read: 0, write: 4096},
+		3: {read: 8192, write: 8192},
+		4: {read: 100, write: 100},
+		6: {read: 500, write: 500},
+	}
+	current := map[int32]procIO{
+		1: {read: 1024 + 2048, write: 0},
+		2: {read: 0, write: 4096 + 8192},
+		3: {read: 0, write: 8192}, // restarted with the same pid
+		5: {read: 1 << 20, write: 0},
+		6: {read: 500, write: 500}, // no IO
+	}
+
+	ranked := rankDiskIO(last, current, 2*time.Second)
+	if len(ranked) != 2 {
+		t.Fatalf("Expected 2 processes but got %d", len(ranked))
+	}
+
+	if ranked[0].Pid != 2 || ranked[1].Pid != 1 {
+		t.Errorf("Unexpected order %d, %d", ranked[0].Pid, ranked[1].Pid)
+	}
+	assertEq(t, ranked[0].ReadKiBs, 0)
+	assertEq(t, ranked[0].WriteKiBs, 4)
+	assertEq(t, ranked[1].ReadKiBs, 1)
+	assertEq(t, ranked[1].WriteKiBs, 0)
+
+	if ranked := rankDiskIO(last, current, 0); len(ranked) != 0 {
+		t.Errorf("Expected no processes without elapsed time but got %d", len(ranked))
+	}
+}
+
+func TestRankProcCounts(t *testing.T) {
+	procs := []*procCounts{
+		{Pid: 1, FDs: 10, Threads: 1},
+		{Pid: 2, FDs: -1, Threads: 40},
+		{Pid: 3, FDs: 250, Threads: 8},
+		{Pid: 4, FDs: 10, Threads: 2},
+	}
+
+	// processes whose files we couldn't count go last, ties are broken by pid
+	cases := []struct {
+		byFDs bool
+		pids  []int32
+	}{
+		{true, []int32{3, 1, 4, 2}},
+		{false, []int32{2, 3, 4, 1}},
+	}
+	for _, c := range cases {
+		for i, proc := range rankProcCounts(procs, c.byFDs) {
+			if proc.Pid != c.pids[i] {
+				t.Errorf("Expected pid %d at %d when ranking by fds %v but got %d", c.pids[i], i, c.byFDs, proc.Pid)
+			}
+		}
+	}
+}
+
+func TestIsHot(t *testing.T) {
+	if !isHot(50, 50) || !isHot(120, 50) {
+		t.Error("Expected usage at or above the threshold to be hot")
+	}
+	if isHot(49.9, 50) {
+		t.Error("Expected usage below the threshold not to be hot")
+	}
+	if isHot(100, 0) {
+		t.Error("Expected a threshold of 0 to never be hot")
+	}
+}
+
+func TestNewcomers(t *testing.T) {
+	a := &PsProcess{Pid: 1, Command: "a"}
+	b := &PsProcess{Pid: 2, Command: "b"}
+	c := &PsProcess{Pid: 3, Command: "c"}
+	start := time.Now()
+	highlight := 5 * time.Second
+
+	var seen newcomers
+	seen.Update([]*PsProcess{a, b}, sortByCpu, false, start)
+	if seen.IsNew(a, start, highlight) || seen.IsNew(b, start, highlight) {
+		t.Error("Expected nothing to be new on the first refresh")
+	}
+
+	seen.Update([]*PsProcess{c, a}, sortByCpu, false, start.Add(2*time.Second))
+	if !seen.IsNew(c, start.Add(2*time.Second), highlight) || seen.IsNew(a, start.Add(2*time.Second), highlight) {
+		t.Error("Expected only the process entering the top rows to be new")
+	}
+
+	// still highlighted while it stays in the top rows, until the highlight runs out
+	seen.Update([]*PsProcess{a, c}, sortByCpu, false, start.Add(4*time.Second))
+	if !seen.IsNew(c, start.Add(4*time.Second), highlight) || seen.IsNew(c, start.Add(7*time.Second), highlight) {
+		t.Error("Expected the newcomer to stay new for the highlight duration")
+	}
+
+	// processes which come back after dropping out are new again
+	seen.Update([]*PsProcess{a}, sortByCpu, false, start.Add(6*time.Second))
+	seen.Update([]*PsProcess{a, b}, sortByCpu, false, start.Add(8*time.Second))
+	if !seen.IsNew(b, start.Add(8*time.Second), highlight) || seen.IsNew(b, start.Add(8*time.Second), 0) {
+		t.Error("Expected the returning process to be new unless highlighting is off")
+	}
+
+	// changing the sort changes every row, so nothing is new
+	seen.Update([]*PsProcess{c, b}, sortByMem, false, start.Add(10*time.Second))
+	if seen.IsNew(c, start.Add(10*time.Second), highlight) {
+		t.Error("Expected nothing to be new after the sort changed")
+	}
+
+	// nor after grouping is turned on, when every row is a group rather than a process
+	seen.Update([]*PsProcess{a, b}, sortByMem, true, start.Add(12*time.Second))
+	if seen.IsNew(a, start.Add(12*time.Second), highlight) {
+		t.Error("Expected nothing to be new after grouping changed")
+	}
+}
+
+func TestFormatTopRows(t *testing.T) {
+	procs := []*PsProcess{
+		{Pid: 123456, CpuPerc: 250.4, Command: "make"},
+		{Pid: 1, CpuPerc: 99.6, Command: "launchd"},
+		{Pid: 4321, CpuPerc: 3, Command: "bash"},
+	}
+
+	rows := formatTopRows(procs, "%CPU", cpuPerc)
+	expected := []string{
+		"%CPU     PID  COMMAND\n",
+		"250%  123456  make\n",
+		"100%       1  launchd\n",
+		"  3%    4321  bash\n",
+	}
+
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows but got %d", len(expected), len(rows))
+	}
+	for i := range expected {
+		if rows[i] != expected[i] {
+			t.Errorf("Expected row %d to be %q but got %q", i, expected[i], rows[i])
+		}
+	}
+
+	// with no processes there's just the header
+	if rows := formatTopRows(nil, "%MEM", memPerc); len(rows) != 1 || rows[0] != "%MEM  PID  COMMAND\n" {
+		t.Errorf("Unexpected rows for an empty list %q", rows)
+	}
+}
+
+func TestScrollText(t *testing.T) {
+	box, err := newScrollText()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := func(n int) []*topLine {
+		lines := []*topLine{}
+		for i := 0; i < n; i++ {
+			lines = append(lines, &topLine{text: "row\n"})
+		}
+		return lines
+	}
+	pinned := []*topLine{{text: ""}, {text: "   %  PID  COMMAND\n"}}
+	box.SetLines(pinned, rows(50))
+	box.height = 11
+
+	box.Keyboard(&terminalapi.Keyboard{Key: keyboard.KeyArrowDown}, nil)
+	box.Keyboard(&terminalapi.Keyboard{Key: keyboard.KeyArrowDown}, nil)
+	if box.offset != 2 {
+		t.Errorf("Expected to scroll down 2 rows but got %d", box.offset)
+	}
+
+	// a page is however many rows fit under the pinned column names
+	box.Keyboard(&terminalapi.Keyboard{Key: keyboard.KeyPgDn}, nil)
+	if box.offset != 12 {
+		t.Errorf("Expected to scroll down a page to 12 but got %d", box.offset)
+	}
+
+	// the position survives a refresh, unless there aren't enough rows any more
+	box.SetLines(pinned, rows(50))
+	if box.offset != 12 {
+		t.Errorf("Expected a refresh to keep the scroll position but got %d", box.offset)
+	}
+	box.SetLines(pinned, rows(5))
+	if box.offset != 4 {
+		t.Errorf("Expected the scroll position to be clamped to the last row but got %d", box.offset)
+	}
+
+	box.Mouse(&terminalapi.Mouse{Button: mouse.ButtonWheelUp}, nil)
+	box.Keyboard(&terminalapi.Keyboard{Key: keyboard.KeyPgUp}, nil)
+	if box.offset != 0 {
+		t.Errorf("Expected to scroll back to the top but got %d", box.offset)
+	}
+}
+
+func TestReadNethogs(t *testing.T) {
+	out := "Adding local address: 10.0.0.2\n" +
+		"Ethernet link detected\n" +
+		"\n" +
+		"Refreshing:\n" +
+		"/usr/lib/firefox/firefox/2730/1000\t0.0820312\t0.148438\n" +
+		"unknown TCP/0/0\t0\t0\n" +
+		"\n" +
+		"Refreshing:\n" +
+		"/usr/lib/firefox/firefox/2730/1000\t1.5\t120.25\n" +
+		"/usr/bin/curl/4411/1000\t0.5\t300\n" +
+		"/usr/sbin/sshd/901/0\t0\t0\n" +
+		"\n" +
+		"Refreshing:\n"
+
+	refreshes := [][]*netProcess{}
+	if err := readNethogs(strings.NewReader(out), func(procs []*netProcess) {
+		refreshes = append(refreshes, procs)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshes) != 2 || len(refreshes[0]) != 1 || len(refreshes[1]) != 3 {
+		t.Fatalf("Expected refreshes of 1 and 3 processes but got %v", refreshes)
+	}
+
+	firefox := refreshes[0][0]
+	if firefox.Pid != 2730 || firefox.Command != "/usr/lib/firefox/firefox" {
+		t.Errorf("Expected firefox with pid 2730 but got %+v", firefox)
+	}
+	assertEq(t, firefox.SentKiBs, 0.0820312)
+	assertEq(t, firefox.RecvKiBs, 0.148438)
+
+	// processes which sent and received nothing are left out
+	ranked := rankNetIO(refreshes[1])
+	if len(ranked) != 2 || ranked[0].Pid != 4411 || ranked[1].Pid != 2730 {
+		t.Errorf("Expected curl then firefox but got %v", ranked)
+	}
+
+	for _, line := range []string{"unknown TCP/0/0\t0\t0", "/usr/bin/curl/4411/1000\t0.5", "curl\t1\t2", "/usr/bin/curl/x/1000\t1\t2"} {
+		if proc, ok := parseNethogsLine(line); ok {
+			t.Errorf("Expected %q not to parse as a process but got %+v", line, proc)
+		}
+	}
+}
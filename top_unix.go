@@ -0,0 +1,107 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Runs ps and parses its output into a list of processes.
+// The `c` modifier collapses the command column to just the executable name,
+// without it we get the full path and arguments. Minimal systems may not have
+// ps installed, in which case we list processes with gopsutil instead.
+func GetPsProcesses(ctx context.Context, fullCommand bool, timeout time.Duration) ([]*PsProcess, error) {
+	if _, err := exec.LookPath("ps"); err != nil {
+		return gopsutilProcesses(ctx, fullCommand)
+	}
+
+	args := []string{"auxc"}
+	if fullCommand {
+		args = []string{"aux"}
+	}
+
+	out, err := commandWithContext(ctx, timeout, "ps", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePsOutput(string(out))
+}
+
+// The ps columns we read, by the names ps may give them in its header. The command column goes
+// by different names in different implementations.
+var psColumns = map[string][]string{
+	"USER":    {"USER"},
+	"PID":     {"PID"},
+	"%CPU":    {"%CPU"},
+	"%MEM":    {"%MEM"},
+	"COMMAND": {"COMMAND", "CMD", "COMM", "ARGS"},
+}
+
+// Parses the output of ps aux or ps auxc. The columns vary between implementations, e.g. BSD has
+// TT and STARTED where GNU has TTY and START, so rather than assuming a layout we find each column
+// by its name in the header. The command is always the last column and may contain spaces, so it's
+// everything from the command column's field onwards.
+func parsePsOutput(out string) ([]*PsProcess, error) {
+	lines := strings.Split(out, "\n")
+	header := strings.Fields(lines[0])
+
+	columns := map[string]int{}
+	for name, aliases := range psColumns {
+		columns[name] = -1
+		for _, alias := range aliases {
+			if i := find(header, alias); i != -1 {
+				columns[name] = i
+				break
+			}
+		}
+		if columns[name] == -1 {
+			return nil, fmt.Errorf("Couldn't find the %s column in the ps header %q.\n", name, strings.TrimSpace(lines[0]))
+		}
+	}
+	if columns["COMMAND"] != len(header)-1 {
+		return nil, fmt.Errorf("Expected the command to be the last column in the ps header %q.\n", strings.TrimSpace(lines[0]))
+	}
+
+	processes := []*PsProcess{}
+
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		// skip blank or truncated lines, the command itself can be empty
+		if len(fields) < columns["COMMAND"] {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[columns["PID"]])
+		if err != nil {
+			return nil, err
+		}
+
+		cpuPerc, err := parsePerc(fields[columns["%CPU"]])
+		if err != nil {
+			return nil, err
+		}
+
+		memPerc, err := parsePerc(fields[columns["%MEM"]])
+		if err != nil {
+			return nil, err
+		}
+
+		process := &PsProcess{
+			User:    fields[columns["USER"]],
+			Pid:     pid,
+			CpuPerc: cpuPerc,
+			MemPerc: memPerc,
+			Command: strings.Join(fields[columns["COMMAND"]:], " "),
+		}
+
+		processes = append(processes, process)
+	}
+
+	return processes, nil
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePsOutput(t *testing.T) {
+	cases := map[string]string{
+		"GNU": `USER         PID %CPU %MEM    VSZ   RSS TTY      STAT START   TIME COMMAND
+root           1  0.1  0.1  19856  8508 ?        SLl  11:32   0:05 /sbin/init splash
+www-data     812 12,5  2.0 912344 81232 ?        Sl   Oct13  10:31 nginx: worker process
+`,
+		"BSD": `USER               PID  %CPU %MEM      VSZ    RSS   TT  STAT STARTED      TIME COMMAND
+root                 1   0.1  0.1 34176788   8508   ??  Ss   Mon10AM   0:05.12 /sbin/init splash
+www-data           812  12.5  2.0 35123456  81232   ??  S     2:01PM  10:31.40 nginx: worker process
+
+`,
+	}
+
+	for name, out := range cases {
+		procs, err := parsePsOutput(out)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if len(procs) != 2 {
+			t.Fatalf("%s: expected 2 processes but got %d", name, len(procs))
+		}
+
+		init, worker := procs[0], procs[1]
+		if init.User != "root" || init.Pid != 1 || init.CpuPerc != 0.1 || init.MemPerc != 0.1 || init.Command != "/sbin/init splash" {
+			t.Errorf("%s: unexpected first process %s", name, init)
+		}
+		if worker.User != "www-data" || worker.Pid != 812 || worker.CpuPerc != 12.5 || worker.MemPerc != 2 || worker.Command != "nginx: worker process" {
+			t.Errorf("%s: unexpected second process %s", name, worker)
+		}
+	}
+}
+
+func TestParsePsOutputColumns(t *testing.T) {
+	// columns in a different order with a short header, as some implementations print
+	procs, err := parsePsOutput("PID USER %MEM %CPU CMD\n42 me 1.5 3.0 sleep 10\n7 me 0.0 0.0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) != 2 || procs[0].Pid != 42 || procs[0].CpuPerc != 3 || procs[0].MemPerc != 1.5 || procs[0].Command != "sleep 10" {
+		t.Errorf("Expected the columns to be found by name but got %v", procs)
+	}
+	if procs[1].Command != "" {
+		t.Errorf("Expected an empty command but got %q", procs[1].Command)
+	}
+
+	for _, out := range []string{
+		"PID TT STAT TIME COMMAND\n1 ?? Ss 0:05 init\n",
+		"USER PID %CPU %MEM COMMAND TIME\nroot 1 0.1 0.1 init 0:05\n",
+		"",
+	} {
+		if _, err := parsePsOutput(out); err == nil || !strings.Contains(err.Error(), "ps header") {
+			t.Errorf("Expected an error about the ps header for %q but got %v", out, err)
+		}
+	}
+}
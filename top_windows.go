@@ -0,0 +1,12 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Windows has no ps, so we list processes with gopsutil instead. The timeout is only used for ps
+// on other platforms.
+func GetPsProcesses(ctx context.Context, fullCommand bool, timeout time.Duration) ([]*PsProcess, error) {
+	return gopsutilProcesses(ctx, fullCommand)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Cumulative bytes read and written by a process
+type procIO struct {
+	read  uint64
+	write uint64
+}
+
+// A process ranked by how much it read from and wrote to disk since the last sample
+type diskProcess struct {
+	Pid       int32
+	ReadKiBs  float64
+	WriteKiBs float64
+}
+
+// Ranks processes by their combined read and write throughput between two samples. Processes
+// which did no IO, only appear in one of the samples, or whose counters went backwards are left out.
+func rankDiskIO(last, current map[int32]procIO, elapsed time.Duration) []*diskProcess {
+	procs := []*diskProcess{}
+	if elapsed <= 0 {
+		return procs
+	}
+
+	for pid, io := range current {
+		prev, ok := last[pid]
+		if !ok || io.read < prev.read || io.write < prev.write || io == prev {
+			continue
+		}
+
+		procs = append(procs, &diskProcess{
+			Pid:       pid,
+			ReadKiBs:  float64(io.read-prev.read) / elapsed.Seconds() / 1024,
+			WriteKiBs: float64(io.write-prev.write) / elapsed.Seconds() / 1024,
+		})
+	}
+
+	sort.Slice(procs, func(i, j int) bool {
+		a := procs[i].ReadKiBs + procs[i].WriteKiBs
+		b := procs[j].ReadKiBs + procs[j].WriteKiBs
+		if a == b {
+			return procs[i].Pid < procs[j].Pid
+		}
+		return a > b
+	})
+
+	return procs
+}
+
+// Create a list of the processes reading and writing the most to disk, using per-process IO
+// counters. These are only available on some platforms (e.g. Linux and Windows but not MacOS),
+// otherwise we show a message rather than the list. Like the other top boxes this is refreshed
+// every top interval. Processes whose counters we aren't permitted to read are skipped.
+func newTopDiskBox(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+
+	title := cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(" Top Disk Processes (read KiB/s, write KiB/s, pid, command) ")
+
+	// we can always read our own counters if the platform supports them
+	self, err := process.NewProcessWithContext(ctx, int32(os.Getpid()))
+	if err == nil {
+		_, err = self.IOCountersWithContext(ctx)
+	}
+	if err != nil {
+		textBox.Write(" Per-process disk IO isn't available on this platform.", text.WriteReplace())
+		return makeContainer(textBox, title, config, WidgetTopDisk), nil
+	}
+
+	var last map[int32]procIO
+	var lastTime time.Time
+
+	config.goPeriodic(ctx, config.TopInterval, textErrors(textBox), func() error {
+		if config.Paused() {
+			return nil
+		}
+
+		procs, err := process.ProcessesWithContext(ctx)
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			textBox.Write(fmt.Sprintf(" Couldn't list processes: %v", err), text.WriteReplace())
+			return nil
+		}
+
+		now := time.Now()
+		current := map[int32]procIO{}
+		byPid := map[int32]*process.Process{}
+		for _, proc := range procs {
+			io, err := proc.IOCountersWithContext(ctx)
+			if err != nil {
+				continue
+			}
+			current[proc.Pid] = procIO{io.ReadBytes, io.WriteBytes}
+			byPid[proc.Pid] = proc
+		}
+
+		if last == nil {
+			last, lastTime = current, now
+			textBox.Write(" Measuring disk IO...", text.WriteReplace())
+			return nil
+		}
+
+		ranked := rankDiskIO(last, current, now.Sub(lastTime))
+		last, lastTime = current, now
+
+		lines := []string{}
+		for _, proc := range ranked[:min(config.TopRowsShown, len(ranked))] {
+			name, err := byPid[proc.Pid].NameWithContext(ctx)
+			if err != nil {
+				name = "?"
+			}
+			lines = append(lines, fmt.Sprintf("%7.0f  %7.0f  %-5d  %s\n", proc.ReadKiBs, proc.WriteKiBs, proc.Pid, name))
+		}
+
+		if len(lines) == 0 {
+			lines = append(lines, " No disk IO since the last refresh.")
+		}
+
+		textBox.Write(strings.Join(lines, ""), text.WriteReplace())
+		return nil
+	})
+
+	return makeContainer(textBox, title, config, WidgetTopDisk), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// The number of open file descriptors and threads of a process, FDs is -1 if we couldn't read it
+type procCounts struct {
+	Pid     int32
+	FDs     int32
+	Threads int32
+}
+
+// Sorts processes by open file descriptors, or by threads if byFDs is false, most first
+func rankProcCounts(procs []*procCounts, byFDs bool) []*procCounts {
+	count := func(proc *procCounts) int32 {
+		if byFDs {
+			return proc.FDs
+		}
+		return proc.Threads
+	}
+
+	sort.Slice(procs, func(i, j int) bool {
+		a, b := count(procs[i]), count(procs[j])
+		if a == b {
+			return procs[i].Pid < procs[j].Pid
+		}
+		return a > b
+	})
+
+	return procs
+}
+
+// Create a list of the processes with the most open files along with their thread counts, which
+// helps catch file descriptor leaks before they hit ulimits. Some platforms such as MacOS can't count
+// open files, in which case we rank by threads instead. Reading another user's open files usually
+// needs elevated privileges, so those processes are ranked by what we could read rather than failing.
+// Listing every process's files is fairly slow so like the other top boxes this is refreshed every top interval.
+func newTopFilesBox(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+
+	// we can always count our own files if the platform supports it
+	byFDs := false
+	if self, err := process.NewProcessWithContext(ctx, int32(os.Getpid())); err == nil {
+		_, err = self.NumFDsWithContext(ctx)
+		byFDs = err == nil
+	}
+
+	titleText := " Top Open Files Processes (files, threads, pid, command) "
+	if !byFDs {
+		titleText = " Top Thread Processes (threads, pid, command) "
+	}
+	title := cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(titleText)
+
+	config.goPeriodic(ctx, config.TopInterval, textErrors(textBox), func() error {
+		if config.Paused() {
+			return nil
+		}
+
+		procs, err := process.ProcessesWithContext(ctx)
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			textBox.Write(fmt.Sprintf(" Couldn't list processes: %v", err), text.WriteReplace())
+			return nil
+		}
+
+		counts := []*procCounts{}
+		byPid := map[int32]*process.Process{}
+		for _, proc := range procs {
+			// the process may have exited since we listed it
+			threads, err := proc.NumThreadsWithContext(ctx)
+			if err != nil {
+				continue
+			}
+
+			fds := int32(-1)
+			if byFDs {
+				if n, err := proc.NumFDsWithContext(ctx); err == nil {
+					fds = n
+				}
+			}
+
+			counts = append(counts, &procCounts{Pid: proc.Pid, FDs: fds, Threads: threads})
+			byPid[proc.Pid] = proc
+		}
+
+		ranked := rankProcCounts(counts, byFDs)
+
+		lines := []string{}
+		for _, proc := range ranked[:min(config.TopRowsShown, len(ranked))] {
+			name, err := byPid[proc.Pid].NameWithContext(ctx)
+			if err != nil {
+				name = "?"
+			}
+
+			if !byFDs {
+				lines = append(lines, fmt.Sprintf("%5d  %-5d  %s\n", proc.Threads, proc.Pid, name))
+				continue
+			}
+
+			fds := "-"
+			if proc.FDs >= 0 {
+				fds = fmt.Sprintf("%d", proc.FDs)
+			}
+			lines = append(lines, fmt.Sprintf("%5s  %5d  %-5d  %s\n", fds, proc.Threads, proc.Pid, name))
+		}
+
+		textBox.Write(strings.Join(lines, ""), text.WriteReplace())
+		return nil
+	})
+
+	return makeContainer(textBox, title, config, WidgetTopFiles), nil
+}
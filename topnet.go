@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/widgets/text"
+)
+
+// Operating systems don't count network traffic per process, so we ask nethogs, which works it out
+// by matching captured packets to the processes owning their sockets
+const nethogs = "nethogs"
+
+// A process ranked by its network throughput over nethogs' last refresh
+type netProcess struct {
+	Pid      int32
+	Command  string
+	SentKiBs float64
+	RecvKiBs float64
+}
+
+// Parses a line of nethogs' trace mode output, which is the program, its pid and its user id
+// separated by slashes, followed by the KB/s sent and received, e.g.
+//
+//	/usr/lib/firefox/firefox/2730/1000	0.0820312	0.148438
+//
+// Returns false for lines which aren't a process, e.g. traffic nethogs couldn't match to one.
+func parseNethogsLine(line string) (*netProcess, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 3 {
+		return nil, false
+	}
+
+	// the program's path can contain slashes too, so the pid is found from the end
+	parts := strings.Split(fields[0], "/")
+	if len(parts) < 3 {
+		return nil, false
+	}
+	pid, err := strconv.ParseInt(parts[len(parts)-2], 10, 32)
+	if err != nil || pid <= 0 {
+		return nil, false
+	}
+
+	sent, sentErr := strconv.ParseFloat(fields[1], 64)
+	recv, recvErr := strconv.ParseFloat(fields[2], 64)
+	if sentErr != nil || recvErr != nil {
+		return nil, false
+	}
+
+	return &netProcess{
+		Pid:      int32(pid),
+		Command:  strings.Join(parts[:len(parts)-2], "/"),
+		SentKiBs: sent,
+		RecvKiBs: recv,
+	}, true
+}
+
+// Reads nethogs' trace mode output, calling refreshed with the processes listed in each refresh.
+// Each refresh starts with a "Refreshing:" line and ends with a blank line.
+func readNethogs(out io.Reader, refreshed func([]*netProcess)) error {
+	var procs []*netProcess
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "Refreshing:":
+			if procs != nil {
+				refreshed(procs)
+			}
+			procs = []*netProcess{}
+		case line == "" && procs != nil:
+			refreshed(procs)
+			procs = nil
+		case procs != nil:
+			if proc, ok := parseNethogsLine(line); ok {
+				procs = append(procs, proc)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Ranks processes by their combined sent and received throughput, leaving out those which sent
+// and received nothing
+func rankNetIO(procs []*netProcess) []*netProcess {
+	ranked := []*netProcess{}
+	for _, proc := range procs {
+		if proc.SentKiBs+proc.RecvKiBs > 0 {
+			ranked = append(ranked, proc)
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a := ranked[i].SentKiBs + ranked[i].RecvKiBs
+		b := ranked[j].SentKiBs + ranked[j].RecvKiBs
+		if a == b {
+			return ranked[i].Pid < ranked[j].Pid
+		}
+		return a > b
+	})
+	return ranked
+}
+
+// Create a list of the processes sending and receiving the most over the network, which finds
+// what's eating the bandwidth. This runs nethogs in the background until the context's done, which
+// getWidgets does once the widget's removed, so needs nethogs installed and usually root or its capabilities, otherwise we show why
+// rather than the list. Like the other top boxes this is redrawn every top interval, which is also
+// how often nethogs is asked to refresh, to the nearest second.
+func newTopNetBox(ctx context.Context, config *PoptopConfig) ([]container.Option, error) {
+	textBox, err := text.New()
+	if err != nil {
+		return nil, err
+	}
+
+	title := cell.NewRichTextString(ColorWidgetTitle).
+		AddOpt(cell.Bold()).
+		AddText(" Top Network Processes (sent KiB/s, recv KiB/s, pid, command) ")
+
+	if _, err := exec.LookPath(nethogs); err != nil {
+		textBox.Write(fmt.Sprintf(" Per-process network IO needs %s, which isn't in your PATH.", nethogs), text.WriteReplace())
+		return makeContainer(textBox, title, config, WidgetTopNet), nil
+	}
+
+	var mu sync.Mutex
+	var latest []*netProcess // nil until nethogs has refreshed once
+	var failure string       // why nethogs exited, once it has
+
+	delay := int(math.Max(1, math.Round(config.TopInterval.Seconds())))
+	config.spawn(func() {
+		cmd := exec.CommandContext(ctx, nethogs, "-t", "-d", strconv.Itoa(delay))
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err == nil {
+			readNethogs(stdout, func(procs []*netProcess) {
+				mu.Lock()
+				defer mu.Unlock()
+				latest = procs
+			})
+			err = cmd.Wait()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// nethogs' own complaint, e.g. that it needs root, says more than its exit status
+		if lines := strings.Split(strings.TrimSpace(stderr.String()), "\n"); lines[len(lines)-1] != "" {
+			err = fmt.Errorf("%s", lines[len(lines)-1])
+		}
+		exited := fmt.Sprintf(" %s exited", nethogs)
+		if err != nil {
+			exited += fmt.Sprintf(": %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		failure = exited + "\n It usually needs root, or the cap_net_admin and cap_net_raw capabilities."
+	})
+
+	config.goPeriodic(ctx, config.TopInterval, textErrors(textBox), func() error {
+		if config.Paused() {
+			return nil
+		}
+
+		mu.Lock()
+		procs, failed := latest, failure
+		mu.Unlock()
+
+		if failed != "" {
+			textBox.Write(failed, text.WriteReplace())
+			return nil
+		}
+		if procs == nil {
+			textBox.Write(" Measuring network IO...", text.WriteReplace())
+			return nil
+		}
+
+		ranked := rankNetIO(procs)
+		lines := []string{}
+		for _, proc := range ranked[:min(config.TopRowsShown, len(ranked))] {
+			command := proc.Command
+			if !config.FullCommand {
+				command = filepath.Base(command)
+			}
+			lines = append(lines, fmt.Sprintf("%7.1f  %7.1f  %-5d  %s\n", proc.SentKiBs, proc.RecvKiBs, proc.Pid, command))
+		}
+
+		if len(lines) == 0 {
+			lines = append(lines, " No network IO since the last refresh.")
+		}
+		textBox.Write(strings.Join(lines, ""), text.WriteReplace())
+		return nil
+	})
+
+	return makeContainer(textBox, title, config, WidgetTopNet), nil
+}